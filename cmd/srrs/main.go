@@ -3,14 +3,19 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
 	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/recording"
+	"github.com/rvolosatovs/turtlitto/pkg/turtleinfo"
 	"github.com/rvolosatovs/turtlitto/pkg/webapi"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -31,8 +36,69 @@ var (
 	tcpSock  = flag.String("tcpSocket", "", "Internal TCP socket address. TRC <-> SRRS communication will use this TCP socket instead of a Unix socket when set")
 	certPath = flag.String("cert", "", "Path to the authentication certificate")
 	keyPath  = flag.String("key", "", "Path to the private key of the certificate")
+	recDir   = flag.String("recordDir", "", "Path to the directory, where TRC protocol sessions are recorded. Sessions are not recorded when empty")
+	advisory = flag.Bool("advisory", false, "Send commands not allowed in the current phase of the game to TRC instead of rejecting them")
+
+	strictTeam = flag.Bool("strictTeam", false, "Reject turtle updates, which violate team consistency rules, instead of reporting them as warnings")
+
+	turtleInfoPath = flag.String("turtleInfo", "", "Path to the JSON file, where the turtle registry is persisted. The registry is only kept in memory when empty. If multiple TRC's are configured, the registry of each TRC is persisted separately, in a file with the TRC name inserted before the extension")
+	rosterFlag     = flag.String("roster", strings.Join(api.DefaultRoster, ","), "Comma-separated IDs of turtles initially known to SRRS. TRC may add and remove turtles at runtime")
+
+	fieldLength = flag.Float64("fieldLength", api.DefaultFieldDimensions().Length, "Length of the field in meters, against which turtle and ball positions are validated")
+	fieldWidth  = flag.Float64("fieldWidth", api.DefaultFieldDimensions().Width, "Width of the field in meters, against which turtle and ball positions are validated")
+	fieldMargin = flag.Float64("fieldMargin", api.DefaultFieldDimensions().Margin, "Width of the area outside of the field lines in meters, where turtles and the ball may be located")
+
+	trcs trcFlag
 )
 
+func init() {
+	flag.Var(&trcs, "trc", "Named TRC to connect to in form name=unix:path or name=tcp:address. May be repeated. When set, unixSocket and tcpSocket are ignored and web API endpoints are scoped by TRC name")
+}
+
+// trcSpec specifies a named TRC to connect to.
+type trcSpec struct {
+	name    string
+	network string
+	addr    string
+}
+
+// trcFlag is a flag.Value, which collects trcSpec's.
+type trcFlag []trcSpec
+
+// String implements flag.Value.
+func (f *trcFlag) String() string {
+	ss := make([]string, 0, len(*f))
+	for _, spec := range *f {
+		ss = append(ss, fmt.Sprintf("%s=%s:%s", spec.name, spec.network, spec.addr))
+	}
+	return strings.Join(ss, ",")
+}
+
+// Set implements flag.Value.
+func (f *trcFlag) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i < 0 {
+		return errors.Errorf("expected name=network:address, got %s", v)
+	}
+	name, sock := v[:i], v[i+1:]
+
+	i = strings.Index(sock, ":")
+	if i < 0 {
+		return errors.Errorf("expected network:address, got %s", sock)
+	}
+	network, addr := sock[:i], sock[i+1:]
+	if network != "unix" && network != "tcp" {
+		return errors.Errorf("network must be either unix or tcp, got %s", network)
+	}
+
+	*f = append(*f, trcSpec{
+		name:    name,
+		network: network,
+		addr:    addr,
+	})
+	return nil
+}
+
 func main() {
 	flag.Parse()
 
@@ -54,84 +120,72 @@ func main() {
 	if err := func() error {
 		defer logger.Sync() //nolint
 
-		pool := trcapi.NewPool(func() (*trcapi.Conn, func(), error) {
-			var netConn net.Conn
-			if *tcpSock == "" {
-				logger := logger.With(zap.String("trc_socket_unix", *unixSock))
+		field := api.FieldDimensions{
+			Length: *fieldLength,
+			Width:  *fieldWidth,
+			Margin: *fieldMargin,
+		}
+		if err := field.Validate(); err != nil {
+			return errors.Wrap(err, "invalid field dimensions")
+		}
 
-				var err error
-				logger.Debug("Dialing Unix socket...")
-				netConn, err = net.Dial("unix", *unixSock)
-				if err != nil {
-					return nil, nil, errors.Wrapf(err, "Failed to connect to TRC's unix socket")
-				}
-				logger.Debug("Unix socket dial succeeded")
-			} else {
-				logger := logger.With(zap.String("trc_socket_tcp", *tcpSock))
+		roster, err := trcapi.ParseRoster(*rosterFlag)
+		if err != nil {
+			return errors.Wrap(err, "invalid roster")
+		}
 
-				var err error
-				logger.Debug("Dialing TCP socket...")
-				netConn, err = net.Dial("tcp", *tcpSock)
-				if err != nil {
-					return nil, nil, errors.Wrapf(err, "Failed to connect to TRC's TCP socket")
-				}
-				logger.Debug("TCP socket dial succeeded")
-			}
+		mux := http.DefaultServeMux
 
-			logger.Debug("Initializing TRC protocol connection on socket...")
-			trcConn, err := trcapi.Connect(trcapi.DefaultVersion, netConn, netConn)
-			if err != nil {
-				return nil, nil, errors.Wrapf(err, "Failed to establish connection to TRC")
+		opts := []webapi.Option{webapi.WithFieldDimensions(field)}
+		if *advisory {
+			opts = append(opts, webapi.WithAdvisoryPhases())
+		}
+		if *strictTeam {
+			opts = append(opts, webapi.WithStrictTeamValidation())
+		}
+		if len(trcs) == 0 {
+			network, addr := "unix", *unixSock
+			if *tcpSock != "" {
+				network, addr = "tcp", *tcpSock
 			}
-			logger.Debug("TRC protocol connection initialized")
-
-			go func() {
-				var next time.Time
-				for {
-					next = time.Now().Add(5 * time.Second)
-
-					ctx, cancel := context.WithDeadline(context.Background(), next)
-					defer cancel()
-
-					if err := trcConn.Ping(ctx); err != nil {
-						logger.Error("Failed to ping TRC",
-							zap.Error(err),
-						)
-
-						if err := trcConn.Close(); err != nil {
-							logger.Error("Failed to close TRC",
-								zap.Error(err),
-							)
-						}
-						return
-					}
 
-					select {
-					case <-trcConn.Closed():
-						return
+			pool := newPool(logger, "trc", network, addr, roster, field)
+			defer pool.Close()
 
-					case <-time.After(time.Until(next)):
-					}
+			if *turtleInfoPath != "" {
+				info, err := openTurtleInfo(logger, *turtleInfoPath)
+				if err != nil {
+					return err
 				}
-			}()
+				opts = append(opts, webapi.WithTurtleInfo(info))
+			}
 
-			return trcConn, func() {
-				logger.Debug("Closing TRC connection...")
-				if err := trcConn.Close(); err != nil {
-					logger.With(zap.Error(err)).Error("Failed to close TRC connection")
-				}
+			webapi.RegisterHandlers(pool, mux, opts...)
+		} else {
+			reg := trcapi.NewRegistry()
+			defer reg.Close()
 
-				logger.Debug("Closing socket...")
-				if err := netConn.Close(); err != nil {
-					logger.With(zap.Error(err)).Error("Failed to close socket")
+			for _, trc := range trcs {
+				logger.Info("Registering TRC...",
+					zap.String("trc", trc.name),
+					zap.String("network", trc.network),
+					zap.String("addr", trc.addr),
+				)
+				if err := reg.AddPool(trc.name, newPool(logger.With(zap.String("trc", trc.name)), trc.name, trc.network, trc.addr, roster, field)); err != nil {
+					return errors.Wrap(err, "failed to register TRC")
 				}
-			}, nil
-		})
-		defer pool.Close()
 
-		mux := http.DefaultServeMux
+				if *turtleInfoPath != "" {
+					info, err := openTurtleInfo(logger, scopedTurtleInfoPath(*turtleInfoPath, trc.name))
+					if err != nil {
+						return err
+					}
+					opts = append(opts, webapi.WithTRCOptions(trc.name, webapi.WithTurtleInfo(info)))
+				}
+			}
 
-		webapi.RegisterHandlers(pool, mux)
+			webapi.RegisterRegistryHandlers(reg, mux, opts...)
+		}
 		if *static != "" {
 			mux.Handle("/", http.FileServer(http.Dir(*static)))
 		}
@@ -191,3 +245,129 @@ func main() {
 		logger.With(zap.Error(err)).Fatal("SRRS failed")
 	}
 }
+
+// openTurtleInfo opens the turtle registry persisted at path.
+func openTurtleInfo(logger *zap.Logger, path string) (*turtleinfo.Store, error) {
+	logger.Info("Opening turtle registry...", zap.String("path", path))
+	info, err := turtleinfo.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open turtle registry at %s", path)
+	}
+	return info, nil
+}
+
+// scopedTurtleInfoPath returns the path, where the turtle registry of the TRC named name is persisted,
+// given the path configured by the turtleInfo flag, e.g. turtles.magenta.json for turtles.json.
+func scopedTurtleInfoPath(path, name string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + name + ext
+}
+
+// newPool returns a new *trcapi.Pool, which connects to the TRC named name listening on addr of network.
+// network must be either "unix" or "tcp". Connections initially know the turtles in roster
+// and validate positions against field.
+func newPool(logger *zap.Logger, name, network, addr string, roster []string, field api.FieldDimensions) *trcapi.Pool {
+	return trcapi.NewPool(func() (*trcapi.Conn, func(), error) {
+		var netConn net.Conn
+		if network == "unix" {
+			logger := logger.With(zap.String("trc_socket_unix", addr))
+
+			var err error
+			logger.Debug("Dialing Unix socket...")
+			netConn, err = net.Dial("unix", addr)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "Failed to connect to TRC's unix socket")
+			}
+			logger.Debug("Unix socket dial succeeded")
+		} else {
+			logger := logger.With(zap.String("trc_socket_tcp", addr))
+
+			var err error
+			logger.Debug("Dialing TCP socket...")
+			netConn, err = net.Dial("tcp", addr)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "Failed to connect to TRC's TCP socket")
+			}
+			logger.Debug("TCP socket dial succeeded")
+		}
+
+		opts := []trcapi.Option{trcapi.WithRoster(roster...), trcapi.WithFieldDimensions(field)}
+		closeRec := func() {}
+		if *recDir != "" {
+			recPath := filepath.Join(*recDir, fmt.Sprintf("%s-%s.trcrec", name, time.Now().Format("20060102T150405")))
+			logger := logger.With(zap.String("path", recPath))
+
+			logger.Debug("Creating recording file...")
+			f, err := os.Create(recPath)
+			if err != nil {
+				netConn.Close()
+				return nil, nil, errors.Wrap(err, "Failed to create recording file")
+			}
+
+			rec := recording.NewWriter(f)
+			opts = append(opts, trcapi.WithRecorder(rec))
+			closeRec = func() {
+				logger.Debug("Closing recording...")
+				if err := rec.Close(); err != nil {
+					logger.With(zap.Error(err)).Error("Failed to close recording")
+				}
+				if err := f.Close(); err != nil {
+					logger.With(zap.Error(err)).Error("Failed to close recording file")
+				}
+			}
+		}
+
+		logger.Debug("Initializing TRC protocol connection on socket...")
+		trcConn, err := trcapi.Connect(trcapi.DefaultVersion, netConn, netConn, opts...)
+		if err != nil {
+			closeRec()
+			netConn.Close()
+			return nil, nil, errors.Wrapf(err, "Failed to establish connection to TRC")
+		}
+		logger.Debug("TRC protocol connection initialized")
+
+		go func() {
+			var next time.Time
+			for {
+				next = time.Now().Add(5 * time.Second)
+
+				ctx, cancel := context.WithDeadline(context.Background(), next)
+				defer cancel()
+
+				if err := trcConn.Ping(ctx); err != nil {
+					logger.Error("Failed to ping TRC",
+						zap.Error(err),
+					)
+
+					if err := trcConn.Close(); err != nil {
+						logger.Error("Failed to close TRC",
+							zap.Error(err),
+						)
+					}
+					return
+				}
+
+				select {
+				case <-trcConn.Closed():
+					return
+
+				case <-time.After(time.Until(next)):
+				}
+			}
+		}()
+
+		return trcConn, func() {
+			logger.Debug("Closing TRC connection...")
+			if err := trcConn.Close(); err != nil {
+				logger.With(zap.Error(err)).Error("Failed to close TRC connection")
+			}
+
+			logger.Debug("Closing socket...")
+			if err := netConn.Close(); err != nil {
+				logger.With(zap.Error(err)).Error("Failed to close socket")
+			}
+
+			closeRec()
+		}, nil
+	})
+}
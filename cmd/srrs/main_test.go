@@ -18,6 +18,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rvolosatovs/turtlitto/pkg/api"
 	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	"github.com/rvolosatovs/turtlitto/pkg/api/schema"
+	"github.com/rvolosatovs/turtlitto/pkg/srrstest"
 	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
 	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
 	"github.com/rvolosatovs/turtlitto/pkg/webapi"
@@ -126,6 +128,8 @@ func TestMain(m *testing.M) {
 func TestAPI(t *testing.T) {
 	a := assert.New(t)
 
+	g := apitest.NewTestGenerator(t)
+
 	handshake := &api.Handshake{
 		Version: trcapi.DefaultVersion,
 		Token:   "test3",
@@ -133,28 +137,21 @@ func TestAPI(t *testing.T) {
 
 	var sessionKey string
 
+	cl := srrstest.NewClient("http://" + defaultTCPAddress)
+
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		req, err := http.NewRequest(http.MethodGet, "http://"+defaultTCPAddress+"/"+webapi.AuthEndpoint, nil)
-		a.NoError(err)
-		req.SetBasicAuth("", handshake.Token)
-
 		logger.Debug("Sending authentication request...")
-		resp, err := http.DefaultClient.Do(req)
-		if !a.NoError(err) {
-			logger.With("error", err).Error("Failed to authenticate")
+		if !a.NoError(cl.Auth(handshake.Token)) {
+			logger.Error("Failed to authenticate")
 			return
 		}
-		defer resp.Body.Close()
 
-		b, err := ioutil.ReadAll(resp.Body)
-		a.NoError(err)
-
-		logger.With("key", string(b)).Debug("Got session key")
-		sessionKey = string(b)
+		sessionKey = cl.SessionKey()
+		logger.With("key", sessionKey).Debug("Got session key")
 	}()
 
 	msgCh := make(chan *api.Message)
@@ -184,6 +181,7 @@ func TestAPI(t *testing.T) {
 				msgCh <- msg
 				return trctest.DefaultStateHandler(msg)
 			}),
+			trctest.WithHandler(api.MessageTypeToken, trctest.DefaultTokenHandler),
 		)
 		return trc, func() { unixConn.Close() }, trc.SendHandshake(handshake)
 	}
@@ -220,7 +218,7 @@ func TestAPI(t *testing.T) {
 			t.Run(strconv.Itoa(i), func(t *testing.T) {
 				a = assert.New(t)
 
-				expected := apitest.RandomState()
+				expected := g.State()
 				if err := expected.Validate(); err != nil {
 					panic(errors.Wrap(err, "invalid state generated"))
 				}
@@ -229,7 +227,7 @@ func TestAPI(t *testing.T) {
 				err = trc.SendState(expected)
 				a.NoError(err)
 
-				var got api.State
+				var got webapi.State
 				logger.Debug("Receiving random state on WebSocket...")
 				err = wsConn.ReadJSON(&got)
 				a.NoError(err)
@@ -244,10 +242,14 @@ func TestAPI(t *testing.T) {
 				a = assert.New(t)
 
 				expected := &api.State{
-					Turtles: apitest.RandomTurtleStateMap(),
+					Turtles: g.TurtleStateMap(),
 				}
 				for len(expected.Turtles) == 0 {
-					expected.Turtles = apitest.RandomTurtleStateMap()
+					expected.Turtles = g.TurtleStateMap()
+				}
+				// Only settings may be written by SRRC.
+				for id, ts := range expected.Turtles {
+					expected.Turtles[id] = ts.Writable()
 				}
 				if err := expected.Validate(); err != nil {
 					panic(errors.Wrap(err, "invalid state generated"))
@@ -316,13 +318,45 @@ func TestAPI(t *testing.T) {
 		}
 	})
 
+	t.Run("SRRC->TRC/turtles/invalid", func(t *testing.T) {
+		a = assert.New(t)
+
+		err := cl.SetTurtles(map[string]*api.TurtleState{
+			"1": {
+				BatteryVoltage: apitest.Uint8Ptr(42),
+				TeamColor:      "green",
+			},
+		})
+		serr, ok := errors.Cause(err).(*srrstest.StatusError)
+		if !a.True(ok, "unexpected error: %v", err) {
+			t.FailNow()
+		}
+		a.Equal(http.StatusBadRequest, serr.Code)
+		a.NotEmpty(serr.Message)
+
+		reasons := make(map[string]api.ValidationReason, len(serr.Violations))
+		for _, v := range serr.Violations {
+			reasons[v.Path] = v.Reason
+		}
+		a.Equal(map[string]api.ValidationReason{
+			`turtles["1"].batteryvoltage`: api.ValidationReasonReadOnly,
+			`turtles["1"].teamcolor`:      api.ValidationReasonInvalidValue,
+		}, reasons)
+	})
+
 	t.Run("SRRC->TRC/command", func(t *testing.T) {
+		// Commands must be valid in the phase of the game resulting from the previous one.
+		commands := []api.Command{
+			api.CommandKickOffMagenta,
+			api.CommandStart,
+			api.CommandStop,
+		}
 		for i := 0; i < messageCount; i++ {
 			t.Run(strconv.Itoa(i), func(t *testing.T) {
 				a = assert.New(t)
 
 				expected := &api.State{
-					Command: apitest.RandomCommand(),
+					Command: commands[i%len(commands)],
 				}
 
 				b, err := json.Marshal(expected.Command)
@@ -388,4 +422,160 @@ func TestAPI(t *testing.T) {
 			})
 		}
 	})
+	t.Run("SRRC/match", func(t *testing.T) {
+		a = assert.New(t)
+
+		expected := &api.MatchUpdate{
+			Score: &api.Score{
+				Magenta: 2,
+				Cyan:    1,
+			},
+			Period: api.PeriodSecondHalf,
+		}
+
+		if !a.NoError(cl.UpdateMatch(expected)) {
+			t.FailNow()
+		}
+
+		deadline := time.Now().Add(timeout)
+		for {
+			if !a.NoError(wsConn.SetReadDeadline(deadline)) {
+				t.FailNow()
+			}
+
+			var got webapi.State
+			if !a.NoError(wsConn.ReadJSON(&got)) {
+				t.FailNow()
+			}
+			if got.Match == nil || got.Match.Score != *expected.Score {
+				continue
+			}
+			a.Equal(expected.Period, got.Match.Period)
+			a.False(got.Match.ClockRunning)
+			a.Zero(got.Match.Clock)
+			return
+		}
+	})
+
+	t.Run("SRRC/turtle_info", func(t *testing.T) {
+		a = assert.New(t)
+
+		expected := map[string]*api.TurtleInfo{
+			"3": {
+				Name:             "Raphael",
+				Serial:           "TU-0003",
+				Generation:       2,
+				BatteryChemistry: api.BatteryChemistryLiPo,
+			},
+		}
+
+		infos, err := cl.UpdateTurtleInfo(expected)
+		if !a.NoError(err) {
+			t.FailNow()
+		}
+		a.Equal(expected, infos)
+
+		deadline := time.Now().Add(timeout)
+		for {
+			if !a.NoError(wsConn.SetReadDeadline(deadline)) {
+				t.FailNow()
+			}
+
+			var got webapi.State
+			if !a.NoError(wsConn.ReadJSON(&got)) {
+				t.FailNow()
+			}
+			if got.TurtleInfo == nil {
+				continue
+			}
+			a.Equal(expected, got.TurtleInfo)
+			break
+		}
+
+		// Requests without the session key are rejected.
+		_, err = srrstest.NewClient(cl.URL).TurtleInfo()
+		a.Equal(http.StatusUnauthorized, srrstest.StatusCode(err))
+	})
+
+	t.Run("SRRC->TRC/command/rejected", func(t *testing.T) {
+		a = assert.New(t)
+
+		go func() {
+			select {
+			case <-msgCh:
+			case <-time.After(timeout):
+			}
+		}()
+
+		if !a.NoError(cl.SendCommand(api.CommandStart)) {
+			t.FailNow()
+		}
+
+		err := cl.SendCommand(api.CommandKickOffCyan)
+		a.Equal(http.StatusBadRequest, srrstest.StatusCode(err))
+		if a.Error(err) {
+			a.Contains(err.Error(), "not allowed in phase running")
+		}
+
+		select {
+		case msg := <-msgCh:
+			t.Errorf("Rejected command was sent to TRC: %s", msg.Payload)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+	t.Run("SRRC/commands", func(t *testing.T) {
+		a = assert.New(t)
+
+		got, err := cl.Commands()
+		a.NoError(err)
+		a.Equal(api.ListCommands(), got)
+	})
+
+	t.Run("SRRC/schema", func(t *testing.T) {
+		a = assert.New(t)
+
+		b, err := cl.Schema("state")
+		a.NoError(err)
+
+		expected, err := schema.Marshal(api.State{})
+		a.NoError(err)
+		a.Equal(string(expected), string(b))
+
+		_, err = cl.Schema("unknown")
+		a.Equal(http.StatusNotFound, srrstest.StatusCode(err))
+	})
+
+	t.Run("TRC->SRRC/token", func(t *testing.T) {
+		a = assert.New(t)
+
+		logger.Debug("Sending token update from TRC...")
+		err := trc.SendToken("test4")
+		a.NoError(err)
+
+		logger.Debug("Waiting for WebSocket to be closed...")
+		a.NoError(wsConn.SetReadDeadline(time.Now().Add(timeout)))
+		for {
+			_, _, err = wsConn.NextReader()
+			if err != nil {
+				break
+			}
+		}
+		a.True(websocket.IsCloseError(err, webapi.CloseTokenRevoked), "unexpected error: %s", err)
+
+		err = srrstest.NewClient(cl.URL).Auth(handshake.Token)
+		a.Equal(http.StatusUnauthorized, srrstest.StatusCode(err))
+
+		a.NoError(srrstest.NewClient(cl.URL).Auth("test4"))
+	})
+}
+
+//Test_items: scopedTurtleInfoPath() in main.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestScopedTurtleInfoPath(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("/var/lib/srrs/turtles.magenta.json", scopedTurtleInfoPath("/var/lib/srrs/turtles.json", "magenta"))
+	a.Equal("turtles.cyan", scopedTurtleInfoPath("turtles", "cyan"))
 }
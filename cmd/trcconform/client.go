@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+)
+
+// errSkipped is returned by cases, which are not applicable to the TRC under test.
+type errSkipped string
+
+// Error implements error.
+func (err errSkipped) Error() string {
+	return string(err)
+}
+
+// errTimeout is returned by receive if no matching message is received within the timeout.
+type errTimeout time.Duration
+
+// Error implements error.
+func (err errTimeout) Error() string {
+	return fmt.Sprintf("no matching message received within %s", time.Duration(err))
+}
+
+// isTimeout reports whether the cause of err is errTimeout.
+func isTimeout(err error) bool {
+	_, ok := errors.Cause(err).(errTimeout)
+	return ok
+}
+
+// received is a message received from TRC.
+type received struct {
+	msg *api.Message
+	err error
+}
+
+// client is the SRRS side of a connection to the TRC under test.
+type client struct {
+	netConn net.Conn
+	encoder *json.Encoder
+	timeout time.Duration
+	// pingWait is the duration to wait for a TRC-initiated ping.
+	pingWait time.Duration
+	// gen generates the random values sent to TRC.
+	gen *apitest.Generator
+
+	recvCh chan received
+	// pending holds messages received while waiting for a different one.
+	pending []*api.Message
+}
+
+// newClient wraps netConn and starts reading messages from it.
+// Messages are decoded leniently, so that the TRC under test is not penalized for decoding failures of the suite.
+func newClient(netConn net.Conn, conf Config) *client {
+	cl := &client{
+		netConn:  netConn,
+		encoder:  json.NewEncoder(netConn),
+		timeout:  conf.Timeout,
+		pingWait: conf.PingWait,
+		gen:      apitest.NewGenerator(apitest.WithSeed(conf.Seed)),
+		recvCh:   make(chan received, 64),
+	}
+
+	go func() {
+		defer close(cl.recvCh)
+
+		dec := json.NewDecoder(netConn)
+		for {
+			var msg api.Message
+			if err := dec.Decode(&msg); err != nil {
+				cl.recvCh <- received{err: err}
+				return
+			}
+			cl.recvCh <- received{msg: &msg}
+		}
+	}()
+	return cl
+}
+
+// Close closes the underlying connection.
+func (cl *client) Close() error {
+	return cl.netConn.Close()
+}
+
+// send sends msg to TRC.
+func (cl *client) send(msg *api.Message) error {
+	if err := cl.netConn.SetWriteDeadline(time.Now().Add(cl.timeout)); err != nil {
+		return err
+	}
+	return errors.Wrap(cl.encoder.Encode(msg), "failed to send message")
+}
+
+// sendRaw sends b followed by a newline to TRC as-is.
+func (cl *client) sendRaw(b []byte) error {
+	if err := cl.netConn.SetWriteDeadline(time.Now().Add(cl.timeout)); err != nil {
+		return err
+	}
+	_, err := cl.netConn.Write(append(b, '\n'))
+	return errors.Wrap(err, "failed to send raw message")
+}
+
+// sendRequest sends a request of type typ with payload pld and returns it.
+func (cl *client) sendRequest(typ api.MessageType, pld interface{}) (*api.Message, error) {
+	var b json.RawMessage
+	if pld != nil {
+		var err error
+		b, err = json.Marshal(pld)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	msg := api.NewMessage(typ, b, nil)
+	return msg, cl.send(msg)
+}
+
+// receive returns the first message matching f received within timeout.
+// Messages not matching f are kept for subsequent calls.
+func (cl *client) receive(timeout time.Duration, f func(*api.Message) bool) (*api.Message, error) {
+	for i, msg := range cl.pending {
+		if f(msg) {
+			cl.pending = append(cl.pending[:i], cl.pending[i+1:]...)
+			return msg, nil
+		}
+	}
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case <-deadline:
+			return nil, errTimeout(timeout)
+
+		case r, ok := <-cl.recvCh:
+			if !ok {
+				return nil, io.EOF
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+			if f(r.msg) {
+				return r.msg, nil
+			}
+			cl.pending = append(cl.pending, r.msg)
+		}
+	}
+}
+
+// receiveResponse returns the response to the request identified by id.
+func (cl *client) receiveResponse(id ulid.ULID) (*api.Message, error) {
+	msg, err := cl.receive(cl.timeout, func(msg *api.Message) bool {
+		return msg.ParentID != nil && *msg.ParentID == id
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to receive response to %s", id)
+	}
+	return msg, nil
+}
+
+// expectNoResponse returns an error if a response to the request identified by id is received within timeout.
+// Closing the connection is considered a valid way of rejecting the request.
+func (cl *client) expectNoResponse(id ulid.ULID) error {
+	return cl.expectRejected(func(msg *api.Message) bool {
+		return msg.ParentID != nil && *msg.ParentID == id
+	})
+}
+
+// expectRejected returns an error if a message matching f is received within timeout.
+// If TRC keeps the connection open, expectRejected additionally requires it to still respond to a ping afterwards.
+// Closing the connection is considered a valid way of rejecting the request, sending malformed data is not.
+func (cl *client) expectRejected(f func(*api.Message) bool) error {
+	msg, err := cl.receive(cl.timeout, f)
+	switch {
+	case err == nil:
+		return errors.Errorf("unexpected response of type %s received", msg.Type)
+	case isClosed(err):
+		return nil
+	case !isTimeout(err):
+		return errors.Wrap(err, "TRC sent malformed data")
+	}
+
+	if err := expectPong(cl); err != nil {
+		if isClosed(err) {
+			return nil
+		}
+		return errors.Wrap(err, "TRC must stay responsive after rejecting a request")
+	}
+	return nil
+}
+
+// handshake performs the handshake responding with version returned by ver given the version requested by TRC.
+func (cl *client) handshake(ver func(semver.Version) semver.Version) (*api.Handshake, error) {
+	req, err := cl.receive(cl.timeout, func(msg *api.Message) bool { return true })
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to receive handshake request")
+	}
+
+	switch {
+	case req.Type != api.MessageTypeHandshake:
+		return nil, errors.Errorf("expected initial message of type %s, got %s", api.MessageTypeHandshake, req.Type)
+	case req.ParentID != nil:
+		return nil, errors.New("initial message is a response, while a request was expected")
+	case len(req.Payload) == 0:
+		return nil, errors.New("handshake payload is empty")
+	}
+
+	var hs api.Handshake
+	if err := decodeStrict(req.Payload, &hs); err != nil {
+		return nil, errors.Wrap(err, "failed to decode handshake payload")
+	}
+
+	b, err := json.Marshal(&api.Handshake{
+		Version: ver(hs.Version),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := cl.send(api.NewMessage(api.MessageTypeHandshake, b, &req.MessageID)); err != nil {
+		return nil, err
+	}
+	return &hs, nil
+}
+
+// sameVersion returns v.
+func sameVersion(v semver.Version) semver.Version {
+	return v
+}
+
+// decodeStrict decodes b into v disallowing unknown fields.
+func decodeStrict(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
@@ -0,0 +1,91 @@
+// Command trcconform runs a protocol conformance test suite against an arbitrary TRC implementation.
+//
+// trcconform acts as SRRS: it dials the TRC once per test case and reports
+// the results as text on stdout and, optionally, as JUnit XML.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var (
+	unixSock  = flag.String("unixSocket", filepath.Join(os.TempDir(), "trc.sock"), "Path to the unix socket of TRC")
+	tcpSock   = flag.String("tcpSocket", "", "Service address of TCP socket of TRC. TCP will be used instead of a Unix socket when this is set")
+	timeout   = flag.Duration("timeout", time.Second, "Duration to wait for a response from TRC")
+	pingWait  = flag.Duration("pingWait", 10*time.Second, "Duration to wait for a TRC-initiated ping")
+	junitPath = flag.String("junit", "", "Path to write the JUnit XML report to. The report is not written when empty")
+	run       = flag.String("run", "", "Run only cases with names matching the regular expression")
+	seed      = flag.Int64("seed", 0, "Seed of the random values sent to TRC. A time-based seed is used when 0")
+)
+
+func main() {
+	flag.Parse()
+
+	network, addr := "unix", *unixSock
+	if *tcpSock != "" {
+		network, addr = "tcp", *tcpSock
+	}
+
+	cases := Suite
+	if *run != "" {
+		re, err := regexp.Compile(*run)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -run expression: %s\n", err)
+			os.Exit(2)
+		}
+
+		cases = nil
+		for _, c := range Suite {
+			if re.MatchString(c.Name) {
+				cases = append(cases, c)
+			}
+		}
+	}
+
+	conf := Config{
+		Timeout:  *timeout,
+		PingWait: *pingWait,
+		Seed:     *seed,
+	}
+	if conf.Seed == 0 {
+		conf.Seed = time.Now().UnixNano()
+	}
+
+	rs := Run(cases, func() (net.Conn, error) {
+		return net.DialTimeout(network, addr, *timeout)
+	}, conf)
+
+	if err := WriteText(os.Stdout, conf.Seed, rs); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write report: %s\n", err)
+		os.Exit(2)
+	}
+
+	if *junitPath != "" {
+		f, err := os.Create(*junitPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create JUnit report: %s\n", err)
+			os.Exit(2)
+		}
+		if err := WriteJUnit(f, conf.Seed, rs); err != nil {
+			f.Close()
+			fmt.Fprintf(os.Stderr, "Failed to write JUnit report: %s\n", err)
+			os.Exit(2)
+		}
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to close JUnit report: %s\n", err)
+			os.Exit(2)
+		}
+	}
+
+	for _, r := range rs {
+		if !r.Passed() && !r.Skipped() {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteText writes a human-readable report of rs obtained using seed to w.
+func WriteText(w io.Writer, seed int64, rs []*Result) error {
+	if _, err := fmt.Fprintf(w, "seed: %d\n", seed); err != nil {
+		return err
+	}
+
+	var passed, failed, skipped int
+	for _, r := range rs {
+		status := "PASS"
+		switch {
+		case r.Skipped():
+			status = "SKIP"
+			skipped++
+		case !r.Passed():
+			status = "FAIL"
+			failed++
+		default:
+			passed++
+		}
+
+		if _, err := fmt.Fprintf(w, "--- %s: %s (%.3fs)\n", status, r.Name, r.Duration.Seconds()); err != nil {
+			return err
+		}
+		if r.Err != nil {
+			if _, err := fmt.Fprintf(w, "\t%s\n", r.Err); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+	return err
+}
+
+// junitTestSuites is the root element of a JUnit XML report.
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite is a JUnit XML test suite.
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+// junitProperty is a JUnit XML test suite property.
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// junitTestCase is a JUnit XML test case.
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+// junitMessage is a JUnit XML failure or skip message.
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes a JUnit XML report of rs obtained using seed to w.
+func WriteJUnit(w io.Writer, seed int64, rs []*Result) error {
+	suite := junitTestSuite{
+		Name:  "trc-conformance",
+		Tests: len(rs),
+		Properties: []junitProperty{
+			{Name: "seed", Value: strconv.FormatInt(seed, 10)},
+		},
+	}
+
+	var total time.Duration
+	for _, r := range rs {
+		total += r.Duration
+
+		className := "trc"
+		name := r.Name
+		if i := strings.Index(r.Name, "/"); i >= 0 {
+			className, name = "trc."+r.Name[:i], r.Name[i+1:]
+		}
+
+		tc := junitTestCase{
+			Name:      name,
+			ClassName: className,
+			Time:      fmt.Sprintf("%.3f", r.Duration.Seconds()),
+		}
+		switch {
+		case r.Skipped():
+			suite.Skipped++
+			tc.Skipped = &junitMessage{Message: r.Err.Error()}
+		case !r.Passed():
+			suite.Failures++
+			tc.Failure = &junitMessage{Message: r.Err.Error()}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total.Seconds())
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(&junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+const (
+	// concurrentRequests is the amount of requests sent at once by the concurrency case.
+	concurrentRequests = 10
+	// repeatedPings is the amount of pings sent one after another by the repeated ping case.
+	repeatedPings = 3
+)
+
+// Case is a conformance test case.
+type Case struct {
+	// Name is the name of the case in form group/name.
+	Name string
+	// Run runs the case on a freshly dialed connection.
+	Run func(cl *client) error
+}
+
+// Result is the result of running a Case.
+type Result struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// Passed reports whether the case passed.
+func (r *Result) Passed() bool {
+	return r.Err == nil
+}
+
+// Skipped reports whether the case was skipped.
+func (r *Result) Skipped() bool {
+	_, ok := r.Err.(errSkipped)
+	return ok
+}
+
+// Suite is the conformance test suite.
+var Suite = []Case{
+	{
+		Name: "handshake/same-version",
+		Run: func(cl *client) error {
+			if _, err := cl.handshake(sameVersion); err != nil {
+				return err
+			}
+			return expectPong(cl)
+		},
+	},
+	{
+		Name: "handshake/lower-minor-version",
+		Run: func(cl *client) error {
+			var skip bool
+			if _, err := cl.handshake(func(v semver.Version) semver.Version {
+				if v.Minor == 0 {
+					skip = true
+					return v
+				}
+				v.Minor--
+				v.Patch = 0
+				return v
+			}); err != nil {
+				return err
+			}
+			if skip {
+				return errSkipped("TRC requested minor version 0")
+			}
+			return expectPong(cl)
+		},
+	},
+	{
+		Name: "handshake/major-version-mismatch",
+		Run: func(cl *client) error {
+			if _, err := cl.handshake(func(v semver.Version) semver.Version {
+				v.Major++
+				return v
+			}); err != nil {
+				return err
+			}
+
+			req, err := cl.sendRequest(api.MessageTypePing, nil)
+			if err != nil {
+				if isClosed(err) {
+					return nil
+				}
+				return err
+			}
+			return errors.Wrap(cl.expectNoResponse(req.MessageID), "TRC must reject a major version mismatch")
+		},
+	},
+	{
+		Name: "ping/srrs-repeated",
+		Run: func(cl *client) error {
+			if _, err := cl.handshake(sameVersion); err != nil {
+				return err
+			}
+			for i := 0; i < repeatedPings; i++ {
+				if err := expectPong(cl); err != nil {
+					return errors.Wrapf(err, "ping %d of %d", i+1, repeatedPings)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Name: "ping/trc-initiated",
+		Run: func(cl *client) error {
+			if _, err := cl.handshake(sameVersion); err != nil {
+				return err
+			}
+
+			ping, err := cl.receive(cl.pingWait, func(msg *api.Message) bool {
+				return msg.Type == api.MessageTypePing && msg.ParentID == nil
+			})
+			if err != nil {
+				return errors.Wrap(err, "failed to receive ping from TRC")
+			}
+			if len(ping.Payload) > 0 && string(ping.Payload) != "null" {
+				return errors.New("ping must not have a payload")
+			}
+			return cl.send(api.NewMessage(api.MessageTypePing, nil, &ping.MessageID))
+		},
+	},
+	{
+		Name: "state/echo",
+		Run: func(cl *client) error {
+			if _, err := cl.handshake(sameVersion); err != nil {
+				return err
+			}
+
+			st := &api.State{
+				Turtles: cl.gen.TurtleStateMap(),
+			}
+			for len(st.Turtles) == 0 {
+				st.Turtles = cl.gen.TurtleStateMap()
+			}
+			return expectStateResponse(cl, st)
+		},
+	},
+	{
+		Name: "state/command",
+		Run: func(cl *client) error {
+			if _, err := cl.handshake(sameVersion); err != nil {
+				return err
+			}
+			return expectStateResponse(cl, &api.State{
+				Command: cl.gen.Command(),
+			})
+		},
+	},
+	{
+		Name: "reject/unknown-message-field",
+		Run: func(cl *client) error {
+			if _, err := cl.handshake(sameVersion); err != nil {
+				return err
+			}
+
+			msg := api.NewMessage(api.MessageTypePing, nil, nil)
+			b, err := json.Marshal(struct {
+				*api.Message
+				Unknown int `json:"unknown_field"`
+			}{msg, 42})
+			if err != nil {
+				return err
+			}
+			if err := cl.sendRaw(b); err != nil {
+				return err
+			}
+			return errors.Wrap(cl.expectNoResponse(msg.MessageID), "TRC must reject messages with unknown fields")
+		},
+	},
+	{
+		Name: "reject/unknown-payload-field",
+		Run: func(cl *client) error {
+			if _, err := cl.handshake(sameVersion); err != nil {
+				return err
+			}
+
+			msg := api.NewMessage(api.MessageTypeState, json.RawMessage(`{"turtles":{"1":{"unknown_field":42}}}`), nil)
+			if err := cl.send(msg); err != nil {
+				return err
+			}
+			return errors.Wrap(cl.expectNoResponse(msg.MessageID), "TRC must reject payloads with unknown fields")
+		},
+	},
+	{
+		Name: "reject/malformed-json",
+		Run: func(cl *client) error {
+			if _, err := cl.handshake(sameVersion); err != nil {
+				return err
+			}
+			if err := cl.sendRaw([]byte(`{"type":"ping","message_id":}`)); err != nil {
+				return err
+			}
+			return errors.Wrap(cl.expectRejected(func(msg *api.Message) bool {
+				return msg.ParentID != nil
+			}), "TRC must reject malformed messages")
+		},
+	},
+	{
+		Name: "concurrency/interleaved-requests",
+		Run: func(cl *client) error {
+			if _, err := cl.handshake(sameVersion); err != nil {
+				return err
+			}
+
+			reqs := make(map[string]api.MessageType, concurrentRequests)
+			for i := 0; i < concurrentRequests; i++ {
+				typ := api.MessageTypePing
+				var pld interface{}
+				if i%2 == 1 {
+					typ = api.MessageTypeState
+					pld = &api.State{
+						Command: cl.gen.Command(),
+					}
+				}
+
+				req, err := cl.sendRequest(typ, pld)
+				if err != nil {
+					return err
+				}
+				reqs[req.MessageID.String()] = typ
+			}
+
+			for len(reqs) > 0 {
+				resp, err := cl.receive(cl.timeout, func(msg *api.Message) bool {
+					return msg.ParentID != nil
+				})
+				if err != nil {
+					return errors.Wrapf(err, "%d responses missing", len(reqs))
+				}
+
+				typ, ok := reqs[resp.ParentID.String()]
+				if !ok {
+					return errors.Errorf("response to unknown or already answered request %s", resp.ParentID)
+				}
+				if typ != resp.Type {
+					return errors.Errorf("response of type %s to request of type %s", resp.Type, typ)
+				}
+				delete(reqs, resp.ParentID.String())
+			}
+			return nil
+		},
+	},
+}
+
+// expectPong sends a ping and expects a pong in response.
+func expectPong(cl *client) error {
+	req, err := cl.sendRequest(api.MessageTypePing, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := cl.receiveResponse(req.MessageID)
+	if err != nil {
+		return err
+	}
+	if resp.Type != api.MessageTypePing {
+		return errors.Errorf("expected response of type %s, got %s", api.MessageTypePing, resp.Type)
+	}
+	if len(resp.Payload) > 0 && string(resp.Payload) != "null" {
+		return errors.New("pong must not have a payload")
+	}
+	return nil
+}
+
+// expectStateResponse sends st and expects a valid state in response, which contains all values set in st.
+func expectStateResponse(cl *client, st *api.State) error {
+	req, err := cl.sendRequest(api.MessageTypeState, st)
+	if err != nil {
+		return err
+	}
+
+	resp, err := cl.receiveResponse(req.MessageID)
+	if err != nil {
+		return err
+	}
+	if resp.Type != api.MessageTypeState {
+		return errors.Errorf("expected response of type %s, got %s", api.MessageTypeState, resp.Type)
+	}
+
+	var got api.State
+	if err := decodeStrict(resp.Payload, &got); err != nil {
+		return errors.Wrap(err, "failed to decode state response")
+	}
+	if err := got.Validate(); err != nil {
+		return errors.Wrap(err, "invalid state response")
+	}
+
+	var wantV, gotV interface{}
+	if err := remarshal(st, &wantV); err != nil {
+		return err
+	}
+	if err := remarshal(&got, &gotV); err != nil {
+		return err
+	}
+	if diffs := echoDiffs("", wantV, gotV); len(diffs) > 0 {
+		return errors.Errorf("state response does not echo the request: %s", strings.Join(diffs, "; "))
+	}
+	return nil
+}
+
+// remarshal decodes the JSON encoding of v into dst.
+func remarshal(v, dst interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// echoDiffs returns the values at path in want, which are not contained in got.
+// Object keys not present in want are ignored.
+func echoDiffs(path string, want, got interface{}) []string {
+	wm, ok := want.(map[string]interface{})
+	if !ok {
+		if reflect.DeepEqual(want, got) {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: want %v, got %v", path, want, got)}
+	}
+
+	gm, ok := got.(map[string]interface{})
+	if !ok {
+		return []string{fmt.Sprintf("%s: want an object, got %v", path, got)}
+	}
+
+	keys := make([]string, 0, len(wm))
+	for k := range wm {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var diffs []string
+	for _, k := range keys {
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+		diffs = append(diffs, echoDiffs(p, wm[k], gm[k])...)
+	}
+	return diffs
+}
+
+// isClosed reports whether err was caused by the connection being closed.
+func isClosed(err error) bool {
+	err = errors.Cause(err)
+	if err == io.EOF {
+		return true
+	}
+	_, ok := err.(*net.OpError)
+	return ok
+}
+
+// Config is the configuration of a suite run.
+type Config struct {
+	// Timeout is the duration to wait for a response from TRC.
+	Timeout time.Duration
+	// PingWait is the duration to wait for a TRC-initiated ping.
+	PingWait time.Duration
+	// Seed seeds the generator of the random values sent to TRC.
+	// Each case uses a generator seeded with Seed, such that it can be reproduced in isolation.
+	Seed int64
+}
+
+// Run runs cases configured by conf, dialing a new connection for each of them using dial.
+func Run(cases []Case, dial func() (net.Conn, error), conf Config) []*Result {
+	rs := make([]*Result, 0, len(cases))
+	for _, c := range cases {
+		start := time.Now()
+		err := func() error {
+			netConn, err := dial()
+			if err != nil {
+				return errors.Wrap(err, "failed to dial TRC")
+			}
+
+			cl := newClient(netConn, conf)
+			defer cl.Close()
+
+			return c.Run(cl)
+		}()
+		rs = append(rs, &Result{
+			Name:     c.Name,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+	}
+	return rs
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	"github.com/stretchr/testify/assert"
+)
+
+// strictStateHandler is a state handler, which rejects states with unknown fields by returning an error.
+func strictStateHandler(msg *api.Message) (*api.Message, error) {
+	if err := decodeStrict(msg.Payload, &api.State{}); err != nil {
+		return nil, err
+	}
+	return trctest.DefaultStateHandler(msg)
+}
+
+// serveTRC serves mock TRCs on a local TCP socket and returns its address and a function closing it.
+// The mock TRCs are conformant, unless handlers returned by newHandlers override the default ones.
+func serveTRC(t *testing.T, newHandlers func(netConn net.Conn) map[api.MessageType]trctest.Handler) (string, func()) {
+	netLst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	go func() {
+		for {
+			netConn, err := netLst.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer netConn.Close()
+
+				hs := map[api.MessageType]trctest.Handler{
+					api.MessageTypeHandshake: trctest.DefaultHandshakeHandler,
+					api.MessageTypeState:     strictStateHandler,
+					api.MessageTypePing:      trctest.DefaultPingHandler,
+				}
+				if newHandlers != nil {
+					for typ, h := range newHandlers(netConn) {
+						hs[typ] = h
+					}
+				}
+
+				var opts []trctest.Option
+				for typ, h := range hs {
+					opts = append(opts, trctest.WithHandler(typ, h))
+				}
+				trc := trctest.Connect(netConn, netConn, opts...)
+				defer trc.Close()
+
+				if err := trc.SendHandshake(&api.Handshake{Version: trcapi.DefaultVersion}); err != nil {
+					return
+				}
+
+				for {
+					select {
+					case <-trc.Errors():
+						return
+					case <-time.After(100 * time.Millisecond):
+						if err := trc.Ping(); err != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return netLst.Addr().String(), func() {
+		netLst.Close()
+	}
+}
+
+// testConfig returns the configuration of suite runs against mock TRCs.
+func testConfig() Config {
+	return Config{
+		Timeout:  200 * time.Millisecond,
+		PingWait: time.Second,
+		Seed:     42,
+	}
+}
+
+//Test_items: Run(), WriteText(), WriteJUnit() in suite.go and report.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestSuite(t *testing.T) {
+	a := assert.New(t)
+
+	addr, closeFn := serveTRC(t, nil)
+	defer closeFn()
+
+	rs := Run(Suite, func() (net.Conn, error) {
+		return net.Dial("tcp", addr)
+	}, testConfig())
+	if !a.Len(rs, len(Suite)) {
+		t.FailNow()
+	}
+
+	for _, r := range rs {
+		if r.Name == "handshake/lower-minor-version" {
+			a.True(r.Skipped(), "%s should be skipped for version %s", r.Name, trcapi.DefaultVersion)
+			continue
+		}
+		a.True(r.Passed(), "%s failed: %v", r.Name, r.Err)
+	}
+
+	buf := &bytes.Buffer{}
+	a.NoError(WriteText(buf, 42, rs))
+	a.Contains(buf.String(), "seed: 42\n")
+	a.Contains(buf.String(), "--- PASS: state/echo")
+
+	buf.Reset()
+	a.NoError(WriteJUnit(buf, 42, rs))
+
+	var report junitTestSuites
+	a.NoError(xml.Unmarshal(buf.Bytes(), &report))
+	if a.Len(report.Suites, 1) {
+		a.Equal(len(Suite), report.Suites[0].Tests)
+		a.Equal(0, report.Suites[0].Failures)
+		a.Equal(1, report.Suites[0].Skipped)
+		a.Equal([]junitProperty{{Name: "seed", Value: "42"}}, report.Suites[0].Properties)
+	}
+}
+
+//Test_items: Run() in suite.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestSuiteNonConformant(t *testing.T) {
+	for _, tc := range []struct {
+		Name        string
+		NewHandlers func(netConn net.Conn) map[api.MessageType]trctest.Handler
+		Failed      []string
+	}{
+		{
+			Name: "lenient",
+			NewHandlers: func(net.Conn) map[api.MessageType]trctest.Handler {
+				return map[api.MessageType]trctest.Handler{
+					api.MessageTypeHandshake: func(*api.Message) (*api.Message, error) {
+						return nil, nil
+					},
+					api.MessageTypeState: trctest.DefaultStateHandler,
+				}
+			},
+			Failed: []string{
+				"handshake/major-version-mismatch",
+				"reject/unknown-payload-field",
+			},
+		},
+		{
+			Name: "hanging",
+			NewHandlers: func(netConn net.Conn) map[api.MessageType]trctest.Handler {
+				return map[api.MessageType]trctest.Handler{
+					api.MessageTypeState: func(msg *api.Message) (*api.Message, error) {
+						if err := decodeStrict(msg.Payload, &api.State{}); err != nil {
+							// Block the connection until it is closed by the suite.
+							ioutil.ReadAll(netConn) //nolint
+							return nil, err
+						}
+						return trctest.DefaultStateHandler(msg)
+					},
+				}
+			},
+			Failed: []string{
+				"reject/unknown-payload-field",
+			},
+		},
+		{
+			Name: "malformed-rejection",
+			NewHandlers: func(netConn net.Conn) map[api.MessageType]trctest.Handler {
+				return map[api.MessageType]trctest.Handler{
+					api.MessageTypeState: func(msg *api.Message) (*api.Message, error) {
+						if err := decodeStrict(msg.Payload, &api.State{}); err != nil {
+							_, err := netConn.Write([]byte("}\n"))
+							return nil, err
+						}
+						return trctest.DefaultStateHandler(msg)
+					},
+				}
+			},
+			Failed: []string{
+				"reject/unknown-payload-field",
+			},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			a := assert.New(t)
+
+			addr, closeFn := serveTRC(t, tc.NewHandlers)
+			defer closeFn()
+
+			rs := Run(Suite, func() (net.Conn, error) {
+				return net.Dial("tcp", addr)
+			}, testConfig())
+
+			var failed []string
+			for _, r := range rs {
+				if !r.Passed() && !r.Skipped() {
+					failed = append(failed, r.Name)
+				}
+			}
+			a.Equal(tc.Failed, failed)
+		})
+	}
+}
+
+//Test_items: echoDiffs() in suite.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestEchoDiffs(t *testing.T) {
+	a := assert.New(t)
+
+	want := map[string]interface{}{
+		"command": "stop",
+		"turtles": map[string]interface{}{
+			"1": map[string]interface{}{"batteryvoltage": 21.0},
+		},
+	}
+	a.Empty(echoDiffs("", want, map[string]interface{}{
+		"command": "stop",
+		"turtles": map[string]interface{}{
+			"1": map[string]interface{}{"batteryvoltage": 21.0, "role": "none"},
+			"2": map[string]interface{}{},
+		},
+	}))
+	a.Equal([]string{
+		"command: want stop, got <nil>",
+		"turtles.1.batteryvoltage: want 21, got 20",
+	}, echoDiffs("", want, map[string]interface{}{
+		"turtles": map[string]interface{}{
+			"1": map[string]interface{}{"batteryvoltage": 20.0},
+		},
+	}))
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+const (
+	// consolePrompt is the prompt printed by the console.
+	consolePrompt = "trcd> "
+
+	// defaultHistoryLength is the count of received messages printed by the history command, unless specified otherwise.
+	defaultHistoryLength = 10
+)
+
+// errExit is returned by console commands, which exit the console.
+var errExit = errors.New("exit")
+
+// consoleCommand is a command of the console.
+type consoleCommand struct {
+	usage string
+	help  string
+	run   func(c *console, args []string) error
+	// complete returns the candidates for the i-th argument given the preceding args.
+	complete func(c *console, i int, args []string) []string
+}
+
+// consoleCommands holds the commands of the console by name.
+var consoleCommands map[string]*consoleCommand
+
+func init() {
+	consoleCommands = map[string]*consoleCommand{
+		"set": {
+			usage:    "set <turtle> <field> <value>",
+			help:     "Set field of turtle to value, e.g. set 3 batteryvoltage 12. Structured values are comma-separated, e.g. set 1 pose 1,2,0",
+			run:      (*console).set,
+			complete: (*console).completeSet,
+		},
+		"emergency": {
+			usage: "emergency <turtle> on|off",
+			help:  "Press or release the emergency button of turtle",
+			run:   (*console).emergency,
+			complete: func(c *console, i int, args []string) []string {
+				switch i {
+				case 0:
+					return c.turtles()
+				case 1:
+					return []string{"on", "off"}
+				}
+				return nil
+			},
+		},
+		"command": {
+			usage: "command <command>",
+			help:  "Send command",
+			run:   (*console).command,
+			complete: func(c *console, i int, args []string) []string {
+				if i == 0 {
+					vs, _ := api.EnumValues(reflect.TypeOf(api.Command("")))
+					return vs
+				}
+				return nil
+			},
+		},
+		"remove": {
+			usage: "remove <turtle>",
+			help:  "Remove turtle from the roster",
+			run:   (*console).remove,
+			complete: func(c *console, i int, args []string) []string {
+				if i == 0 {
+					return c.turtles()
+				}
+				return nil
+			},
+		},
+		"show": {
+			usage: "show [<turtle>]",
+			help:  "Show the state sent to SRRS or the state of turtle",
+			run:   (*console).show,
+			complete: func(c *console, i int, args []string) []string {
+				if i == 0 {
+					return c.turtles()
+				}
+				return nil
+			},
+		},
+		"history": {
+			usage: "history [<count>]",
+			help:  fmt.Sprintf("Show the last count messages received from SRRS, %d by default", defaultHistoryLength),
+			run:   (*console).history,
+		},
+		"connections": {
+			usage: "connections",
+			help:  "List connections",
+			run:   (*console).connections,
+		},
+		"disconnect": {
+			usage: "disconnect [<connection>]",
+			help:  "Close connection or all connections",
+			run:   (*console).disconnect,
+			complete: func(c *console, i int, args []string) []string {
+				if i != 0 {
+					return nil
+				}
+				var ids []string
+				for _, conn := range c.ctl.list() {
+					ids = append(ids, conn.ID)
+				}
+				return ids
+			},
+		},
+		"ping": {
+			usage: "ping",
+			help:  "Send ping on all connections",
+			run:   (*console).ping,
+		},
+		"help": {
+			usage: "help",
+			help:  "Show this help",
+			run:   (*console).help,
+		},
+		"exit": {
+			usage: "exit",
+			help:  "Exit TRCD",
+			run: func(*console, []string) error {
+				return errExit
+			},
+		},
+	}
+}
+
+// console is an interactive console, which applies changes to all connections registered with ctl.
+type console struct {
+	ctl *control
+	out io.Writer
+	ids []string
+}
+
+// newConsole returns a new console, which writes output to out.
+// ids are the IDs of turtles offered by completion in addition to the ones in the tracked state.
+func newConsole(ctl *control, out io.Writer, ids []string) *console {
+	return &console{
+		ctl: ctl,
+		out: out,
+		ids: ids,
+	}
+}
+
+// printf writes formatted output.
+func (c *console) printf(format string, args ...interface{}) {
+	fmt.Fprintf(c.out, format, args...)
+}
+
+// turtles returns the IDs of known turtles sorted.
+func (c *console) turtles() []string {
+	set := make(map[string]struct{}, len(c.ids))
+	for _, id := range c.ids {
+		set[id] = struct{}{}
+	}
+	for id := range c.ctl.State().Turtles {
+		set[id] = struct{}{}
+	}
+
+	ids := make([]string, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// send sends st on all connections.
+func (c *console) send(st *api.State) error {
+	if err := st.Validate(); err != nil {
+		return err
+	}
+
+	conns := c.ctl.list()
+	if len(conns) == 0 {
+		return errors.New("no connections")
+	}
+	for _, conn := range conns {
+		if err := conn.conn.SendState(st); err != nil {
+			return errors.Wrapf(err, "failed to send state on connection %s", conn.ID)
+		}
+	}
+	return nil
+}
+
+// Execute executes the command line.
+// Execute returns errExit if the console should be exited.
+func (c *console) Execute(line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return nil
+	}
+
+	cmd, ok := consoleCommands[args[0]]
+	if !ok {
+		return errors.Errorf("unknown command %s, type help to list commands", args[0])
+	}
+	return cmd.run(c, args[1:])
+}
+
+// Complete returns the candidates completing the last word of line.
+func (c *console) Complete(line string) []string {
+	args := strings.Fields(line)
+	if len(args) == 0 || strings.HasSuffix(line, " ") {
+		args = append(args, "")
+	}
+	word := args[len(args)-1]
+
+	var candidates []string
+	if len(args) == 1 {
+		for name := range consoleCommands {
+			candidates = append(candidates, name)
+		}
+	} else if cmd, ok := consoleCommands[args[0]]; ok && cmd.complete != nil {
+		candidates = cmd.complete(c, len(args)-2, args[1:len(args)-1])
+	}
+
+	var ret []string
+	for _, cand := range candidates {
+		if strings.HasPrefix(cand, word) {
+			ret = append(ret, cand)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// turtleField returns the field of api.TurtleState encoded as JSON object key name.
+func turtleField(name string) (reflect.StructField, bool) {
+	rt := reflect.TypeOf(api.TurtleState{})
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if strings.Split(f.Tag.Get("json"), ",")[0] == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// turtleFieldNames returns the JSON names of fields of api.TurtleState sorted.
+func turtleFieldNames() []string {
+	rt := reflect.TypeOf(api.TurtleState{})
+	names := make([]string, 0, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		names = append(names, strings.Split(rt.Field(i).Tag.Get("json"), ",")[0])
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseFieldValue parses the value of field f from s.
+func parseFieldValue(f reflect.StructField, s string) (reflect.Value, error) {
+	t := f.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	v := reflect.New(t).Elem()
+	if vs, ok := api.EnumValues(t); ok {
+		for _, ev := range vs {
+			if ev == s {
+				v.SetString(s)
+				return v, nil
+			}
+		}
+		return reflect.Value{}, errors.Errorf("invalid value %s, expected one of %s", s, strings.Join(vs, ", "))
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		switch s {
+		case "on", "true":
+			v.SetBool(true)
+		case "off", "false":
+			v.SetBool(false)
+		default:
+			return reflect.Value{}, errors.Errorf("invalid value %s, expected on or off", s)
+		}
+
+	case reflect.Uint8:
+		n, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			return reflect.Value{}, errors.Wrapf(err, "invalid value %s", s)
+		}
+		v.SetUint(n)
+
+	case reflect.Struct:
+		parts := strings.Split(s, ",")
+		if len(parts) != t.NumField() {
+			return reflect.Value{}, errors.Errorf("expected %d comma-separated values", t.NumField())
+		}
+		for i, part := range parts {
+			x, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return reflect.Value{}, errors.Wrapf(err, "invalid value %s", part)
+			}
+			v.Field(i).SetFloat(x)
+		}
+
+	default:
+		return reflect.Value{}, errors.Errorf("unsupported field type %s", f.Type)
+	}
+
+	if f.Type.Kind() == reflect.Ptr {
+		return v.Addr(), nil
+	}
+	return v, nil
+}
+
+// set implements the set command.
+func (c *console) set(args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: " + consoleCommands["set"].usage)
+	}
+
+	f, ok := turtleField(args[1])
+	if !ok {
+		return errors.Errorf("unknown field %s", args[1])
+	}
+	v, err := parseFieldValue(f, args[2])
+	if err != nil {
+		return err
+	}
+
+	ts := &api.TurtleState{}
+	reflect.ValueOf(ts).Elem().FieldByIndex(f.Index).Set(v)
+	return c.send(&api.State{
+		Turtles: map[string]*api.TurtleState{args[0]: ts},
+	})
+}
+
+// completeSet completes the arguments of the set command.
+func (c *console) completeSet(i int, args []string) []string {
+	switch i {
+	case 0:
+		return c.turtles()
+	case 1:
+		return turtleFieldNames()
+	case 2:
+		f, ok := turtleField(args[1])
+		if !ok {
+			return nil
+		}
+		t := f.Type
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if vs, ok := api.EnumValues(t); ok {
+			return vs
+		}
+		if t.Kind() == reflect.Bool {
+			return []string{"on", "off"}
+		}
+	}
+	return nil
+}
+
+// emergency implements the emergency command.
+func (c *console) emergency(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: " + consoleCommands["emergency"].usage)
+	}
+	return c.set([]string{args[0], "robotembutton", args[1]})
+}
+
+// command implements the command command.
+func (c *console) command(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: " + consoleCommands["command"].usage)
+	}
+	return c.send(&api.State{Command: api.Command(args[0])})
+}
+
+// remove implements the remove command.
+func (c *console) remove(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: " + consoleCommands["remove"].usage)
+	}
+
+	conns := c.ctl.list()
+	if len(conns) == 0 {
+		return errors.New("no connections")
+	}
+	for _, conn := range conns {
+		if err := conn.conn.SendState(&api.State{
+			Turtles: map[string]*api.TurtleState{args[0]: nil},
+		}); err != nil {
+			return errors.Wrapf(err, "failed to send state on connection %s", conn.ID)
+		}
+	}
+	return nil
+}
+
+// printJSON writes v indented as JSON.
+func (c *console) printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	c.printf("%s\n", b)
+	return nil
+}
+
+// show implements the show command.
+func (c *console) show(args []string) error {
+	st := c.ctl.State()
+	switch len(args) {
+	case 0:
+		return c.printJSON(st)
+	case 1:
+		ts, ok := st.Turtles[args[0]]
+		if !ok {
+			return errors.Errorf("unknown turtle %s", args[0])
+		}
+		return c.printJSON(ts)
+	}
+	return errors.New("usage: " + consoleCommands["show"].usage)
+}
+
+// history implements the history command.
+func (c *console) history(args []string) error {
+	n := defaultHistoryLength
+	switch len(args) {
+	case 0:
+	case 1:
+		var err error
+		n, err = strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return errors.Errorf("invalid count %s", args[0])
+		}
+	default:
+		return errors.New("usage: " + consoleCommands["history"].usage)
+	}
+
+	c.ctl.messagesMu.RLock()
+	msgs := c.ctl.messages
+	if len(msgs) > n {
+		msgs = msgs[len(msgs)-n:]
+	}
+	msgs = append([]*receivedMessage{}, msgs...)
+	c.ctl.messagesMu.RUnlock()
+
+	for _, msg := range msgs {
+		c.printf("%d %s [%s] %s %s\n",
+			msg.Index, msg.ReceivedAt.Format("15:04:05.000"), msg.Connection, msg.Message.Type, msg.Message.Payload,
+		)
+	}
+	return nil
+}
+
+// connections implements the connections command.
+func (c *console) connections(args []string) error {
+	for _, conn := range c.ctl.list() {
+		c.printf("%s %s connected at %s\n", conn.ID, conn.Addr, conn.ConnectedAt.Format("15:04:05"))
+	}
+	return nil
+}
+
+// disconnect implements the disconnect command.
+func (c *console) disconnect(args []string) error {
+	conns := c.ctl.list()
+	switch len(args) {
+	case 0:
+	case 1:
+		conns = nil
+		for _, conn := range c.ctl.list() {
+			if conn.ID == args[0] {
+				conns = append(conns, conn)
+			}
+		}
+		if len(conns) == 0 {
+			return errors.Errorf("connection %s not found", args[0])
+		}
+	default:
+		return errors.New("usage: " + consoleCommands["disconnect"].usage)
+	}
+	return closeConns(conns)
+}
+
+// ping implements the ping command.
+func (c *console) ping(args []string) error {
+	for _, conn := range c.ctl.list() {
+		if err := conn.conn.Ping(); err != nil {
+			return errors.Wrapf(err, "failed to send ping on connection %s", conn.ID)
+		}
+	}
+	return nil
+}
+
+// help implements the help command.
+func (c *console) help(args []string) error {
+	names := make([]string, 0, len(consoleCommands))
+	for name := range consoleCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		c.printf("%-30s %s\n", consoleCommands[name].usage, consoleCommands[name].help)
+	}
+	return nil
+}
+
+// Run runs the console reading input from in until in is exhausted or the console is exited.
+// If unbuffered is true, in must be a terminal switched to unbuffered mode by makeUnbuffered,
+// in which case lines can be edited and completed using tab.
+// Restoring the terminal mode is the responsibility of the caller.
+func (c *console) Run(in io.Reader, unbuffered bool) error {
+	if !unbuffered {
+		sc := bufio.NewScanner(in)
+		for c.printf(consolePrompt); sc.Scan(); c.printf(consolePrompt) {
+			if err := c.Execute(sc.Text()); err == errExit {
+				return nil
+			} else if err != nil {
+				c.printf("Error: %s\n", err)
+			}
+		}
+		return sc.Err()
+	}
+
+	ed := &lineEditor{
+		r:        bufio.NewReader(in),
+		w:        c.out,
+		prompt:   consolePrompt,
+		complete: c.Complete,
+	}
+	for {
+		line, err := ed.ReadLine()
+		if err == io.EOF {
+			c.printf("\n")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.Execute(line); err == errExit {
+			return nil
+		} else if err != nil {
+			c.printf("Error: %s\n", err)
+		}
+	}
+}
+
+// lineEditor reads lines from a terminal in unbuffered mode without echo.
+// It supports deleting characters, clearing the line, navigating the history and completion.
+type lineEditor struct {
+	r        *bufio.Reader
+	w        io.Writer
+	prompt   string
+	complete func(line string) []string
+
+	history []string
+}
+
+// commonPrefix returns the longest common prefix of ss.
+func commonPrefix(ss []string) string {
+	if len(ss) == 0 {
+		return ""
+	}
+	prefix := ss[0]
+	for _, s := range ss[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// ReadLine reads a single line.
+// ReadLine returns io.EOF if the input is exhausted or end of transmission is read on an empty line.
+func (ed *lineEditor) ReadLine() (string, error) {
+	fmt.Fprint(ed.w, ed.prompt)
+
+	line := ""
+	hist := len(ed.history)
+	redraw := func() {
+		fmt.Fprintf(ed.w, "\r\x1b[K%s%s", ed.prompt, line)
+	}
+	for {
+		b, err := ed.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		switch b {
+		case '\r', '\n':
+			fmt.Fprint(ed.w, "\n")
+			if strings.TrimSpace(line) != "" {
+				ed.history = append(ed.history, line)
+			}
+			return line, nil
+
+		case 4: // Ctrl-D
+			if line == "" {
+				return "", io.EOF
+			}
+
+		case 21: // Ctrl-U
+			line = ""
+			redraw()
+
+		case 127, '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				redraw()
+			}
+
+		case '\t':
+			cands := ed.complete(line)
+			if len(cands) == 0 {
+				break
+			}
+
+			word := ""
+			if i := strings.LastIndexAny(line, " "); i >= 0 {
+				word = line[i+1:]
+			} else {
+				word = line
+			}
+
+			if len(cands) == 1 {
+				line += strings.TrimPrefix(cands[0], word) + " "
+				redraw()
+				break
+			}
+			if prefix := commonPrefix(cands); len(prefix) > len(word) {
+				line += strings.TrimPrefix(prefix, word)
+				redraw()
+				break
+			}
+			fmt.Fprintf(ed.w, "\n%s\n", strings.Join(cands, "  "))
+			redraw()
+
+		case 27: // Escape sequence
+			seq := make([]byte, 2)
+			if _, err := io.ReadFull(ed.r, seq); err != nil {
+				return "", err
+			}
+			if seq[0] != '[' {
+				break
+			}
+			switch seq[1] {
+			case 'A': // Up
+				if hist > 0 {
+					hist--
+					line = ed.history[hist]
+					redraw()
+				}
+			case 'B': // Down
+				if hist < len(ed.history) {
+					hist++
+					line = ""
+					if hist < len(ed.history) {
+						line = ed.history[hist]
+					}
+					redraw()
+				}
+			}
+
+		default:
+			if b >= ' ' {
+				line += string(b)
+				fmt.Fprintf(ed.w, "%c", b)
+			}
+		}
+	}
+}
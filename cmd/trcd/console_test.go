@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: console in console.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestConsole(t *testing.T) {
+	a := assert.New(t)
+
+	ctl := newControl("test", 10)
+	conn := &mockControlledConn{}
+	ctl.Add(ctl.NewID(), "a", conn, conn)
+
+	out := &bytes.Buffer{}
+	c := newConsole(ctl, out, []string{"1", "2", "3"})
+
+	a.Nil(c.Execute("set 3 batteryvoltage 12"))
+	a.Nil(c.Execute("emergency 2 on"))
+	a.Nil(c.Execute("set 1 pose 1,2,0.5"))
+	a.Nil(c.Execute("set 1 role goalkeeper"))
+	a.Nil(c.Execute("command stop"))
+	if a.Len(conn.states, 5) {
+		a.Equal(uint8(12), *conn.states[0].Turtles["3"].BatteryVoltage)
+		a.True(*conn.states[1].Turtles["2"].RobotEmergencyButton)
+		a.Equal(&api.Pose{X: 1, Y: 2, Heading: 0.5}, conn.states[2].Turtles["1"].Pose)
+		a.Equal(api.RoleGoalkeeper, conn.states[3].Turtles["1"].Role)
+		a.Equal(api.CommandStop, conn.states[4].Command)
+	}
+
+	a.NotNil(c.Execute("set 3 batteryvoltage 120"))
+	a.NotNil(c.Execute("set 3 batteryvoltage"))
+	a.NotNil(c.Execute("set 3 foo 1"))
+	a.NotNil(c.Execute("set 1 role striker"))
+	a.NotNil(c.Execute("emergency 2 maybe"))
+	a.NotNil(c.Execute("foo"))
+	a.Len(conn.states, 5)
+
+	a.Equal(errExit, c.Execute("exit"))
+
+	a.Nil(c.Execute("disconnect 1"))
+	a.True(conn.closed)
+
+	a.Equal([]string{"set", "show"}, c.Complete("s"))
+	a.Equal([]string{"1", "2", "3"}, c.Complete("set "))
+	a.Equal([]string{"batteryvoltage"}, c.Complete("set 3 batt"))
+	a.Equal([]string{"cyan", "magenta"}, c.Complete("set 3 teamcolor "))
+	a.Equal([]string{"off", "on"}, c.Complete("emergency 2 o"))
+	a.Empty(c.Complete("set 3 batteryvoltage "))
+}
+
+//Test_items: lineEditor in console.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestLineEditor(t *testing.T) {
+	a := assert.New(t)
+
+	c := newConsole(newControl("test", 10), &bytes.Buffer{}, []string{"1", "2"})
+	ed := &lineEditor{
+		r:        bufio.NewReader(strings.NewReader("se\t3 team\tc\t\nfoo\x7f\x7f\x7fshow\n\x1b[A\x1b[A\n\x04")),
+		w:        &bytes.Buffer{},
+		complete: c.Complete,
+	}
+
+	line, err := ed.ReadLine()
+	a.Nil(err)
+	a.Equal("set 3 teamcolor cyan ", line)
+
+	line, err = ed.ReadLine()
+	a.Nil(err)
+	a.Equal("show", line)
+
+	line, err = ed.ReadLine()
+	a.Nil(err)
+	a.Equal("set 3 teamcolor cyan ", line)
+
+	_, err = ed.ReadLine()
+	a.Equal("EOF", err.Error())
+}
@@ -0,0 +1,34 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// stty runs stty with args on stdin and returns its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// makeUnbuffered switches the terminal attached to stdin to unbuffered mode without echo and
+// returns a function restoring the previous mode.
+func makeUnbuffered() (func(), error) {
+	prev, err := stty("-g")
+	if err != nil {
+		return nil, errors.Wrap(err, "stdin is not a terminal")
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, errors.Wrap(err, "failed to switch terminal to unbuffered mode")
+	}
+	return func() {
+		stty(prev) //nolint
+	}, nil
+}
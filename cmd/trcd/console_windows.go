@@ -0,0 +1,12 @@
+// +build windows
+
+package main
+
+import (
+	"github.com/pkg/errors"
+)
+
+// makeUnbuffered is not supported on Windows, hence the console falls back to reading complete lines.
+func makeUnbuffered() (func(), error) {
+	return nil, errors.New("unbuffered terminal mode is not supported on Windows")
+}
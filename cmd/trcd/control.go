@@ -0,0 +1,526 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mohae/deepcopy"
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	"go.uber.org/zap"
+)
+
+var (
+	// controlStateEndpoint is the endpoint, on which states are pushed to SRRS.
+	controlStateEndpoint = path.Join("api", "v1", "state")
+
+	// controlPingEndpoint is the endpoint, on which pings are triggered.
+	controlPingEndpoint = path.Join("api", "v1", "ping")
+
+	// controlConnectionsEndpoint is the endpoint listing and closing client connections.
+	// Individual connections are served at controlConnectionsEndpoint/<id>.
+	controlConnectionsEndpoint = path.Join("api", "v1", "connections")
+
+	// controlRestartEndpoint is the endpoint, on which a restart of TRC is simulated.
+	controlRestartEndpoint = path.Join("api", "v1", "restart")
+
+	// controlTokenEndpoint is the endpoint of the token sent in handshakes.
+	controlTokenEndpoint = path.Join("api", "v1", "token")
+
+	// controlMessagesEndpoint is the endpoint listing the messages received from SRRS.
+	controlMessagesEndpoint = path.Join("api", "v1", "messages")
+)
+
+// controlledConn is a connection to SRRS controlled by control.
+type controlledConn interface {
+	SendState(st *api.State) error
+	SendToken(tok string) error
+	Ping() error
+}
+
+// connection is a client connection registered with control.
+type connection struct {
+	ID          string    `json:"id"`
+	Addr        string    `json:"addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+
+	conn   controlledConn
+	closer io.Closer
+}
+
+// receivedMessage is a message received from SRRS.
+type receivedMessage struct {
+	// Index is the index of the message in order of reception.
+	Index      int          `json:"index"`
+	Connection string       `json:"connection"`
+	ReceivedAt time.Time    `json:"received_at"`
+	Message    *api.Message `json:"message"`
+}
+
+// tokenRequest is the body of requests to controlTokenEndpoint.
+type tokenRequest struct {
+	Token string `json:"token"`
+}
+
+// control tracks the client connections of TRCD, the token used in handshakes and
+// the messages received from SRRS and allows to steer them over HTTP.
+// control is safe for concurrent use by multiple goroutines.
+type control struct {
+	mu          sync.RWMutex
+	connections map[string]*connection
+	lastID      int
+	token       string
+	downUntil   time.Time
+
+	// announceToken, if set, is called with every token set.
+	announceToken func(token string)
+
+	stateMu sync.RWMutex
+	state   *api.State
+
+	messagesMu sync.RWMutex
+	messages   []*receivedMessage
+	// maxMessages is the maximum length of messages.
+	maxMessages int
+	// received is the count of messages received, including the discarded ones.
+	received int
+}
+
+// newControl returns a new *control, which uses token in handshakes and keeps at most
+// maxMessages last messages received. Messages are not kept if maxMessages is 0.
+func newControl(token string, maxMessages int) *control {
+	return &control{
+		connections: make(map[string]*connection),
+		token:       token,
+		state:       &api.State{},
+		maxMessages: maxMessages,
+	}
+}
+
+// mergeTurtleState sets the fields of dst to the non-zero fields of src.
+func mergeTurtleState(dst, src *api.TurtleState) {
+	dv := reflect.ValueOf(dst).Elem()
+	forEachSetField(src, func(i int, v reflect.Value) {
+		dv.Field(i).Set(v)
+	})
+}
+
+// Track merges st sent to SRRS into the tracked state.
+func (ctl *control) Track(st *api.State) {
+	st = deepcopy.Copy(st).(*api.State)
+
+	ctl.stateMu.Lock()
+	defer ctl.stateMu.Unlock()
+
+	if st.Command != "" {
+		ctl.state.Command = st.Command
+	}
+	for id, ts := range st.Turtles {
+		if ts == nil {
+			delete(ctl.state.Turtles, id)
+			continue
+		}
+		if ctl.state.Turtles == nil {
+			ctl.state.Turtles = make(map[string]*api.TurtleState, len(st.Turtles))
+		}
+		if _, ok := ctl.state.Turtles[id]; !ok {
+			ctl.state.Turtles[id] = &api.TurtleState{}
+		}
+		mergeTurtleState(ctl.state.Turtles[id], ts)
+	}
+}
+
+// State returns a copy of the tracked state, i.e. the merge of all states sent to SRRS.
+func (ctl *control) State() *api.State {
+	ctl.stateMu.RLock()
+	defer ctl.stateMu.RUnlock()
+	return deepcopy.Copy(ctl.state).(*api.State)
+}
+
+// trackingConn is a *trctest.Conn, which tracks the states sent using control.
+type trackingConn struct {
+	*trctest.Conn
+	ctl *control
+}
+
+// SendState sends st to SRRS and tracks it on success.
+func (c *trackingConn) SendState(st *api.State) error {
+	if err := c.Conn.SendState(st); err != nil {
+		return err
+	}
+	c.ctl.Track(st)
+	return nil
+}
+
+// Token returns the token to use in handshakes.
+func (ctl *control) Token() string {
+	ctl.mu.RLock()
+	defer ctl.mu.RUnlock()
+	return ctl.token
+}
+
+// SetToken sets the token to use in handshakes of subsequent connections and
+// sends it to SRRS on all open connections.
+func (ctl *control) SetToken(token string) error {
+	ctl.mu.Lock()
+	ctl.token = token
+	announce := ctl.announceToken
+	ctl.mu.Unlock()
+
+	if announce != nil {
+		announce(token)
+	}
+
+	for _, c := range ctl.list() {
+		if err := c.conn.SendToken(token); err != nil {
+			return errors.Wrapf(err, "failed to send token on connection %s", c.ID)
+		}
+	}
+	return nil
+}
+
+// Accepting reports whether new connections may be accepted, i.e. whether TRC is not restarting.
+func (ctl *control) Accepting() bool {
+	ctl.mu.RLock()
+	defer ctl.mu.RUnlock()
+	return time.Now().After(ctl.downUntil)
+}
+
+// NewID returns a new unique connection ID.
+func (ctl *control) NewID() string {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+
+	ctl.lastID++
+	return strconv.Itoa(ctl.lastID)
+}
+
+// Add registers conn identified by id with address addr, which is closed by closer.
+func (ctl *control) Add(id, addr string, conn controlledConn, closer io.Closer) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+
+	ctl.connections[id] = &connection{
+		ID:          id,
+		Addr:        addr,
+		ConnectedAt: time.Now(),
+		conn:        conn,
+		closer:      closer,
+	}
+}
+
+// Remove unregisters the connection identified by id.
+func (ctl *control) Remove(id string) {
+	ctl.mu.Lock()
+	delete(ctl.connections, id)
+	ctl.mu.Unlock()
+}
+
+// Record records msg received on connection identified by id.
+// The oldest message recorded is discarded if maxMessages messages are already kept.
+func (ctl *control) Record(id string, msg *api.Message) {
+	if ctl.maxMessages <= 0 {
+		return
+	}
+
+	ctl.messagesMu.Lock()
+	if len(ctl.messages) >= ctl.maxMessages {
+		ctl.messages = ctl.messages[len(ctl.messages)-ctl.maxMessages+1:]
+	}
+	ctl.messages = append(ctl.messages, &receivedMessage{
+		Index:      ctl.received,
+		Connection: id,
+		ReceivedAt: time.Now(),
+		Message:    msg,
+	})
+	ctl.received++
+	ctl.messagesMu.Unlock()
+}
+
+// RecordHandler returns a handler, which records messages received on connection identified by id and passes them to h.
+func (ctl *control) RecordHandler(id string, h trctest.Handler) trctest.Handler {
+	return func(msg *api.Message) (*api.Message, error) {
+		ctl.Record(id, msg)
+		return h(msg)
+	}
+}
+
+// TrackHandler returns a handler, which passes messages to h and tracks the states h replies with.
+func (ctl *control) TrackHandler(h trctest.Handler) trctest.Handler {
+	return func(msg *api.Message) (*api.Message, error) {
+		reply, err := h(msg)
+		if err != nil || reply == nil || reply.Type != api.MessageTypeState {
+			return reply, err
+		}
+
+		var st api.State
+		if err := json.Unmarshal(reply.Payload, &st); err != nil {
+			return nil, errors.Wrap(err, "failed to decode state reply")
+		}
+		ctl.Track(&st)
+		return reply, nil
+	}
+}
+
+// list returns the registered connections sorted by ID.
+func (ctl *control) list() []*connection {
+	ctl.mu.RLock()
+	defer ctl.mu.RUnlock()
+
+	conns := make([]*connection, 0, len(ctl.connections))
+	for _, c := range ctl.connections {
+		conns = append(conns, c)
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		ni, _ := strconv.Atoi(conns[i].ID)
+		nj, _ := strconv.Atoi(conns[j].ID)
+		return ni < nj
+	})
+	return conns
+}
+
+// selectConns returns the connections identified by the connection query parameter of r or all connections, if it is not set.
+func (ctl *control) selectConns(r *http.Request) ([]*connection, error) {
+	id := r.URL.Query().Get("connection")
+	if id == "" {
+		return ctl.list(), nil
+	}
+
+	ctl.mu.RLock()
+	defer ctl.mu.RUnlock()
+
+	c, ok := ctl.connections[id]
+	if !ok {
+		return nil, errors.Errorf("connection %s not found", id)
+	}
+	return []*connection{c}, nil
+}
+
+// closeConns closes conns.
+func closeConns(conns []*connection) error {
+	for _, c := range conns {
+		if err := c.closer.Close(); err != nil {
+			return errors.Wrapf(err, "failed to close connection %s", c.ID)
+		}
+	}
+	return nil
+}
+
+// writeJSON writes v encoded as JSON to w.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zap.L().Error("Failed to write response", zap.Error(err))
+	}
+}
+
+// handleState handles requests to controlStateEndpoint.
+func (ctl *control) handleState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, errors.Errorf("expected a POST request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var st api.State
+	if err := dec.Decode(&st); err != nil {
+		http.Error(w, errors.Wrap(err, "failed to decode request body").Error(), http.StatusBadRequest)
+		return
+	}
+
+	conns, err := ctl.selectConns(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	for _, c := range conns {
+		if err := c.conn.SendState(&st); err != nil {
+			http.Error(w, errors.Wrapf(err, "failed to send state on connection %s", c.ID).Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePing handles requests to controlPingEndpoint.
+func (ctl *control) handlePing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, errors.Errorf("expected a POST request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	conns, err := ctl.selectConns(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	for _, c := range conns {
+		if err := c.conn.Ping(); err != nil {
+			http.Error(w, errors.Wrapf(err, "failed to send ping on connection %s", c.ID).Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleConnections handles requests to controlConnectionsEndpoint and the individual connections nested under it.
+func (ctl *control) handleConnections(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+controlConnectionsEndpoint), "/")
+
+	switch r.Method {
+	case "GET":
+		if id == "" {
+			writeJSON(w, ctl.list())
+			return
+		}
+
+		ctl.mu.RLock()
+		c, ok := ctl.connections[id]
+		ctl.mu.RUnlock()
+		if !ok {
+			http.Error(w, errors.Errorf("connection %s not found", id).Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, c)
+
+	case "DELETE":
+		conns := ctl.list()
+		if id != "" {
+			ctl.mu.RLock()
+			c, ok := ctl.connections[id]
+			ctl.mu.RUnlock()
+			if !ok {
+				http.Error(w, errors.Errorf("connection %s not found", id).Error(), http.StatusNotFound)
+				return
+			}
+			conns = []*connection{c}
+		}
+		if err := closeConns(conns); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, errors.Errorf("expected a GET or DELETE request, got %s", r.Method).Error(), http.StatusBadRequest)
+	}
+}
+
+// handleRestart handles requests to controlRestartEndpoint.
+// All connections are closed and new ones are refused for the duration specified by the downtime query parameter.
+func (ctl *control) handleRestart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, errors.Errorf("expected a POST request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	var downtime time.Duration
+	if s := r.URL.Query().Get("downtime"); s != "" {
+		var err error
+		downtime, err = time.ParseDuration(s)
+		if err != nil || downtime < 0 {
+			http.Error(w, errors.Errorf("invalid downtime: %s", s).Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctl.mu.Lock()
+	ctl.downUntil = time.Now().Add(downtime)
+	ctl.mu.Unlock()
+
+	if err := closeConns(ctl.list()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleToken handles requests to controlTokenEndpoint.
+// The token set is sent on all open connections and in handshakes of subsequent connections.
+func (ctl *control) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, errors.Errorf("expected a GET or POST request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == "POST" {
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+
+		var req tokenRequest
+		if err := dec.Decode(&req); err != nil {
+			http.Error(w, errors.Wrap(err, "failed to decode request body").Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Token == "" {
+			http.Error(w, "token must not be empty", http.StatusBadRequest)
+			return
+		}
+
+		if err := ctl.SetToken(req.Token); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	writeJSON(w, &tokenRequest{Token: ctl.Token()})
+}
+
+// handleMessages handles requests to controlMessagesEndpoint.
+// GET returns the messages received with index of at least the since query parameter, DELETE discards all received messages.
+func (ctl *control) handleMessages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		since := 0
+		if s := r.URL.Query().Get("since"); s != "" {
+			var err error
+			since, err = strconv.Atoi(s)
+			if err != nil || since < 0 {
+				http.Error(w, errors.Errorf("invalid since: %s", s).Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctl.messagesMu.RLock()
+		msgs := []*receivedMessage{}
+		for _, msg := range ctl.messages {
+			if msg.Index >= since {
+				msgs = append(msgs, msg)
+			}
+		}
+		ctl.messagesMu.RUnlock()
+		writeJSON(w, msgs)
+
+	case "DELETE":
+		ctl.messagesMu.Lock()
+		ctl.messages = nil
+		ctl.messagesMu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, errors.Errorf("expected a GET or DELETE request, got %s", r.Method).Error(), http.StatusBadRequest)
+	}
+}
+
+// Handler returns the HTTP handler of the control API.
+func (ctl *control) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+controlStateEndpoint, ctl.handleState)
+	mux.HandleFunc("/"+controlPingEndpoint, ctl.handlePing)
+	mux.HandleFunc("/"+controlConnectionsEndpoint, ctl.handleConnections)
+	mux.HandleFunc("/"+controlConnectionsEndpoint+"/", ctl.handleConnections)
+	mux.HandleFunc("/"+controlRestartEndpoint, ctl.handleRestart)
+	mux.HandleFunc("/"+controlTokenEndpoint, ctl.handleToken)
+	mux.HandleFunc("/"+controlMessagesEndpoint, ctl.handleMessages)
+	return mux
+}
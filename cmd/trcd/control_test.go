@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockControlledConn struct {
+	mu     sync.Mutex
+	states []*api.State
+	tokens []string
+	pings  int
+	closed bool
+}
+
+func (c *mockControlledConn) SendState(st *api.State) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.states = append(c.states, st)
+	return nil
+}
+
+func (c *mockControlledConn) SendToken(tok string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens = append(c.tokens, tok)
+	return nil
+}
+
+func (c *mockControlledConn) Ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pings++
+	return nil
+}
+
+func (c *mockControlledConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+//Test_items: control in control.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestControl(t *testing.T) {
+	a := assert.New(t)
+
+	ctl := newControl("test", 10)
+	srv := httptest.NewServer(ctl.Handler())
+	defer srv.Close()
+
+	do := func(method, ep, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+"/"+ep, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	c1, c2 := &mockControlledConn{}, &mockControlledConn{}
+	id1, id2 := ctl.NewID(), ctl.NewID()
+	ctl.Add(id1, "a", c1, c1)
+	ctl.Add(id2, "b", c2, c2)
+
+	resp := do("GET", controlConnectionsEndpoint, "")
+	var conns []*connection
+	a.Nil(json.NewDecoder(resp.Body).Decode(&conns))
+	resp.Body.Close()
+	if a.Len(conns, 2) {
+		a.Equal(id1, conns[0].ID)
+		a.Equal("b", conns[1].Addr)
+	}
+
+	resp = do("POST", controlStateEndpoint, `{"command":"stop"}`)
+	a.Equal(http.StatusNoContent, resp.StatusCode)
+	a.Equal([]*api.State{{Command: api.CommandStop}}, c1.states)
+	a.Equal([]*api.State{{Command: api.CommandStop}}, c2.states)
+
+	resp = do("POST", controlStateEndpoint, `{"foo":"bar"}`)
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	resp = do("POST", controlPingEndpoint+"?connection="+id2, "")
+	a.Equal(http.StatusNoContent, resp.StatusCode)
+	a.Equal(0, c1.pings)
+	a.Equal(1, c2.pings)
+
+	resp = do("POST", controlPingEndpoint+"?connection=42", "")
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+
+	resp = do("DELETE", controlConnectionsEndpoint+"/"+id1, "")
+	a.Equal(http.StatusNoContent, resp.StatusCode)
+	a.True(c1.closed)
+	a.False(c2.closed)
+
+	resp = do("POST", controlTokenEndpoint, `{"token":"secret"}`)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal("secret", ctl.Token())
+	a.Equal([]string{"secret"}, c2.tokens)
+
+	ctl.Record(id1, api.NewMessage(api.MessageTypePing, nil, nil))
+	ctl.Record(id2, api.NewMessage(api.MessageTypeState, json.RawMessage(`{"command":"start"}`), nil))
+
+	resp = do("GET", controlMessagesEndpoint+"?since=1", "")
+	var msgs []*receivedMessage
+	a.Nil(json.NewDecoder(resp.Body).Decode(&msgs))
+	resp.Body.Close()
+	if a.Len(msgs, 1) {
+		a.Equal(1, msgs[0].Index)
+		a.Equal(id2, msgs[0].Connection)
+		a.Equal(api.MessageTypeState, msgs[0].Message.Type)
+	}
+
+	resp = do("DELETE", controlMessagesEndpoint, "")
+	a.Equal(http.StatusNoContent, resp.StatusCode)
+	resp = do("GET", controlMessagesEndpoint, "")
+	msgs = nil
+	a.Nil(json.NewDecoder(resp.Body).Decode(&msgs))
+	resp.Body.Close()
+	a.Empty(msgs)
+
+	resp = do("POST", controlRestartEndpoint+"?downtime=1h", "")
+	a.Equal(http.StatusNoContent, resp.StatusCode)
+	a.True(c2.closed)
+	a.False(ctl.Accepting())
+
+	resp = do("POST", controlRestartEndpoint+"?downtime=-1s", "")
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	resp = do("POST", controlRestartEndpoint, "")
+	a.Equal(http.StatusNoContent, resp.StatusCode)
+	time.Sleep(time.Millisecond)
+	a.True(ctl.Accepting())
+}
+
+//Test_items: Record(), TrackHandler() in control.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestControlRecordTrack(t *testing.T) {
+	a := assert.New(t)
+
+	ctl := newControl("test", 2)
+	for i := 0; i < 3; i++ {
+		ctl.Record("1", api.NewMessage(api.MessageTypePing, nil, nil))
+	}
+	if a.Len(ctl.messages, 2) {
+		a.Equal(1, ctl.messages[0].Index)
+		a.Equal(2, ctl.messages[1].Index)
+	}
+
+	ctl = newControl("test", 0)
+	ctl.Record("1", api.NewMessage(api.MessageTypePing, nil, nil))
+	a.Empty(ctl.messages)
+
+	h := ctl.TrackHandler(func(msg *api.Message) (*api.Message, error) {
+		return api.NewMessage(api.MessageTypeState, json.RawMessage(`{"command":"stop","turtles":{"1":{"batteryvoltage":42}}}`), &msg.MessageID), nil
+	})
+	reply, err := h(api.NewMessage(api.MessageTypeState, json.RawMessage(`{"command":"stop"}`), nil))
+	a.Nil(err)
+	a.NotNil(reply)
+	st := ctl.State()
+	a.Equal(api.CommandStop, st.Command)
+	if a.Contains(st.Turtles, "1") && a.NotNil(st.Turtles["1"].BatteryVoltage) {
+		a.Equal(uint8(42), *st.Turtles["1"].BatteryVoltage)
+	}
+}
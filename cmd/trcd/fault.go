@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+)
+
+// splitList returns the non-empty, whitespace-trimmed elements of comma-separated list s.
+func splitList(s string) []string {
+	var ret []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// parseFaultOptions returns the trctest options injecting faults as configured by the flags.
+// probs is a comma-separated list of fault=probability pairs, e.g. "drop=0.1,duplicate=0.05".
+// ats is a comma-separated list of fault@time pairs, e.g. "malformed@10s,abrupt_close@1m".
+// If seed is 0, a random seed is used.
+func parseFaultOptions(probs, ats string, delay time.Duration, seed int64) ([]trctest.Option, error) {
+	var opts []trctest.Option
+	for _, e := range splitList(probs) {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("expected fault=probability, got %s", e)
+		}
+		f, err := trctest.ParseFault(kv[0])
+		if err != nil {
+			return nil, err
+		}
+		p, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid probability of fault %s", f)
+		}
+		if p < 0 || p > 1 {
+			return nil, errors.Errorf("probability of fault %s must be within 0 … 1, got %v", f, p)
+		}
+		opts = append(opts, trctest.WithFaultProbability(f, p))
+	}
+
+	for _, e := range splitList(ats) {
+		kv := strings.SplitN(e, "@", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("expected fault@time, got %s", e)
+		}
+		f, err := trctest.ParseFault(kv[0])
+		if err != nil {
+			return nil, err
+		}
+		at, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid time of fault %s", f)
+		}
+		if at < 0 {
+			return nil, errors.Errorf("time of fault %s must not be negative", f)
+		}
+		opts = append(opts, trctest.WithFaultAt(f, at))
+	}
+
+	if delay < 0 {
+		return nil, errors.New("fault delay must not be negative")
+	}
+	opts = append(opts, trctest.WithFaultDelay(delay))
+	if seed != 0 {
+		opts = append(opts, trctest.WithFaultSeed(seed))
+	}
+	return opts, nil
+}
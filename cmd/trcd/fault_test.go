@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: parseFaultOptions() in fault.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestParseFaultOptions(t *testing.T) {
+	a := assert.New(t)
+
+	opts, err := parseFaultOptions("", "", time.Second, 0)
+	a.Nil(err)
+	a.Len(opts, 1)
+
+	opts, err = parseFaultOptions("drop=0.1, duplicate=1", "malformed@10s,abrupt_close@1m", time.Second, 42)
+	a.Nil(err)
+	a.Len(opts, 6)
+
+	for _, tc := range []struct {
+		Probs string
+		Ats   string
+	}{
+		{Probs: "drop"},
+		{Probs: "drop=2"},
+		{Probs: "drop=x"},
+		{Probs: "crash=0.5"},
+		{Ats: "malformed"},
+		{Ats: "malformed@-1s"},
+		{Ats: "malformed@soon"},
+		{Ats: "crash@1s"},
+	} {
+		_, err := parseFaultOptions(tc.Probs, tc.Ats, time.Second, 0)
+		a.NotNil(err, "%+v", tc)
+	}
+}
@@ -1,19 +1,24 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"flag"
-	"fmt"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/blang/semver"
 	"github.com/pkg/errors"
 	"github.com/rvolosatovs/turtlitto/pkg/api"
-	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
 	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/recording"
 	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -24,6 +29,35 @@ var (
 	unixSock = flag.String("unixSocket", DefaultUnixSocket, "Path to the unix socket")
 	tcpSock  = flag.String("tcpSocket", DefaultTCPSocket, "Service address of tcp socket. TCP will be used instead of a Unix socket when this is set")
 	silent   = flag.Bool("silent", false, "Disables automatic sending of random state updates")
+	msgpack  = flag.Bool("msgpack", false, "Offer length-prefixed MessagePack encoding to SRRS during the handshake")
+	quiet    = flag.Bool("quiet", false, "Only log warnings, errors and the token. The token is logged as a structured line with message \"Token\" and field \"token\"")
+
+	token         = flag.String("token", "test", "Token sent to SRRS in the handshake")
+	randomTok     = flag.Bool("randomToken", false, "Use a random token instead of the one specified by token")
+	tokenRotation = flag.Duration("tokenRotation", 0, "Interval, at which a new random token is sent to SRRS and used for subsequent connections. 0 disables rotation")
+	versionFlag   = flag.String("version", trcapi.DefaultVersion.String(), "Protocol version advertised to SRRS in the handshake")
+
+	initialStatePath = flag.String("initialState", "", "Path to a JSON-encoded state sent to SRRS after the handshake instead of a random one")
+
+	rosterFlag     = flag.String("roster", strings.Join(api.DefaultRoster, ","), "Comma-separated IDs of turtles controlled by TRCD")
+	motionInterval = flag.Duration("motionInterval", 200*time.Millisecond, "Interval between updates of simulated turtle and ball positions. 0 disables the simulation")
+	simulate       = flag.Bool("simulate", false, "Simulate turtles reacting coherently to commands instead of sending random state updates")
+
+	replayPath  = flag.String("replay", "", "Path to a recording of TRC protocol session to replay instead of sending random state updates")
+	replaySpeed = flag.Float64("replaySpeed", 1, "Speed factor of the replay, 1 being the original speed. 0 replays without delays")
+	replayStep  = flag.Bool("replayStep", false, "Replay step-by-step: each message is sent after a newline is read from stdin")
+
+	faultProbs = flag.String("faults", "", "Comma-separated fault=probability pairs of faults to inject into messages sent to SRRS, e.g. drop=0.1,duplicate=0.05")
+	faultsAt   = flag.String("faultsAt", "", "Comma-separated fault@time pairs of faults to inject once the time elapsed since the connection was accepted, e.g. malformed@10s")
+	faultDelay = flag.Duration("faultDelay", trctest.DefaultFaultDelay, "Time responses are delayed by the delay fault")
+	faultSeed  = flag.Int64("faultSeed", 0, "Seed of the random fault injection. 0 uses a random seed")
+
+	consoleMode = flag.Bool("console", false, "Start an interactive console on stdin. Random and motion state updates are not sent automatically. Only warnings and errors are logged, unless debug is set")
+	controlAddr = flag.String("controlAddr", "", "Service address of the HTTP control API, e.g. localhost:4243. The control API is disabled if empty")
+	maxMessages = flag.Int("maxMessages", 1000, "Maximum amount of messages received from SRRS kept for the control API and console")
+
+	scenarioPath = flag.String("scenario", "", "Path to a JSON scenario to execute instead of sending random state updates")
+	scenarioExit = flag.Bool("scenarioExit", false, "Exit once the scenario finished, with a non-zero exit code if any expectation was not fulfilled")
 )
 
 func main() {
@@ -35,17 +69,33 @@ func main() {
 		conf = zap.NewDevelopmentConfig()
 		conf.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
 	}
+	// The token is logged regardless of the log level.
+	tokenConf := conf
+	tokenConf.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
+	if !*debug && (*consoleMode || *quiet) {
+		conf.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
+	}
 
 	logger, err := conf.Build()
 	if err != nil {
 		panic(err)
 	}
+	tokenLogger, err := tokenConf.Build()
+	if err != nil {
+		panic(err)
+	}
 
 	zap.RedirectStdLog(logger)
 	zap.ReplaceGlobals(logger)
 
 	if err := func() error {
-		defer logger.Sync() //nolint
+		defer logger.Sync()      //nolint
+		defer tokenLogger.Sync() //nolint
+
+		roster, err := trcapi.ParseRoster(*rosterFlag)
+		if err != nil {
+			return errors.Wrap(err, "invalid roster")
+		}
 
 		var netLst net.Listener
 		switch {
@@ -73,6 +123,127 @@ func main() {
 
 		defer netLst.Close()
 
+		var replayEntries []*recording.Entry
+		var replayOpts []recording.ReplayOption
+		if *replayPath != "" {
+			logger := logger.With(zap.String("path", *replayPath))
+
+			logger.Info("Reading recording...")
+			f, err := os.Open(*replayPath)
+			if err != nil {
+				return errors.Wrap(err, "failed to open recording")
+			}
+			replayEntries, err = recording.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return errors.Wrap(err, "failed to read recording")
+			}
+			logger.Info("Recording read", zap.Int("entries", len(replayEntries)))
+
+			replayOpts = append(replayOpts, recording.WithSpeed(*replaySpeed))
+			if *replayStep {
+				stepCh := make(chan struct{})
+				go func() {
+					defer close(stepCh)
+
+					sc := bufio.NewScanner(os.Stdin)
+					for sc.Scan() {
+						stepCh <- struct{}{}
+					}
+				}()
+				replayOpts = append(replayOpts, recording.WithStep(stepCh))
+			}
+		}
+
+		var runner *scenarioRunner
+		if *scenarioPath != "" {
+			logger := logger.With(zap.String("path", *scenarioPath))
+
+			logger.Info("Reading scenario...")
+			sc, err := readScenario(*scenarioPath)
+			if err != nil {
+				return err
+			}
+			logger.Info("Scenario read",
+				zap.String("name", sc.Name),
+				zap.Int("events", len(sc.Events)),
+			)
+			runner = newScenarioRunner(sc, logger)
+		} else if *scenarioExit {
+			return errors.New("scenarioExit requires scenario to be specified")
+		}
+
+		if *consoleMode && *replayStep {
+			return errors.New("console may not be used together with replayStep, since both read stdin")
+		}
+
+		faultOpts, err := parseFaultOptions(*faultProbs, *faultsAt, *faultDelay, *faultSeed)
+		if err != nil {
+			return errors.Wrap(err, "invalid fault injection")
+		}
+
+		var sim *simulation
+		if *simulate {
+			switch {
+			case *replayPath != "" || *scenarioPath != "":
+				return errors.New("simulate may not be used together with replay or scenario")
+			case *motionInterval <= 0:
+				return errors.New("simulate requires a positive motionInterval")
+			case *consoleMode:
+				return errors.New("simulate may not be used together with console, since the console suppresses the simulated motion")
+			}
+			sim = newSimulation(api.DefaultFieldDimensions(), roster)
+		}
+
+		version, err := semver.Parse(*versionFlag)
+		if err != nil {
+			return errors.Wrap(err, "invalid version")
+		}
+
+		var initialState *api.State
+		if *initialStatePath != "" {
+			if sim != nil || runner != nil || replayEntries != nil {
+				return errors.New("initialState may not be used together with simulate, scenario or replay")
+			}
+			initialState, err = readState(*initialStatePath)
+			if err != nil {
+				return err
+			}
+		}
+
+		tok := *token
+		if *randomTok || *tokenRotation > 0 {
+			tok = randomToken()
+		}
+		if tok == "" {
+			return errors.New("token must not be empty")
+		}
+		// Messages received are only kept if they can be inspected.
+		keepMessages := 0
+		if *controlAddr != "" || *consoleMode {
+			keepMessages = *maxMessages
+		}
+		ctl := newControl(tok, keepMessages)
+		ctl.announceToken = func(tok string) {
+			tokenLogger.Info("Token", zap.String("token", tok))
+		}
+		if *controlAddr != "" {
+			logger := logger.With(zap.String("addr", *controlAddr))
+
+			logger.Info("Listening for control API requests...")
+			ctlLst, err := net.Listen("tcp", *controlAddr)
+			if err != nil {
+				return errors.Wrap(err, "failed to listen for control API requests")
+			}
+			defer ctlLst.Close()
+
+			go func() {
+				if err := http.Serve(ctlLst, ctl.Handler()); err != nil {
+					logger.Debug("Control API server stopped", zap.Error(err))
+				}
+			}()
+		}
+
 		closeCh := make(chan struct{})
 
 		go func() {
@@ -99,35 +270,95 @@ func main() {
 					continue
 				}
 
+				if !ctl.Accepting() {
+					logger.Info("Refusing connection during restart",
+						zap.Stringer("addr", sockConn.RemoteAddr()),
+					)
+					sockConn.Close()
+					continue
+				}
+
 				go func() {
 					defer sockConn.Close()
 
-					logger := logger.With(zap.Stringer("addr", sockConn.RemoteAddr()))
+					connID := ctl.NewID()
+					logger := logger.With(
+						zap.Stringer("addr", sockConn.RemoteAddr()),
+						zap.String("connection", connID),
+					)
 
 					logger.Info("Connection accepted")
 
-					trcConn := trctest.Connect(sockConn, sockConn,
-						trctest.WithHandler(api.MessageTypeState, func(msg *api.Message) (*api.Message, error) {
+					trcConn := &trackingConn{ctl: ctl}
+					trcConn.Conn = trctest.Connect(sockConn, sockConn, append([]trctest.Option{
+						trctest.WithHandler(api.MessageTypeState, ctl.RecordHandler(connID, ctl.TrackHandler(func(msg *api.Message) (*api.Message, error) {
 							logger.With(zap.Any("state", msg)).Info("Received state")
 
+							if runner != nil && msg.ParentID == nil {
+								var st api.State
+								if err := json.Unmarshal(msg.Payload, &st); err != nil {
+									return nil, errors.Wrap(err, "failed to decode state")
+								}
+								runner.Observe(&st)
+							}
+
+							if sim != nil && msg.ParentID == nil {
+								var st api.State
+								if err := json.Unmarshal(msg.Payload, &st); err != nil {
+									return nil, errors.Wrap(err, "failed to decode state")
+								}
+								reply, err := sim.Handle(&st)
+								if err != nil {
+									return nil, err
+								}
+								b, err := json.Marshal(reply)
+								if err != nil {
+									return nil, errors.Wrap(err, "failed to encode state")
+								}
+								logger.With(zap.Any("reply", reply)).Debug("Sending simulated reply...")
+								return api.NewMessage(api.MessageTypeState, b, &msg.MessageID), nil
+							}
+
 							reply, err := trctest.DefaultStateHandler(msg)
 							logger.With(zap.Any("reply", reply)).Debug("Sending reply...")
 							return reply, err
-						}),
+						}))),
 
-						trctest.WithHandler(api.MessageTypePing, func(msg *api.Message) (*api.Message, error) {
+						trctest.WithHandler(api.MessageTypePing, ctl.RecordHandler(connID, func(msg *api.Message) (*api.Message, error) {
 							logger.Debug("Received ping")
 							return trctest.DefaultPingHandler(msg)
-						}),
+						})),
 
-						trctest.WithHandler(api.MessageTypeHandshake, func(msg *api.Message) (*api.Message, error) {
+						trctest.WithHandler(api.MessageTypeHandshake, ctl.RecordHandler(connID, func(msg *api.Message) (*api.Message, error) {
 							logger.Debug("Received handshake")
 							return trctest.DefaultPingHandler(msg)
-						}),
-					)
+						})),
+
+						trctest.WithHandler(api.MessageTypeToken, ctl.RecordHandler(connID, func(msg *api.Message) (*api.Message, error) {
+							logger.Debug("Received token acknowledgement")
+							return trctest.DefaultTokenHandler(msg)
+						})),
+					}, faultOpts...)...)
 					defer trcConn.Close()
 
+					ctl.Add(connID, sockConn.RemoteAddr().String(), trcConn, sockConn)
+					defer ctl.Remove(connID)
+
+					// connCtx is done once TRCD is closed or the connection failed.
+					connCtx, connCancel := context.WithCancel(context.Background())
+					defer connCancel()
+
 					go func() {
+						select {
+						case <-closeCh:
+							connCancel()
+						case <-connCtx.Done():
+						}
+					}()
+
+					go func() {
+						defer connCancel()
+
 						for err := range trcConn.Errors() {
 							logger.Error("Internal TRCD error",
 								zap.Error(err),
@@ -136,9 +367,32 @@ func main() {
 						}
 					}()
 
+					if replayEntries != nil {
+						ctx, cancel := context.WithCancel(context.Background())
+						go func() {
+							<-closeCh
+							cancel()
+						}()
+
+						logger.Info("Replaying recording...")
+						if err := trcConn.Replay(ctx, replayEntries, replayOpts...); err != nil {
+							logger.Error("Failed to replay recording",
+								zap.Error(err),
+							)
+							return
+						}
+						logger.Info("Replay finished")
+
+						<-closeCh
+						return
+					}
+
 					hs := &api.Handshake{
-						Version: trcapi.DefaultVersion,
-						Token:   "test",
+						Version: version,
+						Token:   ctl.Token(),
+					}
+					if *msgpack {
+						hs.Encodings = []api.Encoding{api.EncodingMsgPack, api.EncodingJSON}
 					}
 					if err := trcConn.SendHandshake(hs); err != nil {
 						logger.Error("Failed to send handshake",
@@ -150,7 +404,21 @@ func main() {
 						zap.Reflect("handshake", hs),
 					)
 
-					st := apitest.RandomState()
+					if runner != nil {
+						logger.Info("Executing scenario...")
+						if !runner.Run(connCtx, trcConn) {
+							<-connCtx.Done()
+						}
+						return
+					}
+
+					st := initialState
+					switch {
+					case sim != nil:
+						st = sim.State()
+					case st == nil:
+						st = randomState(roster)
+					}
 					if err := trcConn.SendState(st); err != nil {
 						logger.Error("Failed to send initial state",
 							zap.Error(err),
@@ -167,31 +435,35 @@ func main() {
 					}
 
 					wg := &sync.WaitGroup{}
-					wg.Add(2)
-
-					go func() {
-						defer wg.Done()
-
-						for {
-							select {
-							case <-time.After(10*time.Second + time.Millisecond*time.Duration(rand.Intn(7000))):
-								st := apitest.RandomState()
-								if err := trcConn.SendState(st); err != nil {
-									logger.Error("Failed to send state",
-										zap.Error(err),
+					wg.Add(1)
+
+					// States are only sent on command, if the console is used.
+					if sim == nil && !*consoleMode {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+
+							for {
+								select {
+								case <-time.After(10*time.Second + time.Millisecond*time.Duration(rand.Intn(7000))):
+									st := randomState(roster)
+									if err := trcConn.SendState(st); err != nil {
+										logger.Error("Failed to send state",
+											zap.Error(err),
+										)
+										return
+									}
+									logger.Info("Sent state",
+										zap.Reflect("state", st),
 									)
+
+								case <-closeCh:
+									logger.Debug("TRCD closed, stopping state-sending goroutine")
 									return
 								}
-								logger.Info("Sent state",
-									zap.Reflect("state", st),
-								)
-
-							case <-closeCh:
-								logger.Debug("TRCD closed, stopping state-sending goroutine")
-								return
 							}
-						}
-					}()
+						}()
+					}
 
 					go func() {
 						defer wg.Done()
@@ -214,60 +486,90 @@ func main() {
 						}
 					}()
 
+					if *motionInterval > 0 && !*consoleMode {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+
+							step := func(dt float64) *api.State {
+								return sim.Step(dt)
+							}
+							if sim == nil {
+								m := newMotion(api.DefaultFieldDimensions(), roster)
+								step = func(dt float64) *api.State {
+									return &api.State{
+										Turtles: m.step(dt),
+									}
+								}
+							}
+
+							ticker := time.NewTicker(*motionInterval)
+							defer ticker.Stop()
+
+							for {
+								select {
+								case <-ticker.C:
+									st := step(motionInterval.Seconds())
+									if err := trcConn.SendState(st); err != nil {
+										logger.Error("Failed to send motion state",
+											zap.Error(err),
+										)
+										return
+									}
+									logger.Debug("Sent motion state",
+										zap.Reflect("state", st),
+									)
+
+								case <-closeCh:
+									logger.Debug("TRCD closed, stopping motion goroutine")
+									return
+								}
+							}
+						}()
+					}
+
 					wg.Wait()
 				}()
 			}
 		}()
 
-		fmt.Println(`********************************************************************************
-                                        TOKEN INCOMING...
-********************************************************************************`)
-		time.Sleep(time.Second)
-		fmt.Println(`********************************************************************************
-********************************************************************************
-                              STILL COMING...
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************`)
-		time.Sleep(time.Second)
-		fmt.Println(`********************************************************************************
-********************************************************************************
-                              STILL COMING...
-********************************************************************************`)
-		time.Sleep(time.Second)
-		fmt.Println(`********************************************************************************
-****************BEHOLD........UNLEASHING THE TOKEN.....*************************
-********************************************************************************
-********************************************************************************
-********************************************************************************
-********************************************************************************`)
-		time.Sleep(time.Second)
-		fmt.Println(`********************************************************************************
-********************************************************************************
-                  TOKEN IS:                test
-********************************************************************************
-********************************************************************************
-********************************************************************************
-		`)
+		ctl.announceToken(ctl.Token())
+		if *tokenRotation > 0 {
+			go rotateTokens(ctl, *tokenRotation, closeCh)
+		}
 
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt)
 
+		var scenarioCh <-chan error
+		if runner != nil && *scenarioExit {
+			scenarioCh = runner.Done()
+		}
+
+		var consoleCh chan error
+		if *consoleMode {
+			// The terminal mode is restored on return, since the console may still be blocked reading stdin.
+			restore, err := makeUnbuffered()
+			if err != nil {
+				logger.Debug("Falling back to line-buffered console", zap.Error(err))
+			} else {
+				defer restore()
+			}
+
+			consoleCh = make(chan error, 1)
+			go func() {
+				consoleCh <- newConsole(ctl, os.Stdout, roster).Run(os.Stdin, err == nil)
+			}()
+		}
+
 		select {
 		case <-closeCh:
+		case err := <-consoleCh:
+			close(closeCh)
+			return err
+		case err := <-scenarioCh:
+			close(closeCh)
+			return err
 		case sig := <-c:
 			close(closeCh)
 			logger.Info("Received signal, exiting...",
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+)
+
+const (
+	// turtleSpeed is the maximum linear speed of simulated turtles in m/s.
+	turtleSpeed = 2.5
+	// turtleTurnSpeed is the maximum angular speed of simulated turtles in rad/s.
+	turtleTurnSpeed = 3.0
+	// kickSpeed is the speed of the ball after being kicked in m/s.
+	kickSpeed = 6.0
+	// kickDistance is the distance between turtle and ball in meters, at which the ball is kicked.
+	kickDistance = 0.4
+	// ballFriction is the fraction of ball speed lost per second.
+	ballFriction = 0.5
+	// ballNoise is the maximum error of the perceived ball position in meters.
+	ballNoise = 0.05
+)
+
+// turtleMotion is the simulated motion of a single turtle.
+type turtleMotion struct {
+	pose     api.Pose
+	velocity api.Velocity
+	target   api.Position
+
+	// held represents whether the turtle is kept in place.
+	held bool
+}
+
+// motion simulates plausible movement of turtles and the ball on the field.
+// motion is not safe for concurrent use.
+type motion struct {
+	field   api.FieldDimensions
+	turtles map[string]*turtleMotion
+
+	ball         api.Position
+	ballVelocity api.Velocity
+}
+
+// newMotion returns a new motion of turtles identified by ids placed randomly on field.
+func newMotion(field api.FieldDimensions, ids []string) *motion {
+	m := &motion{
+		field:   field,
+		turtles: make(map[string]*turtleMotion, len(ids)),
+	}
+	for _, id := range ids {
+		pos := m.randomPosition()
+		m.turtles[id] = &turtleMotion{
+			pose: api.Pose{
+				X:       pos.X,
+				Y:       pos.Y,
+				Heading: (2*rand.Float64() - 1) * math.Pi,
+			},
+			target: m.randomPosition(),
+		}
+	}
+	return m
+}
+
+// randomPosition returns a random position within the field lines.
+func (m *motion) randomPosition() api.Position {
+	return api.Position{
+		X: (rand.Float64() - 0.5) * m.field.Length,
+		Y: (rand.Float64() - 0.5) * m.field.Width,
+	}
+}
+
+// normalizeAngle returns a rotated to range -π … π.
+func normalizeAngle(a float64) float64 {
+	a = math.Mod(a+math.Pi, 2*math.Pi)
+	if a < 0 {
+		a += 2 * math.Pi
+	}
+	return a - math.Pi
+}
+
+// clamp returns v limited to range -max … max.
+func clamp(v, max float64) float64 {
+	return math.Max(-max, math.Min(max, v))
+}
+
+// step advances the simulation by dt seconds and returns the resulting turtle states.
+func (m *motion) step(dt float64) map[string]*api.TurtleState {
+	m.stepBall(dt)
+
+	sts := make(map[string]*api.TurtleState, len(m.turtles))
+	for id, t := range m.turtles {
+		if t.held {
+			pose := t.pose
+			t.velocity = api.Velocity{}
+			sts[id] = &api.TurtleState{
+				Pose:     &pose,
+				Velocity: &api.Velocity{},
+				Ball:     m.perceivedBall(),
+			}
+			continue
+		}
+
+		dx, dy := t.target.X-t.pose.X, t.target.Y-t.pose.Y
+		dist := math.Hypot(dx, dy)
+		if dist < 0.1 {
+			if rand.Intn(3) == 0 {
+				t.target = m.ball
+			} else {
+				t.target = m.randomPosition()
+			}
+		}
+
+		turn := clamp(normalizeAngle(math.Atan2(dy, dx)-t.pose.Heading), turtleTurnSpeed*dt)
+		speed := math.Min(turtleSpeed, dist/dt)
+		t.velocity = api.Velocity{
+			X:       speed * dx / math.Max(dist, 1e-9),
+			Y:       speed * dy / math.Max(dist, 1e-9),
+			Angular: turn / dt,
+		}
+		t.pose.X += t.velocity.X * dt
+		t.pose.Y += t.velocity.Y * dt
+		t.pose.Heading = normalizeAngle(t.pose.Heading + turn)
+
+		if math.Hypot(m.ball.X-t.pose.X, m.ball.Y-t.pose.Y) < kickDistance {
+			m.ballVelocity = api.Velocity{
+				X: kickSpeed * math.Cos(t.pose.Heading),
+				Y: kickSpeed * math.Sin(t.pose.Heading),
+			}
+		}
+
+		pose, vel := t.pose, t.velocity
+		sts[id] = &api.TurtleState{
+			Pose:     &pose,
+			Velocity: &vel,
+			Ball:     m.perceivedBall(),
+		}
+	}
+	return sts
+}
+
+// hold sets whether the turtle identified by id is kept in place.
+func (m *motion) hold(id string, held bool) {
+	if t, ok := m.turtles[id]; ok {
+		t.held = held
+	}
+}
+
+// perceivedBall returns the ball position as perceived by a turtle.
+func (m *motion) perceivedBall() *api.Position {
+	return &api.Position{
+		X: clamp(m.ball.X+(2*rand.Float64()-1)*ballNoise, m.field.Length/2+m.field.Margin),
+		Y: clamp(m.ball.Y+(2*rand.Float64()-1)*ballNoise, m.field.Width/2+m.field.Margin),
+	}
+}
+
+// stepBall advances the ball by dt seconds. The ball bounces off the field boundaries.
+func (m *motion) stepBall(dt float64) {
+	m.ball.X += m.ballVelocity.X * dt
+	m.ball.Y += m.ballVelocity.Y * dt
+
+	maxX, maxY := m.field.Length/2+m.field.Margin, m.field.Width/2+m.field.Margin
+	if math.Abs(m.ball.X) > maxX {
+		m.ball.X = clamp(m.ball.X, maxX)
+		m.ballVelocity.X = -m.ballVelocity.X
+	}
+	if math.Abs(m.ball.Y) > maxY {
+		m.ball.Y = clamp(m.ball.Y, maxY)
+		m.ballVelocity.Y = -m.ballVelocity.Y
+	}
+
+	f := math.Max(0, 1-ballFriction*dt)
+	m.ballVelocity.X *= f
+	m.ballVelocity.Y *= f
+}
+
+// randomState returns a random valid *api.State of turtles in roster.
+// Positions and velocities are omitted if they are simulated, so that the simulated motion is not disturbed.
+func randomState(roster []string) *api.State {
+	st := apitest.RandomState()
+	st.Turtles = apitest.RandomTurtleStateMapOf(roster)
+	if *motionInterval > 0 {
+		for _, ts := range st.Turtles {
+			ts.Pose = nil
+			ts.Velocity = nil
+			ts.Ball = nil
+		}
+	}
+	return st
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: newMotion(), step() in motion.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestMotion(t *testing.T) {
+	a := assert.New(t)
+
+	m := newMotion(api.DefaultFieldDimensions(), api.DefaultRoster)
+
+	var prev map[string]*api.TurtleState
+	for i := 0; i < 1000; i++ {
+		sts := m.step(0.2)
+		a.Len(sts, 6)
+
+		for id, ts := range sts {
+			if !a.NoError(ts.Validate(), "turtle %s at step %d", id, i) {
+				t.FailNow()
+			}
+			if prev == nil {
+				continue
+			}
+
+			p := prev[id].Pose
+			a.True(math.Hypot(ts.Pose.X-p.X, ts.Pose.Y-p.Y) <= turtleSpeed*0.2+1e-9, "turtle %s jumped at step %d", id, i)
+		}
+		prev = sts
+	}
+}
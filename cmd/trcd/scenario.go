@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultExpectWithin is the time SRRS has to fulfill an expectation, unless specified otherwise.
+	defaultExpectWithin = 5 * time.Second
+
+	// defaultBatteryInterval is the interval between battery updates during a decline, unless specified otherwise.
+	defaultBatteryInterval = time.Second
+)
+
+// duration is a time.Duration, which is encoded in JSON as a string, e.g. "1m30s".
+type duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Wrap(err, "duration must be a string")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// batteryDecline represents a linear decline of battery voltage of turtles.
+type batteryDecline struct {
+	Turtles  []string `json:"turtles"`
+	From     uint8    `json:"from"`
+	To       uint8    `json:"to"`
+	Over     duration `json:"over"`
+	Interval duration `json:"interval,omitempty"`
+}
+
+// emergency represents a press or release of the emergency button of a turtle.
+type emergency struct {
+	Turtle  string `json:"turtle"`
+	Pressed bool   `json:"pressed"`
+}
+
+// expectation represents a state SRRS is expected to send.
+// A state sent by SRRS fulfills the expectation if it contains the command, if set,
+// and all fields set in the turtle states.
+type expectation struct {
+	Command api.Command                 `json:"command,omitempty"`
+	Turtles map[string]*api.TurtleState `json:"turtles,omitempty"`
+	Within  duration                    `json:"within,omitempty"`
+}
+
+// event is a single event of a scenario. Exactly one of the actions must be set.
+type event struct {
+	// At is the time of the event relative to the start of the scenario.
+	At duration `json:"at"`
+
+	// Comment is a human-readable description of the event, which is logged when it occurs.
+	Comment string `json:"comment,omitempty"`
+
+	State      *api.State      `json:"state,omitempty"`
+	Battery    *batteryDecline `json:"battery,omitempty"`
+	Emergency  *emergency      `json:"emergency,omitempty"`
+	Expect     *expectation    `json:"expect,omitempty"`
+	Disconnect bool            `json:"disconnect,omitempty"`
+}
+
+// scenario is a sequence of timed events used to rehearse a specific situation.
+type scenario struct {
+	Name   string   `json:"name"`
+	Events []*event `json:"events"`
+}
+
+// validate returns an error if ev is invalid.
+func (ev *event) validate() error {
+	actions := 0
+	for _, set := range []bool{ev.State != nil, ev.Battery != nil, ev.Emergency != nil, ev.Expect != nil, ev.Disconnect} {
+		if set {
+			actions++
+		}
+	}
+	if actions != 1 {
+		return errors.Errorf("expected exactly one action, got %d", actions)
+	}
+	if ev.At < 0 {
+		return errors.New("time must not be negative")
+	}
+
+	switch {
+	case ev.State != nil:
+		return ev.State.Validate()
+
+	case ev.Battery != nil:
+		switch {
+		case len(ev.Battery.Turtles) == 0:
+			return errors.New("battery decline must specify turtles")
+		case ev.Battery.From > 99 || ev.Battery.To > 99:
+			return errors.New("battery voltage must be within 0 … 99")
+		case ev.Battery.Over <= 0:
+			return errors.New("battery decline duration must be positive")
+		case ev.Battery.Interval < 0:
+			return errors.New("battery decline interval must not be negative")
+		}
+
+	case ev.Emergency != nil:
+		if ev.Emergency.Turtle == "" {
+			return errors.New("emergency must specify a turtle")
+		}
+
+	case ev.Expect != nil:
+		if ev.Expect.Command == "" && len(ev.Expect.Turtles) == 0 {
+			return errors.New("expectation must specify a command or turtles")
+		}
+		if ev.Expect.Within < 0 {
+			return errors.New("expectation timeout must not be negative")
+		}
+		return (&api.State{Command: ev.Expect.Command, Turtles: ev.Expect.Turtles}).Validate()
+	}
+	return nil
+}
+
+// readScenario reads the scenario encoded as JSON at path.
+// The events are sorted by time, events occurring at the same time keep the order of declaration.
+func readScenario(path string) (*scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open scenario")
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+
+	sc := &scenario{}
+	if err := dec.Decode(sc); err != nil {
+		return nil, errors.Wrap(err, "failed to decode scenario")
+	}
+	for i, ev := range sc.Events {
+		if ev == nil {
+			return nil, errors.Errorf("event %d is empty", i)
+		}
+		if err := ev.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid event %d", i)
+		}
+	}
+	sort.SliceStable(sc.Events, func(i, j int) bool {
+		return sc.Events[i].At < sc.Events[j].At
+	})
+	return sc, nil
+}
+
+// matches reports whether st fulfills exp.
+func (exp *expectation) matches(st *api.State) bool {
+	if exp.Command != "" && st.Command != exp.Command {
+		return false
+	}
+	for id, want := range exp.Turtles {
+		got, ok := st.Turtles[id]
+		if !ok || got == nil {
+			return false
+		}
+		if want == nil {
+			continue
+		}
+
+		equal := true
+		gv := reflect.ValueOf(got).Elem()
+		forEachSetField(want, func(i int, v reflect.Value) {
+			equal = equal && reflect.DeepEqual(v.Interface(), gv.Field(i).Interface())
+		})
+		if !equal {
+			return false
+		}
+	}
+	return true
+}
+
+// stateSender sends states to SRRS.
+type stateSender interface {
+	SendState(st *api.State) error
+}
+
+// waiter is a pending expectation.
+type waiter struct {
+	exp *expectation
+	// fulfilledCh receives the state fulfilling exp, once it is observed.
+	fulfilledCh chan *api.State
+}
+
+// scenarioRunner executes a scenario.
+// The progress is kept across connections, such that the scenario resumes, when SRRS reconnects.
+// scenarioRunner is safe for concurrent use by multiple goroutines.
+type scenarioRunner struct {
+	scenario *scenario
+	logger   *zap.Logger
+
+	// runMu ensures the scenario is only executed on a single connection at a time.
+	runMu sync.Mutex
+	// next is the index of the next event to execute.
+	next int
+	// offset is the time of the event, at which the scenario is resumed.
+	offset time.Duration
+
+	waitersMu sync.Mutex
+	waiters   map[*waiter]struct{}
+
+	// expectations tracks pending expectations.
+	expectations sync.WaitGroup
+
+	failuresMu sync.Mutex
+	failures   int
+	expected   int
+
+	finishOnce sync.Once
+	doneCh     chan error
+}
+
+// newScenarioRunner returns a new *scenarioRunner executing sc.
+func newScenarioRunner(sc *scenario, logger *zap.Logger) *scenarioRunner {
+	return &scenarioRunner{
+		scenario: sc,
+		logger:   logger.With(zap.String("scenario", sc.Name)),
+		waiters:  make(map[*waiter]struct{}),
+		doneCh:   make(chan error, 1),
+	}
+}
+
+// Done returns a channel, on which the result of the scenario is sent once all events are executed
+// and all expectations are either fulfilled or timed out.
+// The error is nil if all expectations were fulfilled.
+func (r *scenarioRunner) Done() <-chan error {
+	return r.doneCh
+}
+
+// Observe notifies the runner of state st sent by SRRS.
+// Pending expectations fulfilled by st are fulfilled before Observe returns.
+func (r *scenarioRunner) Observe(st *api.State) {
+	r.waitersMu.Lock()
+	for w := range r.waiters {
+		if w.exp.matches(st) {
+			w.fulfilledCh <- st
+			delete(r.waiters, w)
+		}
+	}
+	r.waitersMu.Unlock()
+}
+
+// Run executes the remaining events of the scenario on conn until ctx is done,
+// the scenario disconnects or all events are executed.
+// Run reports whether the connection must be closed.
+func (r *scenarioRunner) Run(ctx context.Context, conn stateSender) bool {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	start := time.Now().Add(-r.offset)
+	for r.next < len(r.scenario.Events) {
+		ev := r.scenario.Events[r.next]
+
+		select {
+		case <-ctx.Done():
+			return true
+		case <-time.After(time.Until(start.Add(time.Duration(ev.At)))):
+		}
+
+		logger := r.logger.With(
+			zap.Int("event", r.next),
+			zap.Duration("at", time.Duration(ev.At)),
+		)
+		if ev.Comment != "" {
+			logger = logger.With(zap.String("comment", ev.Comment))
+		}
+
+		r.offset = time.Duration(ev.At)
+		if ev.Disconnect {
+			logger.Info("Disconnecting...")
+			r.next++
+			return true
+		}
+
+		if err := r.execute(ctx, logger, conn, ev); err != nil {
+			logger.Error("Failed to execute event, resuming on next connection", zap.Error(err))
+			return true
+		}
+		r.next++
+	}
+
+	r.finishOnce.Do(func() {
+		go r.finish()
+	})
+	return false
+}
+
+// execute executes a single event ev on conn.
+func (r *scenarioRunner) execute(ctx context.Context, logger *zap.Logger, conn stateSender, ev *event) error {
+	switch {
+	case ev.State != nil:
+		logger.Info("Sending state...", zap.Reflect("state", ev.State))
+		return conn.SendState(ev.State)
+
+	case ev.Emergency != nil:
+		logger.Info("Toggling emergency button...",
+			zap.String("turtle", ev.Emergency.Turtle),
+			zap.Bool("pressed", ev.Emergency.Pressed),
+		)
+		pressed := ev.Emergency.Pressed
+		return conn.SendState(&api.State{
+			Turtles: map[string]*api.TurtleState{
+				ev.Emergency.Turtle: {RobotEmergencyButton: &pressed},
+			},
+		})
+
+	case ev.Battery != nil:
+		logger.Info("Starting battery decline...", zap.Reflect("battery", ev.Battery))
+		go r.declineBattery(ctx, logger, conn, ev.Battery)
+		return nil
+
+	case ev.Expect != nil:
+		logger.Info("Expecting state...", zap.Reflect("expect", ev.Expect))
+		r.expect(logger, ev.Expect)
+		return nil
+	}
+	return errors.New("event has no action")
+}
+
+// declineBattery sends linearly declining battery voltages of turtles in b until the decline is finished or ctx is done.
+func (r *scenarioRunner) declineBattery(ctx context.Context, logger *zap.Logger, conn stateSender, b *batteryDecline) {
+	interval := time.Duration(b.Interval)
+	if interval == 0 {
+		interval = defaultBatteryInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for {
+		progress := float64(time.Since(start)) / float64(b.Over)
+		if progress > 1 {
+			progress = 1
+		}
+		v := uint8(float64(b.From) + (float64(b.To)-float64(b.From))*progress + 0.5)
+
+		st := &api.State{
+			Turtles: make(map[string]*api.TurtleState, len(b.Turtles)),
+		}
+		for _, id := range b.Turtles {
+			st.Turtles[id] = &api.TurtleState{BatteryVoltage: &v}
+		}
+		if err := conn.SendState(st); err != nil {
+			logger.Error("Failed to send battery state", zap.Error(err))
+			return
+		}
+		if progress == 1 {
+			logger.Info("Battery decline finished")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expect waits in the background for SRRS to send a state fulfilling exp.
+func (r *scenarioRunner) expect(logger *zap.Logger, exp *expectation) {
+	within := time.Duration(exp.Within)
+	if within == 0 {
+		within = defaultExpectWithin
+	}
+
+	w := &waiter{
+		exp:         exp,
+		fulfilledCh: make(chan *api.State, 1),
+	}
+	r.waitersMu.Lock()
+	r.waiters[w] = struct{}{}
+	r.waitersMu.Unlock()
+
+	r.failuresMu.Lock()
+	r.expected++
+	r.failuresMu.Unlock()
+
+	r.expectations.Add(1)
+	go func() {
+		defer r.expectations.Done()
+
+		timer := time.NewTimer(within)
+		defer timer.Stop()
+
+		select {
+		case st := <-w.fulfilledCh:
+			logger.Info("Expectation fulfilled", zap.Reflect("state", st))
+			return
+
+		case <-timer.C:
+		}
+
+		r.waitersMu.Lock()
+		_, pending := r.waiters[w]
+		delete(r.waiters, w)
+		r.waitersMu.Unlock()
+
+		if !pending {
+			// The expectation was fulfilled concurrently with the timeout.
+			logger.Info("Expectation fulfilled", zap.Reflect("state", <-w.fulfilledCh))
+			return
+		}
+
+		logger.Error("Expectation not fulfilled", zap.Duration("within", within))
+		r.failuresMu.Lock()
+		r.failures++
+		r.failuresMu.Unlock()
+	}()
+}
+
+// finish waits for pending expectations and reports the result of the scenario.
+func (r *scenarioRunner) finish() {
+	r.expectations.Wait()
+
+	r.failuresMu.Lock()
+	failures, expected := r.failures, r.expected
+	r.failuresMu.Unlock()
+
+	logger := r.logger.With(
+		zap.Int("expectations", expected),
+		zap.Int("failed", failures),
+	)
+	if failures > 0 {
+		logger.Error("Scenario failed")
+		r.doneCh <- errors.Errorf("%d of %d expectations of scenario %s not fulfilled", failures, expected, r.scenario.Name)
+		return
+	}
+	logger.Info("Scenario passed")
+	r.doneCh <- nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type recordingSender struct {
+	mu     sync.Mutex
+	states []*api.State
+}
+
+func (s *recordingSender) SendState(st *api.State) error {
+	s.mu.Lock()
+	s.states = append(s.states, st)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *recordingSender) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.states)
+}
+
+//Test_items: readScenario() in scenario.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestReadScenario(t *testing.T) {
+	a := assert.New(t)
+
+	sc, err := readScenario(filepath.Join("scenarios", "corner_localization.json"))
+	if !a.Nil(err) {
+		return
+	}
+	a.NotEmpty(sc.Name)
+	for i := 1; i < len(sc.Events); i++ {
+		a.True(sc.Events[i-1].At <= sc.Events[i].At)
+	}
+
+	dir, err := ioutil.TempDir("", "trcd-scenario")
+	if !a.Nil(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	for name, s := range map[string]string{
+		"no action":      `{"events": [{"at": "1s"}]}`,
+		"two actions":    `{"events": [{"at": "1s", "disconnect": true, "emergency": {"turtle": "1"}}]}`,
+		"invalid state":  `{"events": [{"at": "1s", "state": {"command": "foo"}}]}`,
+		"unknown field":  `{"events": [{"at": "1s", "disconnect": true, "foo": 42}]}`,
+		"bad duration":   `{"events": [{"at": 1, "disconnect": true}]}`,
+		"empty expect":   `{"events": [{"at": "1s", "expect": {}}]}`,
+		"battery range":  `{"events": [{"at": "1s", "battery": {"turtles": ["1"], "from": 120, "over": "1s"}}]}`,
+		"battery period": `{"events": [{"at": "1s", "battery": {"turtles": ["1"], "from": 20}}]}`,
+	} {
+		path := filepath.Join(dir, "scenario.json")
+		if !a.Nil(ioutil.WriteFile(path, []byte(s), 0644)) {
+			return
+		}
+		_, err := readScenario(path)
+		a.NotNil(err, name)
+	}
+}
+
+//Test_items: scenarioRunner in scenario.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestScenarioRunner(t *testing.T) {
+	a := assert.New(t)
+
+	battery := uint8(20)
+	sc := &scenario{
+		Name: "test",
+		Events: []*event{
+			{State: &api.State{Turtles: map[string]*api.TurtleState{"1": {BatteryVoltage: &battery}}}},
+			{Expect: &expectation{Command: api.CommandStop, Within: duration(time.Second)}},
+			{Expect: &expectation{
+				Turtles: map[string]*api.TurtleState{"2": {Role: api.RoleInactive}},
+				Within:  duration(50 * time.Millisecond),
+			}},
+			{At: duration(10 * time.Millisecond), Disconnect: true},
+			{At: duration(20 * time.Millisecond), Emergency: &emergency{Turtle: "1", Pressed: true}},
+		},
+	}
+
+	r := newScenarioRunner(sc, zap.NewNop())
+	conn := &recordingSender{}
+
+	a.True(r.Run(context.Background(), conn))
+	a.Equal(1, conn.Len())
+
+	// States observed in a burst must not be dropped.
+	for i := 0; i < 100; i++ {
+		r.Observe(&api.State{Turtles: map[string]*api.TurtleState{"2": {Role: api.RoleAttackerMain}}})
+	}
+	r.Observe(&api.State{Command: api.CommandStop})
+
+	a.False(r.Run(context.Background(), conn))
+	a.Equal(2, conn.Len())
+
+	select {
+	case err := <-r.Done():
+		a.EqualError(err, "1 of 2 expectations of scenario test not fulfilled")
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for scenario to finish")
+	}
+}
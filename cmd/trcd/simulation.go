@@ -0,0 +1,338 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/mohae/deepcopy"
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+const (
+	// batteryFull is the battery voltage of a fully charged turtle.
+	batteryFull = 25.2
+	// batteryEmpty is the battery voltage, below which a turtle is considered empty.
+	batteryEmpty = 19.0
+	// batteryIdleDrain is the battery voltage lost per second by a turtle in the field.
+	batteryIdleDrain = 0.005
+	// batteryLoadDrain is the battery voltage lost per second per m/s of speed.
+	batteryLoadDrain = 0.01
+	// batteryCharge is the battery voltage gained per second by a turtle out of the field.
+	batteryCharge = 0.05
+
+	// crashRate is the probability per second of an executable of a turtle in the field to crash.
+	crashRate = 0.002
+	// restartDuration is the time in seconds it takes to restart a crashed executable.
+	restartDuration = 3.0
+	// maxRestartCount is the maximum restart count representable in the protocol.
+	maxRestartCount = 99
+)
+
+// assignedRoles lists the roles handed out by the role assigner in order of priority.
+var assignedRoles = []api.Role{
+	api.RoleGoalkeeper,
+	api.RoleAttackerMain,
+	api.RoleDefenderMain,
+	api.RoleAttackerAssist,
+	api.RoleDefenderAssist,
+	api.RoleDefenderAssist2,
+}
+
+// assignedRefBoxRoles lists the refbox roles handed out by the role assigner in order of priority.
+var assignedRefBoxRoles = []api.RefBoxRole{
+	api.RefBoxRole1,
+	api.RefBoxRole2,
+	api.RefBoxRole3,
+	api.RefBoxRole4,
+	api.RefBoxRole5,
+	api.RefBoxRole6,
+}
+
+// executable is a crashable executable running on a turtle.
+type executable struct {
+	status       func(*api.TurtleState) **bool
+	restartCount func(*api.TurtleState) **uint8
+}
+
+// executables lists the executables, which are simulated to crash.
+var executables = []executable{
+	{
+		status:       func(ts *api.TurtleState) **bool { return &ts.MotionStatus },
+		restartCount: func(ts *api.TurtleState) **uint8 { return &ts.RestartCountMotion },
+	},
+	{
+		status:       func(ts *api.TurtleState) **bool { return &ts.VisionStatus },
+		restartCount: func(ts *api.TurtleState) **uint8 { return &ts.RestartCountVision },
+	},
+	{
+		status:       func(ts *api.TurtleState) **bool { return &ts.WorldmodelStatus },
+		restartCount: func(ts *api.TurtleState) **uint8 { return &ts.RestartCountWorldmodel },
+	},
+}
+
+// boolPtr returns a pointer to v.
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+// uint8Ptr returns a pointer to v.
+func uint8Ptr(v uint8) *uint8 {
+	return &v
+}
+
+// simulatedTurtle is the simulated internal state of a single turtle.
+type simulatedTurtle struct {
+	state *api.TurtleState
+
+	// battery is the precise battery voltage.
+	battery float64
+	// restarting holds the remaining restart time in seconds of crashed executables by index in executables.
+	restarting map[int]float64
+}
+
+// simulation simulates turtles, which react coherently to commands sent by SRRS.
+// simulation is safe for concurrent use by multiple goroutines.
+type simulation struct {
+	mu sync.Mutex
+
+	motion  *motion
+	turtles map[string]*simulatedTurtle
+
+	command      api.Command
+	running      bool
+	roleAssigner bool
+}
+
+// newSimulation returns a new simulation of turtles identified by ids on field.
+// All turtles are initially charged, out of the field and members of the cyan team defending the blue goal.
+func newSimulation(field api.FieldDimensions, ids []string) *simulation {
+	sim := &simulation{
+		motion:  newMotion(field, ids),
+		turtles: make(map[string]*simulatedTurtle, len(ids)),
+		command: api.CommandStop,
+	}
+	for _, id := range ids {
+		sim.turtles[id] = &simulatedTurtle{
+			state: &api.TurtleState{
+				VisionStatus:           boolPtr(true),
+				MotionStatus:           boolPtr(true),
+				WorldmodelStatus:       boolPtr(true),
+				AppmanStatus:           boolPtr(true),
+				RestartCountMotion:     uint8Ptr(0),
+				RestartCountVision:     uint8Ptr(0),
+				RestartCountWorldmodel: uint8Ptr(0),
+				BallFound:              api.BallFoundNo,
+				LocalizationStatus:     api.LocalizationStatusLocalization,
+				CPB:                    api.CPBNo,
+				EmergencyStatus:        uint8Ptr(0),
+				Role:                   api.RoleNone,
+				RobotInField:           boolPtr(false),
+				RobotEmergencyButton:   boolPtr(false),
+				HomeGoal:               api.HomeGoalBlue,
+				TeamColor:              api.TeamColorCyan,
+				ActiveDevPC:            uint8Ptr(0),
+				Kinect1State:           api.KinectStateNoBall,
+				Kinect2State:           api.KinectStateNoBall,
+			},
+			battery:    batteryFull,
+			restarting: make(map[int]float64),
+		}
+		sim.motion.hold(id, true)
+	}
+	// All turtles are held, hence this only obtains the initial positions.
+	for id, ts := range sim.motion.step(0) {
+		t := sim.turtles[id]
+		t.state.Pose, t.state.Velocity, t.state.Ball = ts.Pose, ts.Velocity, ts.Ball
+	}
+	sim.assignRoles()
+	return sim
+}
+
+// State returns the complete current state of the simulation.
+func (sim *simulation) State() *api.State {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.state()
+}
+
+// state returns the complete current state of the simulation.
+// sim.mu must be held by the caller.
+func (sim *simulation) state() *api.State {
+	st := &api.State{
+		Command: sim.command,
+		Turtles: make(map[string]*api.TurtleState, len(sim.turtles)),
+	}
+	for id, t := range sim.turtles {
+		t.state.BatteryVoltage = uint8Ptr(uint8(math.Round(t.battery)))
+		st.Turtles[id] = deepcopy.Copy(t.state).(*api.TurtleState)
+	}
+	return st
+}
+
+// Handle applies the state st sent by SRRS and returns the resulting state of the simulation.
+// Only writable turtle fields of st are applied.
+func (sim *simulation) Handle(st *api.State) (*api.State, error) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	for id := range st.Turtles {
+		if _, ok := sim.turtles[id]; !ok {
+			return nil, errors.Errorf("unknown turtle %s", id)
+		}
+	}
+
+	for id, ts := range st.Turtles {
+		if ts == nil {
+			continue
+		}
+
+		t := sim.turtles[id].state
+		if ts.Role != "" {
+			t.Role = ts.Role
+		}
+		if ts.RefBoxRole != "" {
+			t.RefBoxRole = ts.RefBoxRole
+		}
+		if ts.RobotInField != nil {
+			t.RobotInField = boolPtr(*ts.RobotInField)
+		}
+		if ts.HomeGoal != "" {
+			t.HomeGoal = ts.HomeGoal
+		}
+		if ts.TeamColor != "" {
+			t.TeamColor = ts.TeamColor
+		}
+	}
+
+	switch st.Command {
+	case "":
+
+	case api.CommandGoIn:
+		for _, t := range sim.turtles {
+			t.state.RobotInField = boolPtr(t.state.Role != api.RoleInactive)
+		}
+
+	case api.CommandGoOut:
+		sim.running = false
+		for _, t := range sim.turtles {
+			t.state.RobotInField = boolPtr(false)
+		}
+
+	case api.CommandStart:
+		sim.running = true
+
+	case api.CommandStop:
+		sim.running = false
+
+	case api.CommandRoleAssignerOn:
+		sim.roleAssigner = true
+
+	case api.CommandRoleAssignerOff:
+		sim.roleAssigner = false
+	}
+	if st.Command != "" {
+		sim.command = st.Command
+	}
+
+	if sim.roleAssigner {
+		sim.assignRoles()
+	}
+	sim.holdTurtles()
+	return sim.state(), nil
+}
+
+// assignRoles hands out roles and refbox roles to turtles, which are not inactive, in order of their IDs.
+// sim.mu must be held by the caller.
+func (sim *simulation) assignRoles() {
+	ids := make([]string, 0, len(sim.turtles))
+	for id := range sim.turtles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	i := 0
+	for _, id := range ids {
+		t := sim.turtles[id].state
+		if t.Role == api.RoleInactive {
+			continue
+		}
+		if i < len(assignedRoles) {
+			t.Role = assignedRoles[i]
+			t.RefBoxRole = assignedRefBoxRoles[i]
+		} else {
+			t.Role = api.RoleNone
+		}
+		i++
+	}
+}
+
+// holdTurtles keeps turtles in place, which may not move.
+// sim.mu must be held by the caller.
+func (sim *simulation) holdTurtles() {
+	for id, t := range sim.turtles {
+		sim.motion.hold(id, !sim.running || !sim.canMove(t))
+	}
+}
+
+// canMove reports whether the turtle t is able to move on its own.
+func (sim *simulation) canMove(t *simulatedTurtle) bool {
+	return t.state.RobotInField != nil && *t.state.RobotInField &&
+		t.state.Role != api.RoleInactive &&
+		t.state.MotionStatus != nil && *t.state.MotionStatus &&
+		t.battery > batteryEmpty
+}
+
+// Step advances the simulation by dt seconds and returns the complete resulting state.
+func (sim *simulation) Step(dt float64) *api.State {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	for _, t := range sim.turtles {
+		inField := t.state.RobotInField != nil && *t.state.RobotInField
+
+		for i, exe := range executables {
+			status := exe.status(t.state)
+			if left, ok := t.restarting[i]; ok {
+				if left -= dt; left > 0 {
+					t.restarting[i] = left
+					continue
+				}
+				delete(t.restarting, i)
+				*status = boolPtr(true)
+				continue
+			}
+
+			if inField && rand.Float64() < crashRate*dt {
+				t.restarting[i] = restartDuration
+				*status = boolPtr(false)
+
+				count := exe.restartCount(t.state)
+				if *count == nil {
+					*count = uint8Ptr(0)
+				}
+				if **count < maxRestartCount {
+					*count = uint8Ptr(**count + 1)
+				}
+			}
+		}
+	}
+	sim.holdTurtles()
+
+	for id, ts := range sim.motion.step(dt) {
+		t := sim.turtles[id]
+		t.state.Pose = ts.Pose
+		t.state.Velocity = ts.Velocity
+		t.state.Ball = ts.Ball
+
+		if t.state.RobotInField != nil && *t.state.RobotInField {
+			speed := math.Hypot(ts.Velocity.X, ts.Velocity.Y)
+			t.battery = math.Max(0, t.battery-(batteryIdleDrain+batteryLoadDrain*speed)*dt)
+		} else {
+			t.battery = math.Min(batteryFull, t.battery+batteryCharge*dt)
+		}
+	}
+	return sim.state()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: newSimulation(), Handle(), Step() in simulation.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestSimulation(t *testing.T) {
+	a := assert.New(t)
+
+	sim := newSimulation(api.DefaultFieldDimensions(), []string{"1", "2", "3"})
+
+	st := sim.State()
+	a.Nil(st.Validate())
+	a.Nil(st.ValidateTeam())
+	a.Equal(api.RoleGoalkeeper, st.Turtles["1"].Role)
+	a.Equal(api.RoleAttackerMain, st.Turtles["2"].Role)
+	a.Equal(api.RoleDefenderMain, st.Turtles["3"].Role)
+
+	_, err := sim.Handle(&api.State{Turtles: map[string]*api.TurtleState{"42": {Role: api.RoleInactive}}})
+	a.NotNil(err)
+
+	st, err = sim.Handle(&api.State{Command: api.CommandRoleAssignerOn})
+	a.Nil(err)
+	a.Equal(api.CommandRoleAssignerOn, st.Command)
+
+	st, err = sim.Handle(&api.State{Turtles: map[string]*api.TurtleState{"1": {Role: api.RoleInactive}}})
+	a.Nil(err)
+	a.Equal(api.RoleInactive, st.Turtles["1"].Role)
+	a.Equal(api.RoleGoalkeeper, st.Turtles["2"].Role)
+	a.Equal(api.RoleAttackerMain, st.Turtles["3"].Role)
+
+	st, err = sim.Handle(&api.State{Command: api.CommandGoIn})
+	a.Nil(err)
+	a.False(*st.Turtles["1"].RobotInField)
+	a.True(*st.Turtles["2"].RobotInField)
+	a.True(*st.Turtles["3"].RobotInField)
+
+	// Turtles stay in place until the game is started.
+	before := sim.State()
+	st = sim.Step(0.2)
+	a.Equal(before.Turtles["2"].Pose, st.Turtles["2"].Pose)
+
+	_, err = sim.Handle(&api.State{Command: api.CommandStart})
+	a.Nil(err)
+	for i := 0; i < 10; i++ {
+		st = sim.Step(0.2)
+	}
+	a.NotEqual(before.Turtles["2"].Pose, st.Turtles["2"].Pose)
+	a.Equal(before.Turtles["1"].Pose, st.Turtles["1"].Pose)
+	a.Nil(st.Validate())
+
+	// Batteries drain in the field and recharge out of it.
+	for i := 0; i < 1000; i++ {
+		st = sim.Step(1)
+	}
+	a.True(*st.Turtles["2"].BatteryVoltage < *before.Turtles["2"].BatteryVoltage)
+	a.Equal(*before.Turtles["1"].BatteryVoltage, *st.Turtles["1"].BatteryVoltage)
+
+	drained := *st.Turtles["2"].BatteryVoltage
+	st, err = sim.Handle(&api.State{Command: api.CommandGoOut})
+	a.Nil(err)
+	a.False(*st.Turtles["2"].RobotInField)
+	for i := 0; i < 100; i++ {
+		st = sim.Step(1)
+	}
+	a.True(*st.Turtles["2"].BatteryVoltage > drained)
+	a.Nil(st.Validate())
+}
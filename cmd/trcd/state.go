@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+// readState reads the valid JSON-encoded state at path.
+func readState(path string) (*api.State, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open state")
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+
+	st := &api.State{}
+	if err := dec.Decode(st); err != nil {
+		return nil, errors.Wrap(err, "failed to decode state")
+	}
+	if err := st.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid state")
+	}
+	return st, nil
+}
+
+// forEachSetField calls f with the index and value of every non-zero field of ts.
+func forEachSetField(ts *api.TurtleState, f func(i int, v reflect.Value)) {
+	tv := reflect.ValueOf(ts).Elem()
+	for i := 0; i < tv.NumField(); i++ {
+		if v := tv.Field(i); !reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface()) {
+			f(i, v)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: readState() in state.go, randomToken() in token.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestReadState(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "trcd-state")
+	if !a.Nil(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	for s, valid := range map[string]bool{
+		`{"command":"stop","turtles":{"1":{"batteryvoltage":24}}}`: true,
+		`{"command":"bogus"}`:                      false,
+		`{"turtles":{"1":{"batteryvoltage":120}}}`: false,
+		`{"foo":"bar"}`:                            false,
+		`{`:                                        false,
+	} {
+		if !a.Nil(ioutil.WriteFile(path, []byte(s), 0644)) {
+			return
+		}
+		st, err := readState(path)
+		if !valid {
+			a.NotNil(err, s)
+			continue
+		}
+		if a.Nil(err, s) {
+			a.Equal(api.CommandStop, st.Command)
+		}
+	}
+
+	_, err = readState(filepath.Join(dir, "missing.json"))
+	a.NotNil(err)
+
+	a.Len(randomToken(), 2*randomTokenSize)
+	a.NotEqual(randomToken(), randomToken())
+}
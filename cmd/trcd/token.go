@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// randomTokenSize is the size of random tokens in bytes.
+const randomTokenSize = 16
+
+// randomToken returns a new random hex-encoded token.
+func randomToken() string {
+	b := make([]byte, randomTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// rotateTokens sets a new random token on ctl every interval until closeCh is closed.
+func rotateTokens(ctl *control, interval time.Duration, closeCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := ctl.SetToken(randomToken()); err != nil {
+				zap.L().Warn("Failed to rotate token", zap.Error(err))
+			}
+		case <-closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Command trcproxy is a protocol-aware proxy, which sits between SRRS and TRC,
+// forwards the traffic unchanged and logs a timeline of the exchanged messages.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/recording"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	debug       = flag.Bool("debug", false, "Debug mode")
+	unixSock    = flag.String("unixSocket", filepath.Join(os.TempDir(), "trcproxy.sock"), "Path to the unix socket to listen on for SRRS")
+	tcpSock     = flag.String("tcpSocket", "", "Service address of TCP socket to listen on for SRRS. TCP will be used instead of a Unix socket when this is set")
+	trcUnixSock = flag.String("trcUnixSocket", filepath.Join(os.TempDir(), "trc.sock"), "Path to the unix socket of TRC")
+	trcTCPSock  = flag.String("trcTCPSocket", "", "Service address of TCP socket of TRC. TCP will be used instead of a Unix socket when this is set")
+	httpAddr    = flag.String("http", "", "Address to serve the timeline on over HTTP. The timeline is not served when empty")
+	timeout     = flag.Duration("timeout", 5*time.Second, "Duration after which requests without a response are reported")
+	maxEvents   = flag.Int("maxEvents", 1000, "Maximum amount of events kept for the HTTP timeline")
+)
+
+// timelineTemplate is the template of the HTML timeline page.
+var timelineTemplate = template.Must(template.New("timeline").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="2">
+<title>TRC proxy timeline</title>
+<style>
+body { font-family: monospace; }
+td { padding: 0 0.5em; vertical-align: top; }
+.problem { color: #c00; }
+</style>
+</head>
+<body>
+<table>
+<tr><th>Time</th><th>Direction</th><th>Type</th><th>Message ID</th><th>Parent ID</th><th>Latency</th><th>Payload</th></tr>
+{{range .}}<tr>
+<td>{{.Time.Format "15:04:05.000"}}</td><td>{{.Direction}}</td><td>{{.Type}}</td><td>{{.MessageID}}</td><td>{{.ParentID}}</td><td>{{if .Latency}}{{.Latency}}{{end}}</td><td>{{printf "%s" .Payload}}</td>
+</tr>{{range .Problems}}
+<tr class="problem"><td></td><td colspan="6">{{.}}</td></tr>{{end}}
+{{end}}</table>
+</body>
+</html>
+`))
+
+// expireInterval is the interval, at which requests without a response are looked for.
+const expireInterval = 100 * time.Millisecond
+
+// logEvent logs e using logger.
+// Events, in which problems were detected, are logged with warning level.
+func logEvent(logger *zap.Logger, e *Event) {
+	fields := []zap.Field{
+		zap.String("direction", directionArrow(e.Direction)),
+	}
+	if e.Type != "" {
+		fields = append(fields, zap.String("type", string(e.Type)))
+	}
+	if e.MessageID != "" {
+		fields = append(fields, zap.String("message_id", e.MessageID))
+	}
+	if e.ParentID != "" {
+		fields = append(fields, zap.String("parent_id", e.ParentID))
+	}
+	if e.Latency > 0 {
+		fields = append(fields, zap.Duration("latency", e.Latency))
+	}
+
+	if len(e.Problems) > 0 {
+		logger.Warn("Protocol violation", append(fields, zap.Strings("problems", e.Problems))...)
+		return
+	}
+	logger.Info("Message", fields...)
+}
+
+func main() {
+	flag.Parse()
+
+	conf := zap.NewProductionConfig()
+	conf.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	if *debug {
+		conf = zap.NewDevelopmentConfig()
+		conf.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+
+	logger, err := conf.Build()
+	if err != nil {
+		panic(err)
+	}
+
+	zap.RedirectStdLog(logger)
+	zap.ReplaceGlobals(logger)
+
+	if err := func() error {
+		defer logger.Sync() //nolint
+
+		tl := NewTimeline(*maxEvents, *timeout)
+		tl.Subscribe(func(e *Event) {
+			logEvent(logger, e)
+		})
+
+		expireTicker := time.NewTicker(expireInterval)
+		defer expireTicker.Stop()
+		go func() {
+			for now := range expireTicker.C {
+				tl.Expire(now)
+			}
+		}()
+
+		var netLst net.Listener
+		if *tcpSock == "" {
+			logger := logger.With(zap.String("path", *unixSock))
+
+			logger.Info("Listening on Unix socket...")
+			netLst, err = net.Listen("unix", *unixSock)
+			if err != nil {
+				return errors.Wrap(err, "failed to listen on Unix socket")
+			}
+		} else {
+			logger := logger.With(zap.String("addr", *tcpSock))
+
+			logger.Info("Listening on TCP socket...")
+			netLst, err = net.Listen("tcp", *tcpSock)
+			if err != nil {
+				return errors.Wrap(err, "failed to listen on TCP socket")
+			}
+		}
+		defer netLst.Close()
+
+		trcNetwork, trcAddr := "unix", *trcUnixSock
+		if *trcTCPSock != "" {
+			trcNetwork, trcAddr = "tcp", *trcTCPSock
+		}
+
+		errCh := make(chan error, 1)
+		if *httpAddr != "" {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				if err := timelineTemplate.Execute(w, tl.Events()); err != nil {
+					logger.Error("Failed to render timeline", zap.Error(err))
+				}
+			})
+			mux.HandleFunc("/timeline.json", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if err := json.NewEncoder(w).Encode(tl.Events()); err != nil {
+					logger.Error("Failed to write timeline", zap.Error(err))
+				}
+			})
+
+			go func() {
+				logger.Info("Serving timeline over HTTP...", zap.String("addr", *httpAddr))
+				if err := http.ListenAndServe(*httpAddr, mux); err != nil {
+					errCh <- errors.Wrap(err, "failed to serve HTTP")
+				}
+			}()
+		}
+
+		go func() {
+			for {
+				srrsConn, err := netLst.Accept()
+				if err != nil {
+					errCh <- errors.Wrap(err, "failed to accept connection")
+					return
+				}
+
+				go func() {
+					defer srrsConn.Close()
+
+					logger := logger.With(zap.Stringer("addr", srrsConn.RemoteAddr()))
+					logger.Info("Connection accepted, dialing TRC...",
+						zap.String("network", trcNetwork),
+						zap.String("trc_addr", trcAddr),
+					)
+
+					trcConn, err := net.Dial(trcNetwork, trcAddr)
+					if err != nil {
+						logger.Error("Failed to dial TRC", zap.Error(err))
+						return
+					}
+					defer trcConn.Close()
+
+					neg := newNegotiation()
+					doneCh := make(chan error, 2)
+					go func() {
+						defer neg.done()
+						doneCh <- pipe(tl, recording.DirectionToTRC, trcConn, srrsConn, neg)
+					}()
+					go func() {
+						doneCh <- pipe(tl, recording.DirectionToSRRS, srrsConn, trcConn, neg)
+					}()
+
+					if err := <-doneCh; err != nil {
+						logger.Warn("Connection failed", zap.Error(err))
+					}
+					logger.Info("Connection closed")
+				}()
+			}
+		}()
+
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+
+		select {
+		case err := <-errCh:
+			return err
+		case sig := <-c:
+			logger.Info("Received signal, exiting...",
+				zap.Stringer("signal", sig),
+			)
+		}
+		return nil
+	}(); err != nil {
+		logger.With(zap.Error(err)).Fatal("TRC proxy failed")
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/recording"
+)
+
+// frameHeaderSize is the size of the length prefix of MessagePack frames in bytes.
+const frameHeaderSize = 4
+
+// malformedError is returned if the data read cannot be split into messages.
+type malformedError struct {
+	err error
+}
+
+// Error implements error.
+func (err *malformedError) Error() string {
+	return err.err.Error()
+}
+
+// malformed returns err wrapped in a *malformedError.
+func malformed(err error) error {
+	return &malformedError{err: err}
+}
+
+// negotiation tracks the encoding negotiated during the handshake on a single proxied connection.
+type negotiation struct {
+	// encCh receives the encoding chosen by SRRS and is closed once the pipe to TRC returns.
+	encCh chan api.Encoding
+}
+
+// newNegotiation returns a new *negotiation.
+func newNegotiation() *negotiation {
+	return &negotiation{
+		encCh: make(chan api.Encoding, 1),
+	}
+}
+
+// done must be called once the pipe forwarding messages to TRC returns.
+func (neg *negotiation) done() {
+	close(neg.encCh)
+}
+
+// encoding inspects the message b forwarded in direction dir and returns the encoding,
+// which is used for the messages following b in direction dir, if b completes the negotiation.
+// If b is a handshake request offering encodings, encoding blocks until the response is forwarded to TRC.
+func (neg *negotiation) encoding(dir recording.Direction, b []byte) (api.Encoding, bool) {
+	var msg api.Message
+	if err := json.Unmarshal(b, &msg); err != nil || msg.Type != api.MessageTypeHandshake {
+		return "", false
+	}
+
+	var hs api.Handshake
+	if err := json.Unmarshal(msg.Payload, &hs); err != nil {
+		return "", false
+	}
+
+	switch {
+	case dir == recording.DirectionToTRC && msg.ParentID != nil:
+		select {
+		case neg.encCh <- hs.Encoding:
+		default:
+		}
+		return hs.Encoding, hs.Encoding != ""
+
+	case dir == recording.DirectionToSRRS && msg.ParentID == nil && len(hs.Encodings) > 0:
+		enc := <-neg.encCh
+		return enc, enc != ""
+	}
+	return "", false
+}
+
+// isSpace reports whether c is JSON whitespace.
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n':
+		return true
+	}
+	return false
+}
+
+// readSpace reads the whitespace buffered by br up to and including the first newline and appends it to buf.
+func readSpace(br *bufio.Reader, buf []byte) []byte {
+	for br.Buffered() > 0 {
+		b, err := br.Peek(1)
+		if err != nil || !isSpace(b[0]) {
+			return buf
+		}
+		br.ReadByte() //nolint
+		buf = append(buf, b[0])
+		if b[0] == '\n' {
+			return buf
+		}
+	}
+	return buf
+}
+
+// readJSON reads the next JSON object from br and returns it exactly as read, including the surrounding whitespace.
+// Whitespace following the object is only included up to the first newline and if it is already buffered.
+// The data returned is not validated beyond matching the brackets.
+// readJSON returns a *malformedError along with the data read, if the data is not an object or is truncated.
+func readJSON(br *bufio.Reader) ([]byte, error) {
+	var (
+		buf      []byte
+		depth    int
+		inString bool
+		escaped  bool
+	)
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF && depth > 0 {
+				err = malformed(io.ErrUnexpectedEOF)
+			}
+			return buf, err
+		}
+		buf = append(buf, c)
+
+		switch {
+		case depth == 0:
+			if isSpace(c) {
+				continue
+			}
+			if c != '{' {
+				return buf, malformed(errors.New("expected a JSON object"))
+			}
+			depth++
+
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+
+		case c == '"':
+			inString = true
+
+		case c == '{' || c == '[':
+			depth++
+
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return readSpace(br, buf), nil
+			}
+		}
+	}
+}
+
+// readFrame reads the next length-prefixed frame from br and returns it exactly as read, including the header.
+// If skipSpace is true, whitespace preceding the frame is read and returned as well.
+// readFrame returns a *malformedError along with the data read, if the frame exceeds trcapi.MaxFrameSize or is truncated.
+func readFrame(br *bufio.Reader, skipSpace bool) ([]byte, error) {
+	var buf []byte
+	for skipSpace {
+		b, err := br.Peek(1)
+		if err != nil {
+			return buf, err
+		}
+		if !isSpace(b[0]) {
+			break
+		}
+		br.ReadByte() //nolint
+		buf = append(buf, b[0])
+	}
+
+	header := make([]byte, frameHeaderSize)
+	n, err := io.ReadFull(br, header)
+	buf = append(buf, header[:n]...)
+	switch {
+	case err == io.EOF && n > 0, err == io.ErrUnexpectedEOF:
+		return buf, malformed(io.ErrUnexpectedEOF)
+	case err != nil:
+		return buf, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size > trcapi.MaxFrameSize {
+		return buf, malformed(errors.Errorf("frame size %d exceeds maximum of %d", size, trcapi.MaxFrameSize))
+	}
+
+	pld := make([]byte, size)
+	n, err = io.ReadFull(br, pld)
+	buf = append(buf, pld[:n]...)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return buf, malformed(io.ErrUnexpectedEOF)
+	}
+	return buf, err
+}
+
+// decodeFrame decodes the message in frame encoded using enc and returns its JSON encoding.
+// Whitespace preceding frame is skipped, which is unambiguous, since the headers of valid frames start with a zero byte.
+func decodeFrame(frame []byte, enc api.Encoding) (json.RawMessage, error) {
+	dec, err := trcapi.NewDecoder(bytes.NewReader(bytes.TrimLeft(frame, " \t\r\n")), enc)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// pipe forwards the data read from r to w unchanged and records the messages contained in it on tl as sent in direction dir.
+// Messages are read as JSON until an encoding is negotiated on neg, after which the negotiated encoding is used.
+// Each message is recorded before it is forwarded, such that the response cannot be recorded before the request.
+// Malformed messages are recorded and forwarded as well. If the stream cannot be split into messages anymore,
+// the rest of it is forwarded without inspection.
+func pipe(tl *Timeline, dir recording.Direction, w io.Writer, r io.Reader, neg *negotiation) error {
+	br := bufio.NewReader(r)
+
+	var (
+		encoding api.Encoding
+		// first specifies whether the next frame is the first one after the negotiation.
+		first bool
+	)
+	for {
+		var (
+			b   []byte
+			raw json.RawMessage
+			err error
+		)
+		if encoding == "" {
+			b, err = readJSON(br)
+			raw = bytes.TrimSpace(b)
+		} else {
+			b, err = readFrame(br, first)
+			first = false
+		}
+
+		if err != nil {
+			_, isMalformed := err.(*malformedError)
+			if isMalformed {
+				tl.Malformed(dir, err)
+			}
+			if _, werr := w.Write(b); werr != nil {
+				return errors.Wrap(werr, "failed to forward data")
+			}
+			switch {
+			case err == io.EOF:
+				return nil
+			case !isMalformed:
+				return err
+			}
+			_, err = io.Copy(w, br)
+			return err
+		}
+
+		if encoding != "" {
+			raw, err = decodeFrame(b, encoding)
+		} else {
+			var v interface{}
+			err = json.Unmarshal(raw, &v)
+		}
+		if err != nil {
+			tl.Malformed(dir, err)
+		} else {
+			tl.Observe(dir, raw)
+		}
+
+		if _, werr := w.Write(b); werr != nil {
+			return errors.Wrap(werr, "failed to forward message")
+		}
+
+		if encoding != "" || err != nil {
+			continue
+		}
+		enc, ok := neg.encoding(dir, raw)
+		switch {
+		case !ok, enc == api.EncodingJSON:
+		case enc == api.EncodingMsgPack:
+			encoding, first = enc, true
+		default:
+			return errors.Errorf("unsupported encoding: %s", enc)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/recording"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: pipe() in pipe.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestPipeMsgPack(t *testing.T) {
+	a := assert.New(t)
+
+	srrsConn, proxySRRSConn := net.Pipe()
+	trcConn, proxyTRCConn := net.Pipe()
+
+	tl := NewTimeline(100, time.Hour)
+	neg := newNegotiation()
+
+	pipeErrCh := make(chan error, 2)
+	go func() {
+		defer neg.done()
+		pipeErrCh <- pipe(tl, recording.DirectionToTRC, proxyTRCConn, proxySRRSConn, neg)
+	}()
+	go func() {
+		pipeErrCh <- pipe(tl, recording.DirectionToSRRS, proxySRRSConn, proxyTRCConn, neg)
+	}()
+
+	trc := trctest.Connect(trcConn, trcConn,
+		trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+		trctest.WithHandler(api.MessageTypeState, trctest.DefaultStateHandler),
+		trctest.WithHandler(api.MessageTypePing, trctest.DefaultPingHandler),
+	)
+	go func() {
+		for err := range trc.Errors() {
+			t.Errorf("TRC error: %s", err)
+		}
+	}()
+
+	hsErrCh := make(chan error, 1)
+	go func() {
+		hsErrCh <- trc.SendHandshake(&api.Handshake{
+			Version:   trcapi.DefaultVersion,
+			Encodings: []api.Encoding{api.EncodingMsgPack, api.EncodingJSON},
+		})
+	}()
+
+	conn, err := trcapi.Connect(trcapi.DefaultVersion, srrsConn, srrsConn)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	frameErrCh := make(chan error, 1)
+	go func() {
+		for err := range conn.Errors() {
+			if trcapi.IsFrameError(err) {
+				frameErrCh <- err
+				continue
+			}
+			t.Errorf("SRRS error: %s", err)
+		}
+	}()
+
+	select {
+	case err := <-hsErrCh:
+		a.NoError(err)
+	case <-time.After(time.Second):
+		t.Fatal("Handshake response not received")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	a.NoError(conn.Ping(ctx))
+	a.NoError(conn.SetCommand(ctx, api.CommandStart))
+
+	ch, closeFn, err := conn.SubscribeStateChanges(ctx)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(trc.SendState(&api.State{
+		Command: api.CommandStop,
+	}))
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("No update received")
+	}
+	closeFn()
+
+	// A malformed frame must be recorded, reported and skipped without tearing down the connection.
+	_, err = trcConn.Write([]byte{0, 0, 0, 1, 0xc1})
+	a.NoError(err)
+	select {
+	case <-frameErrCh:
+	case <-time.After(time.Second):
+		t.Fatal("Malformed frame not reported")
+	}
+	a.NoError(conn.Ping(ctx))
+
+	conn.Close()
+	trc.Close()
+	srrsConn.Close()
+	trcConn.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pipeErrCh:
+		case <-time.After(time.Second):
+			t.Fatal("Pipe did not return")
+		}
+	}
+	proxySRRSConn.Close()
+	proxyTRCConn.Close()
+
+	var (
+		encoding  api.Encoding
+		malformed int
+		observed  = map[recording.Direction]map[api.MessageType]int{}
+	)
+	for _, e := range tl.Events() {
+		for _, p := range e.Problems {
+			if !strings.HasPrefix(p, "malformed message") {
+				t.Errorf("Unexpected problem in %s: %s", e, p)
+				continue
+			}
+			a.Equal(recording.DirectionToSRRS, e.Direction)
+			malformed++
+		}
+		if e.Type == "" {
+			continue
+		}
+
+		if observed[e.Direction] == nil {
+			observed[e.Direction] = map[api.MessageType]int{}
+		}
+		observed[e.Direction][e.Type]++
+
+		if e.Type == api.MessageTypeHandshake && e.ParentID != "" {
+			var hs api.Handshake
+			a.NoError(json.Unmarshal(e.Payload, &hs))
+			encoding = hs.Encoding
+		}
+	}
+	a.Equal(api.EncodingMsgPack, encoding)
+	a.Equal(1, malformed)
+	for _, dir := range []recording.Direction{recording.DirectionToTRC, recording.DirectionToSRRS} {
+		for _, typ := range []api.MessageType{api.MessageTypeHandshake, api.MessageTypePing, api.MessageTypeState} {
+			a.NotZero(observed[dir][typ], "%s not observed in direction %s", typ, dir)
+		}
+	}
+}
+
+//Test_items: pipe() in pipe.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestPipeUnchanged(t *testing.T) {
+	a := assert.New(t)
+
+	g := apitest.NewTestGenerator(t)
+
+	in := &bytes.Buffer{}
+	fmt.Fprintf(in, " \t%s\n\n", mustMarshal(api.NewMessage(api.MessageTypePing, nil, nil)))
+	in.WriteString(`{"type":"ping","message_id":}` + "\n")
+	fmt.Fprintf(in, "%s\n", mustMarshal(api.NewMessage(api.MessageTypeHandshake, mustMarshal(&api.Handshake{
+		Version:  trcapi.DefaultVersion,
+		Encoding: api.EncodingMsgPack,
+	}), g.ULID())))
+
+	enc, err := trcapi.NewEncoder(in, api.EncodingMsgPack)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(enc.Encode(api.NewMessage(api.MessageTypePing, nil, nil)))
+	in.Write([]byte{0, 0, 0, 1, 0xc1})
+	a.NoError(enc.Encode(api.NewMessage(api.MessageTypeState, mustMarshal(g.State()), nil)))
+	// Truncated frame.
+	in.Write([]byte{0, 0, 0, 42, 0x80})
+
+	expected := append([]byte{}, in.Bytes()...)
+
+	tl := NewTimeline(100, time.Hour)
+	neg := newNegotiation()
+	defer neg.done()
+
+	out := &bytes.Buffer{}
+	a.NoError(pipe(tl, recording.DirectionToTRC, out, in, neg))
+	a.Equal(expected, out.Bytes())
+
+	var types []api.MessageType
+	var malformed int
+	for _, e := range tl.Events() {
+		if e.Type == "" {
+			malformed++
+			continue
+		}
+		types = append(types, e.Type)
+	}
+	a.Equal([]api.MessageType{
+		api.MessageTypePing,
+		api.MessageTypeHandshake,
+		api.MessageTypePing,
+		api.MessageTypeState,
+	}, types)
+	a.Equal(3, malformed)
+}
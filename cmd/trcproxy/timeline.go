@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/recording"
+)
+
+// Event is an entry of the timeline.
+type Event struct {
+	Time      time.Time           `json:"time"`
+	Direction recording.Direction `json:"direction"`
+	Type      api.MessageType     `json:"type,omitempty"`
+	MessageID string              `json:"message_id,omitempty"`
+	ParentID  string              `json:"parent_id,omitempty"`
+	// Latency is the time elapsed since the request, if the message is a response to a known request.
+	Latency time.Duration `json:"latency,omitempty"`
+	// Problems are the protocol violations detected in the message.
+	Problems []string        `json:"problems,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+}
+
+// String returns a human-readable representation of the event.
+func (e *Event) String() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s %s", e.Time.Format("15:04:05.000"), directionArrow(e.Direction))
+	if e.Type != "" {
+		fmt.Fprintf(buf, " %-9s", e.Type)
+	}
+	if e.MessageID != "" {
+		fmt.Fprintf(buf, " %s", e.MessageID)
+	}
+	if e.ParentID != "" {
+		fmt.Fprintf(buf, " reply to %s", e.ParentID)
+		if e.Latency > 0 {
+			fmt.Fprintf(buf, " after %s", e.Latency)
+		}
+	}
+	for _, p := range e.Problems {
+		fmt.Fprintf(buf, "\n\t! %s", p)
+	}
+	return buf.String()
+}
+
+// directionArrow returns a human-readable representation of dir.
+func directionArrow(dir recording.Direction) string {
+	switch dir {
+	case recording.DirectionToTRC:
+		return "SRRS -> TRC "
+	case recording.DirectionToSRRS:
+		return "TRC  -> SRRS"
+	default:
+		return string(dir)
+	}
+}
+
+// pendingRequest is a request, which did not receive a response yet.
+type pendingRequest struct {
+	time      time.Time
+	typ       api.MessageType
+	direction recording.Direction
+}
+
+// Timeline is a log of inspected messages.
+// Timeline is safe for concurrent use by multiple goroutines.
+type Timeline struct {
+	mu *sync.RWMutex
+
+	timeout   time.Duration
+	maxEvents int
+
+	events  []*Event
+	pending map[ulid.ULID]pendingRequest
+
+	subsMu *sync.RWMutex
+	subs   []func(*Event)
+}
+
+// NewTimeline returns a new Timeline, which keeps at most maxEvents events and
+// reports requests, which did not receive a response within timeout.
+func NewTimeline(maxEvents int, timeout time.Duration) *Timeline {
+	return &Timeline{
+		mu:        &sync.RWMutex{},
+		timeout:   timeout,
+		maxEvents: maxEvents,
+		pending:   make(map[ulid.ULID]pendingRequest),
+		subsMu:    &sync.RWMutex{},
+	}
+}
+
+// Subscribe registers f to be called on each new event.
+func (tl *Timeline) Subscribe(f func(*Event)) {
+	tl.subsMu.Lock()
+	tl.subs = append(tl.subs, f)
+	tl.subsMu.Unlock()
+}
+
+// Events returns the events currently stored in the timeline.
+func (tl *Timeline) Events() []*Event {
+	tl.mu.RLock()
+	es := make([]*Event, len(tl.events))
+	copy(es, tl.events)
+	tl.mu.RUnlock()
+	return es
+}
+
+// add adds events to the timeline and notifies subscribers.
+func (tl *Timeline) add(es ...*Event) {
+	if len(es) == 0 {
+		return
+	}
+
+	tl.mu.Lock()
+	tl.events = append(tl.events, es...)
+	if len(tl.events) > tl.maxEvents {
+		tl.events = tl.events[len(tl.events)-tl.maxEvents:]
+	}
+	tl.mu.Unlock()
+
+	tl.subsMu.RLock()
+	for _, e := range es {
+		for _, f := range tl.subs {
+			f(e)
+		}
+	}
+	tl.subsMu.RUnlock()
+}
+
+// expire returns events for requests, which did not receive a response within the timeout.
+// expire must be called with tl.mu held.
+func (tl *Timeline) expire(now time.Time) []*Event {
+	var es []*Event
+	for id, req := range tl.pending {
+		if now.Sub(req.time) < tl.timeout {
+			continue
+		}
+		delete(tl.pending, id)
+
+		es = append(es, &Event{
+			Time:      now,
+			Direction: req.direction,
+			Type:      req.typ,
+			MessageID: id.String(),
+			Problems:  []string{fmt.Sprintf("no response received within %s", tl.timeout)},
+		})
+	}
+	return es
+}
+
+// Expire records the requests, which did not receive a response within the timeout as of now.
+func (tl *Timeline) Expire(now time.Time) {
+	tl.mu.Lock()
+	expired := tl.expire(now)
+	tl.mu.Unlock()
+
+	tl.add(expired...)
+}
+
+// Malformed records a message sent in direction dir, which could not be decoded.
+func (tl *Timeline) Malformed(dir recording.Direction, err error) {
+	tl.add(&Event{
+		Time:      time.Now(),
+		Direction: dir,
+		Problems:  []string{fmt.Sprintf("malformed message: %s", err)},
+	})
+}
+
+// Observe inspects the raw message b sent in direction dir and records it on the timeline.
+func (tl *Timeline) Observe(dir recording.Direction, b []byte) {
+	now := time.Now()
+	e := &Event{
+		Time:      now,
+		Direction: dir,
+	}
+
+	var msg api.Message
+	if err := decodeStrict(b, &msg); err != nil {
+		if !isUnknownField(err) {
+			e.Problems = append(e.Problems, fmt.Sprintf("invalid message: %s", err))
+			tl.add(e)
+			return
+		}
+		e.Problems = append(e.Problems, fmt.Sprintf("message: %s", err))
+
+		if err := json.Unmarshal(b, &msg); err != nil {
+			e.Problems = append(e.Problems, fmt.Sprintf("invalid message: %s", err))
+			tl.add(e)
+			return
+		}
+	}
+
+	e.Type = msg.Type
+	e.MessageID = msg.MessageID.String()
+	e.Payload = msg.Payload
+	e.Problems = append(e.Problems, inspectPayload(&msg)...)
+
+	tl.mu.Lock()
+	expired := tl.expire(now)
+	switch {
+	case msg.ParentID == nil && msg.Type == api.MessageTypeState && dir == recording.DirectionToSRRS:
+		// State updates sent by TRC are not responded to.
+
+	case msg.ParentID == nil:
+		tl.pending[msg.MessageID] = pendingRequest{
+			time:      now,
+			typ:       msg.Type,
+			direction: dir,
+		}
+
+	default:
+		e.ParentID = msg.ParentID.String()
+
+		req, ok := tl.pending[*msg.ParentID]
+		switch {
+		case !ok:
+			e.Problems = append(e.Problems, "response to an unknown or expired request")
+		case req.direction == dir:
+			e.Problems = append(e.Problems, "response sent in the same direction as the request")
+		case req.typ != msg.Type:
+			e.Problems = append(e.Problems, fmt.Sprintf("response of type %s to a request of type %s", msg.Type, req.typ))
+		}
+		if ok {
+			e.Latency = now.Sub(req.time)
+			delete(tl.pending, *msg.ParentID)
+		}
+	}
+	tl.mu.Unlock()
+
+	tl.add(append(expired, e)...)
+}
+
+// inspectPayload returns the problems found in the payload of msg.
+func inspectPayload(msg *api.Message) []string {
+	var v interface{}
+	switch msg.Type {
+	case api.MessageTypeState:
+		v = &api.State{}
+	case api.MessageTypeHandshake:
+		v = &api.Handshake{}
+	case api.MessageTypeToken:
+		if msg.ParentID != nil {
+			if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+				return []string{"token response with payload"}
+			}
+			return nil
+		}
+		v = &api.TokenUpdate{}
+	case api.MessageTypePing:
+		if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+			return []string{"ping message with payload"}
+		}
+		return nil
+	default:
+		return []string{fmt.Sprintf("unknown message type: %s", msg.Type)}
+	}
+
+	if len(msg.Payload) == 0 {
+		switch msg.Type {
+		case api.MessageTypeHandshake:
+			return []string{"empty handshake payload"}
+		case api.MessageTypeToken:
+			return []string{"empty token payload"}
+		}
+		return nil
+	}
+
+	var problems []string
+	if err := decodeStrict(msg.Payload, v); err != nil {
+		if !isUnknownField(err) {
+			return []string{fmt.Sprintf("invalid payload: %s", err)}
+		}
+		problems = append(problems, fmt.Sprintf("payload: %s", err))
+
+		if err := json.Unmarshal(msg.Payload, v); err != nil {
+			return append(problems, fmt.Sprintf("invalid payload: %s", err))
+		}
+	}
+
+	if val, ok := v.(api.Validator); ok {
+		if err := val.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("validation failed: %s", err))
+		}
+	}
+	return problems
+}
+
+// decodeStrict decodes b into v disallowing unknown fields.
+func decodeStrict(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// isUnknownField reports whether err was caused by an unknown field.
+func isUnknownField(err error) bool {
+	return strings.HasPrefix(err.Error(), "json: unknown field")
+}
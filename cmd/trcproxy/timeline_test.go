@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/recording"
+	"github.com/stretchr/testify/assert"
+)
+
+// mustMarshal returns the JSON encoding of v or panics.
+func mustMarshal(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+//Test_items: Observe() in timeline.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestTimeline(t *testing.T) {
+	a := assert.New(t)
+
+	g := apitest.NewTestGenerator(t)
+	tl := NewTimeline(10, time.Hour)
+
+	req := api.NewMessage(api.MessageTypeState, mustMarshal(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"1": {BatteryVoltage: apitest.Uint8Ptr(200)},
+		},
+	}), nil)
+	tl.Observe(recording.DirectionToTRC, mustMarshal(req))
+
+	resp := api.NewMessage(api.MessageTypePing, nil, &req.MessageID)
+	tl.Observe(recording.DirectionToSRRS, mustMarshal(resp))
+
+	tl.Observe(recording.DirectionToSRRS, []byte(`{"type":"ping","message_id":"01C9ZQVJ8Z2Q3M0ZK8WW9GS5QH","foo":42}`))
+
+	unknown := api.NewMessage(api.MessageTypeState, nil, g.ULID())
+	tl.Observe(recording.DirectionToSRRS, mustMarshal(unknown))
+
+	es := tl.Events()
+	if !a.Len(es, 4) {
+		t.FailNow()
+	}
+
+	a.Equal(api.MessageTypeState, es[0].Type)
+	a.Len(es[0].Problems, 1, "out-of-range battery voltage should be reported")
+
+	a.Equal(req.MessageID.String(), es[1].ParentID)
+	a.NotZero(es[1].Latency)
+	a.Len(es[1].Problems, 1, "response type mismatch should be reported")
+
+	a.Len(es[2].Problems, 1, "unknown field should be reported")
+
+	a.Len(es[3].Problems, 1, "response to unknown request should be reported")
+}
+
+//Test_items: Expire() in timeline.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestTimelineExpire(t *testing.T) {
+	a := assert.New(t)
+
+	tl := NewTimeline(10, time.Minute)
+
+	req := api.NewMessage(api.MessageTypePing, nil, nil)
+	tl.Observe(recording.DirectionToTRC, mustMarshal(req))
+
+	tl.Expire(time.Now())
+	a.Len(tl.Events(), 1)
+
+	tl.Expire(time.Now().Add(time.Minute))
+	es := tl.Events()
+	if a.Len(es, 2) {
+		a.Equal(req.MessageID.String(), es[1].MessageID)
+		a.Len(es[1].Problems, 1, "missing response should be reported")
+	}
+
+	tl.Expire(time.Now().Add(time.Hour))
+	a.Len(tl.Events(), 2)
+}
@@ -62,6 +62,7 @@ class App extends Component {
       session: "",
       command: "role_assigner_on",
       turtles: {},
+      turtleInfo: {},
       notifications: [],
       loggedIn: false,
       authNotification: ""
@@ -119,7 +120,13 @@ class App extends Component {
     const data = JSON.parse(event.data);
     if (data.turtles !== undefined)
       this.setState(prev => {
+        const removed = Object.keys(data.turtles).filter(
+          id => data.turtles[id] === null
+        );
         const turtleChanges = Object.keys(data.turtles).reduce((acc, id) => {
+          if (data.turtles[id] === null) {
+            return acc;
+          }
           if (prev.turtles[id] === undefined) {
             data.turtles[id].enabled = false;
             acc[id] = { $set: data.turtles[id] };
@@ -128,11 +135,15 @@ class App extends Component {
           }
           return acc;
         }, {});
-        const turtles = update(prev.turtles, turtleChanges);
+        const turtles = update(update(prev.turtles, turtleChanges), {
+          $unset: removed
+        });
 
         return { turtles };
       });
     if (data.command !== undefined) this.setState({ command: data.command });
+    if (data.turtle_info !== undefined)
+      this.setState({ turtleInfo: data.turtle_info });
   }
 
   onConnectionOpen(event) {
@@ -209,7 +220,11 @@ class App extends Component {
                   onTurtleEnableChange={id => this.onTurtleEnableChange(id)}
                 />
                 <ScrollableContent>
-                  <Settings turtles={turtles} session={this.state.session} />
+                  <Settings
+                    turtles={turtles}
+                    turtleInfo={this.state.turtleInfo}
+                    session={this.state.session}
+                  />
                 </ScrollableContent>
               </Fragment>
             )}
@@ -31,13 +31,18 @@ const COMMAND_DISPLAY_VALUES = {
  * Props:
  *  - command: the currently active command
  *  - turtles: an array of Turtles
+ *  - turtleInfo: an object containing the registered information of turtles by ID
  *  - session: a string which holds the password needed to connect to the SRRS
  */
 const Settings = props => {
-  const { command, turtles, session } = props;
+  const { command, turtles, turtleInfo, session } = props;
   return (
     <SettingsWrapper>
-      <TurtleList turtles={turtles} session={session} />
+      <TurtleList
+        turtles={turtles}
+        turtleInfo={turtleInfo}
+        session={session}
+      />
       <RoleDropdown
         id={"settings_role-dropdown"}
         currentValue={COMMAND_DISPLAY_VALUES[command]}
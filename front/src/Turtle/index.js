@@ -56,17 +56,21 @@ const onChange = (id, propName, propValue, session) => {
  *   - teamcolor: the current team of this turtle
  *  - editable: whether this turtle's properties can be edited
  *  - id: identifier of a turtle
+ *  - name: the registered display name of a turtle
  */
 const Turtle = props => {
   const { batteryvoltage, homegoal, role, teamcolor } = props.turtle;
-  const { editable, id, session } = props;
+  const { editable, id, name, session } = props;
   return (
     <DefaultTurtle>
       <BatterySection>
         <Battery percentage={batteryvoltage} />
       </BatterySection>
       <SubSection>
-        <p>Turtle {id}</p>
+        <p>
+          Turtle {id}
+          {name && ` (${name})`}
+        </p>
       </SubSection>
       <DropDownSection>
         <Dropdown
@@ -109,6 +113,7 @@ Turtle.propTypes = {
     teamcolor: PropTypes.string
   }).isRequired,
   id: PropTypes.string.isRequired,
+  name: PropTypes.string,
   editable: PropTypes.bool
 };
 
@@ -15,9 +15,10 @@ import Turtle from "../Turtle";
  *   - homegoal: the current home goal of this turtle
  *   - role: the current role of this turtle
  *   - teamcolor: the current team of this turtle
+ *  - turtleInfo: an object containing the registered information of turtles by ID
  */
 const TurtleList = props => {
-  const { turtles, session } = props;
+  const { turtles, turtleInfo, session } = props;
   return (
     <Grid>
       <Row>
@@ -29,6 +30,7 @@ const TurtleList = props => {
                 <Turtle
                   key={index}
                   id={id}
+                  name={turtleInfo && turtleInfo[id] && turtleInfo[id].name}
                   turtle={turtles[id]}
                   session={session}
                   editable
@@ -50,7 +52,12 @@ TurtleList.propTypes = {
       role: PropTypes.string.isRequired,
       teamcolor: PropTypes.string.isRequired
     })
-  ).isRequired
+  ).isRequired,
+  turtleInfo: PropTypes.objectOf(
+    PropTypes.shape({
+      name: PropTypes.string
+    })
+  )
 };
 
 export default TurtleList;
@@ -115,7 +115,58 @@ const (
 	CPBNo           CPB = "no"
 )
 
+// FieldDimensions represents the dimensions of the soccer field in meters.
+// The origin is at the center of the field, the x axis is parallel to the side lines.
+type FieldDimensions struct {
+	// Length is the distance between the goal lines.
+	Length float64 `json:"length"`
+
+	// Width is the distance between the side lines.
+	Width float64 `json:"width"`
+
+	// Margin is the width of the area outside of the lines, where turtles and the ball may be located.
+	Margin float64 `json:"margin"`
+}
+
+// DefaultFieldDimensions returns the field dimensions positions are validated against by Validate.
+// Use ValidateOn to validate positions against other dimensions.
+func DefaultFieldDimensions() FieldDimensions {
+	return FieldDimensions{
+		Length: 22,
+		Width:  14,
+		Margin: 1,
+	}
+}
+
+// MaxSpeed is the maximum linear speed of a turtle in m/s, which is considered valid.
+const MaxSpeed = 10.0
+
+// Position represents a position on the field in meters.
+type Position struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Pose represents the position of a turtle on the field in meters and its heading in radians.
+type Pose struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+
+	// Heading represents the angle between the x axis and the direction the turtle faces (-π … π).
+	Heading float64 `json:"heading"`
+}
+
+// Velocity represents the linear velocity of a turtle in m/s and its angular velocity in rad/s.
+type Velocity struct {
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Angular float64 `json:"angular"`
+}
+
 // TurtleState is the state of a particular turtle.
+// Fields tagged with `api:"writable"` are settings, which may be changed by the operator,
+// all other fields represent telemetry reported by the turtle, see WritableTurtleFields.
+// Numeric fields tagged with `max:"N"` must not exceed N.
 type TurtleState struct {
 	// VisionStatus represents status of Vision Executable.
 	VisionStatus *bool `json:"visionstatus,omitempty"`
@@ -130,13 +181,13 @@ type TurtleState struct {
 	AppmanStatus *bool `json:"appmanstatus,omitempty"`
 
 	// RestartCountMotion represents restart count of Motion Executable (0 … 99).
-	RestartCountMotion *uint8 `json:"restartcountmotion,omitempty"`
+	RestartCountMotion *uint8 `json:"restartcountmotion,omitempty" max:"99"`
 
 	// RestartCountVision represents restart count of Vision Executable (0 … 99).
-	RestartCountVision *uint8 `json:"restartcountvision,omitempty"`
+	RestartCountVision *uint8 `json:"restartcountvision,omitempty" max:"99"`
 
 	// RestartCountWorldmodel represents restart count of Worldmodel Executable (0 … 99).
-	RestartCountWorldmodel *uint8 `json:"restartcountworldmodel,omitempty"`
+	RestartCountWorldmodel *uint8 `json:"restartcountworldmodel,omitempty" max:"99"`
 
 	// BallFound represents ball Found (No/Communicated/Yes).
 	BallFound BallFound `json:"ballfound,omitempty"`
@@ -148,37 +199,46 @@ type TurtleState struct {
 	CPB CPB `json:"cpb,omitempty"`
 
 	// BatteryVoltage represents battery Voltage (0 … 99).
-	BatteryVoltage *uint8 `json:"batteryvoltage,omitempty"`
+	BatteryVoltage *uint8 `json:"batteryvoltage,omitempty" max:"99"`
 
 	// EmergencyStatus represents emergency Status (0 100).
-	EmergencyStatus *uint8 `json:"emergencystatus,omitempty"`
+	EmergencyStatus *uint8 `json:"emergencystatus,omitempty" max:"100"`
 
 	// Role represents TRC Role (0 … 10).
-	Role Role `json:"role,omitempty"`
+	Role Role `json:"role,omitempty" api:"writable"`
 
 	// RefBoxRole represents TRC RefboxRole (0 … 10).
-	RefBoxRole RefBoxRole `json:"refboxrole,omitempty"`
+	RefBoxRole RefBoxRole `json:"refboxrole,omitempty" api:"writable"`
 
 	// RobotInField represents TRC Robot In Field (0/1).
-	RobotInField *bool `json:"robotinfield,omitempty"`
+	RobotInField *bool `json:"robotinfield,omitempty" api:"writable"`
 
 	// RobotEmergencyButton represents TRC Robot Emergency Button pressed (0/1).
 	RobotEmergencyButton *bool `json:"robotembutton,omitempty"`
 
 	// HomeGoal represents robot’s HomeGoal (Yellow/Blue).
-	HomeGoal HomeGoal `json:"homegoal,omitempty"`
+	HomeGoal HomeGoal `json:"homegoal,omitempty" api:"writable"`
 
 	// TeamColor represents robot’s Teamcolor (Magenta/Cyan).
-	TeamColor TeamColor `json:"teamcolor,omitempty"`
+	TeamColor TeamColor `json:"teamcolor,omitempty" api:"writable"`
 
 	// ActiveDevPC represents active DevPC controlling robot (0 … 90).
-	ActiveDevPC *uint8 `json:"activedevpc,omitempty"`
+	ActiveDevPC *uint8 `json:"activedevpc,omitempty" max:"90"`
 
 	// Kinect1State represents status of Kinect 1 (No State/No Ball/Ball).
 	Kinect1State KinectState `json:"kinect1_state,omitempty"`
 
 	// Kinect2State represents status of Kinect 2 (No State/No Ball/Ball).
 	Kinect2State KinectState `json:"kinect2_state,omitempty"`
+
+	// Pose represents robot's position and heading on the field.
+	Pose *Pose `json:"pose,omitempty"`
+
+	// Velocity represents robot's velocity.
+	Velocity *Velocity `json:"velocity,omitempty"`
+
+	// Ball represents position of the ball as perceived by the robot.
+	Ball *Position `json:"ball,omitempty"`
 }
 
 // Message specifies the type of the message.
@@ -188,17 +248,120 @@ const (
 	MessageTypeState     MessageType = "state"
 	MessageTypePing      MessageType = "ping"
 	MessageTypeHandshake MessageType = "handshake"
+	MessageTypeToken     MessageType = "token"
+)
+
+// Encoding specifies the encoding of messages on the connection.
+type Encoding string
+
+const (
+	// EncodingJSON represents newline-delimited JSON messages.
+	// It is used during the handshake and whenever no other encoding is negotiated.
+	EncodingJSON Encoding = "json"
+
+	// EncodingMsgPack represents length-prefixed frames containing MessagePack-encoded messages.
+	EncodingMsgPack Encoding = "msgpack"
 )
 
 // Handshake represents the handshake message payload.
 type Handshake struct {
 	Version semver.Version `json:"version"`
 	Token   string         `json:"token"`
+
+	// Encodings are the encodings supported by TRC in order of preference.
+	// Encodings is only set in the request.
+	Encodings []Encoding `json:"encodings,omitempty"`
+
+	// Encoding is the encoding chosen by SRRS, which is used by both sides after the handshake.
+	// Encoding is only set in the response.
+	Encoding Encoding `json:"encoding,omitempty"`
+}
+
+// TokenUpdate represents the token message payload.
+// TRC sends it to replace the token received during the handshake.
+type TokenUpdate struct {
+	Token string `json:"token"`
 }
 
+// Period is a period of a match.
+type Period string
+
+const (
+	PeriodFirstHalf  Period = "first_half"
+	PeriodHalfTime   Period = "half_time"
+	PeriodSecondHalf Period = "second_half"
+	PeriodPenalties  Period = "penalties"
+)
+
+// Score represents the amount of goals scored by each team.
+type Score struct {
+	Magenta uint `json:"magenta"`
+	Cyan    uint `json:"cyan"`
+}
+
+// Match represents the state of a match tracked by SRRS.
+type Match struct {
+	Score  Score  `json:"score"`
+	Period Period `json:"period"`
+	Phase  Phase  `json:"phase"`
+
+	// Clock represents the game time elapsed in the current period in seconds.
+	Clock float64 `json:"clock"`
+
+	// ClockRunning represents whether the game clock is running.
+	ClockRunning bool `json:"clock_running"`
+
+	// LastSetPiece represents the last set-piece command sent to TRC.
+	LastSetPiece Command `json:"last_set_piece,omitempty"`
+}
+
+// MatchUpdate represents a manual update of the match state.
+type MatchUpdate struct {
+	// Score, if set, replaces the score.
+	Score *Score `json:"score,omitempty"`
+
+	// Period, if set, changes the period.
+	// Changing the period resets and stops the game clock and stops the game.
+	Period Period `json:"period,omitempty"`
+}
+
+// BatteryChemistry is a chemistry of a turtle battery.
+type BatteryChemistry string
+
+const (
+	BatteryChemistryLiPo    BatteryChemistry = "lipo"
+	BatteryChemistryLiIon   BatteryChemistry = "li_ion"
+	BatteryChemistryLiFePO4 BatteryChemistry = "lifepo4"
+	BatteryChemistryNiMH    BatteryChemistry = "nimh"
+)
+
+// TurtleInfo represents static information about a turtle maintained by SRRS.
+type TurtleInfo struct {
+	// Name represents the display name of the turtle.
+	Name string `json:"name,omitempty"`
+
+	// Serial represents the hardware serial number of the turtle.
+	Serial string `json:"serial,omitempty"`
+
+	// Generation represents the robot generation the turtle belongs to.
+	Generation uint `json:"generation,omitempty"`
+
+	// BatteryChemistry represents the expected chemistry of the turtle's battery.
+	BatteryChemistry BatteryChemistry `json:"battery_chemistry,omitempty"`
+
+	// Notes represents free-form maintenance notes.
+	Notes string `json:"notes,omitempty"`
+}
+
+// DefaultRoster represents the IDs of turtles controlled by TRC, unless configured otherwise.
+var DefaultRoster = []string{"1", "2", "3", "4", "5", "6"}
+
 // State represents the state of the TRC.
 type State struct {
-	Command Command                 `json:"command,omitempty"`
+	Command Command `json:"command,omitempty"`
+
+	// Turtles represents the states of turtles by ID.
+	// In updates, turtles not known yet join the roster and turtles with null state leave it.
 	Turtles map[string]*TurtleState `json:"turtles,omitempty"`
 }
 
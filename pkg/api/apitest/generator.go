@@ -0,0 +1,443 @@
+package apitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+// SeedEnv is the environment variable, which sets the seed of generators returned by NewTestGenerator.
+const SeedEnv = "APITEST_SEED"
+
+// Generator generates random protocol values.
+// By default, only valid values are generated.
+// Generator is not safe for concurrent use by multiple goroutines.
+type Generator struct {
+	rand *rand.Rand
+	seed int64
+
+	roster []string
+	// invalid holds the JSON names of turtle state fields, for which only invalid values are generated.
+	invalid map[string]bool
+}
+
+// GeneratorOption represents a Generator option.
+type GeneratorOption func(*Generator)
+
+// WithSeed seeds the generator with seed.
+// Generators using the same seed and options generate the same sequence of values.
+func WithSeed(seed int64) GeneratorOption {
+	return func(g *Generator) {
+		g.seed = seed
+		g.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithSource makes the generator use src as the source of random numbers.
+// The seed reported by the generator is meaningless in that case.
+func WithSource(src rand.Source) GeneratorOption {
+	return func(g *Generator) {
+		g.seed = 0
+		g.rand = rand.New(src)
+	}
+}
+
+// WithRoster restricts the turtles, for which states are generated, to the ones identified by ids.
+// By default, api.DefaultRoster is used.
+func WithRoster(ids ...string) GeneratorOption {
+	return func(g *Generator) {
+		g.roster = ids
+	}
+}
+
+// WithInvalidField makes the generator generate only invalid values for the turtle state fields identified
+// by their JSON names. NewGenerator panics, if any of the fields cannot have an invalid value.
+func WithInvalidField(names ...string) GeneratorOption {
+	return func(g *Generator) {
+		for _, name := range names {
+			g.invalid[name] = true
+		}
+	}
+}
+
+// NewGenerator returns a new *Generator configured by opts.
+// By default, the generator is seeded with the current time.
+func NewGenerator(opts ...GeneratorOption) *Generator {
+	g := &Generator{
+		roster:  api.DefaultRoster,
+		invalid: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.rand == nil {
+		WithSeed(time.Now().UnixNano())(g)
+	}
+
+	typ := reflect.TypeOf(api.TurtleState{})
+	for name := range g.invalid {
+		f, ok := turtleField(name)
+		if !ok {
+			panic(errors.Errorf("unknown turtle state field %s", name))
+		}
+		if !canBeInvalid(f) {
+			panic(errors.Errorf("field %s of %s cannot have an invalid value", name, typ))
+		}
+	}
+	return g
+}
+
+// NewTestGenerator returns a new *Generator configured by opts, which is seeded with the value of SeedEnv,
+// if set, or the current time otherwise. The seed is logged to t, such that failures can be reproduced.
+func NewTestGenerator(t testing.TB, opts ...GeneratorOption) *Generator {
+	t.Helper()
+
+	seed := time.Now().UnixNano()
+	if s := os.Getenv(SeedEnv); s != "" {
+		var err error
+		seed, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			t.Fatalf("Invalid %s: %s", SeedEnv, err)
+		}
+	}
+	t.Logf("Generator seed: %d, set %s=%d to reproduce", seed, SeedEnv, seed)
+	return NewGenerator(append([]GeneratorOption{WithSeed(seed)}, opts...)...)
+}
+
+// Seed returns the seed of g.
+func (g *Generator) Seed() int64 {
+	return g.seed
+}
+
+// Rand returns the source of random numbers used by g.
+func (g *Generator) Rand() *rand.Rand {
+	return g.rand
+}
+
+// jsonName returns the name of the JSON object key f is encoded as.
+func jsonName(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("json"), ",")[0]
+}
+
+// turtleField returns the field of api.TurtleState encoded as JSON object key name.
+func turtleField(name string) (reflect.StructField, bool) {
+	typ := reflect.TypeOf(api.TurtleState{})
+	for i := 0; i < typ.NumField(); i++ {
+		if f := typ.Field(i); jsonName(f) == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+var (
+	positionType = reflect.TypeOf(&api.Position{})
+	poseType     = reflect.TypeOf(&api.Pose{})
+	velocityType = reflect.TypeOf(&api.Velocity{})
+)
+
+// canBeInvalid reports whether the field f of api.TurtleState can have an invalid value.
+func canBeInvalid(f reflect.StructField) bool {
+	switch f.Type {
+	case positionType, poseType, velocityType:
+		return true
+	}
+	if _, ok := api.EnumValues(f.Type); ok {
+		return true
+	}
+	max, ok := api.FieldMaximum(f)
+	return ok && max < math.MaxUint8
+}
+
+// enum returns a random valid value of enumeration type typ.
+func (g *Generator) enum(typ reflect.Type) reflect.Value {
+	vs, ok := api.EnumValues(typ)
+	if !ok {
+		panic(errors.Errorf("%s is not an enumeration type", typ))
+	}
+	return reflect.ValueOf(vs[g.rand.Intn(len(vs))]).Convert(typ)
+}
+
+// coordinate returns a random coordinate in range [-max, max].
+func (g *Generator) coordinate(max float64) float64 {
+	return (2*g.rand.Float64() - 1) * max
+}
+
+// outside returns a random coordinate in range [-max-1.01, -max-0.01] or [max+0.01, max+1.01].
+func (g *Generator) outside(max float64) float64 {
+	v := max + 0.01 + g.rand.Float64()
+	if g.rand.Intn(2) == 0 {
+		return -v
+	}
+	return v
+}
+
+// point returns a random point on api.DefaultFieldDimensions or, if invalid is true, outside of it.
+func (g *Generator) point(invalid bool) (float64, float64) {
+	d := api.DefaultFieldDimensions()
+	maxX, maxY := d.Length/2+d.Margin, d.Width/2+d.Margin
+	if !invalid {
+		return g.coordinate(maxX), g.coordinate(maxY)
+	}
+	if g.rand.Intn(2) == 0 {
+		return g.outside(maxX), g.coordinate(maxY)
+	}
+	return g.coordinate(maxX), g.outside(maxY)
+}
+
+// Command returns a random valid api.Command.
+func (g *Generator) Command() api.Command {
+	return g.enum(reflect.TypeOf(api.Command(""))).Interface().(api.Command)
+}
+
+// Position returns a random valid *api.Position within api.DefaultFieldDimensions.
+func (g *Generator) Position() *api.Position {
+	x, y := g.point(false)
+	return &api.Position{X: x, Y: y}
+}
+
+// Pose returns a random valid *api.Pose within api.DefaultFieldDimensions.
+func (g *Generator) Pose() *api.Pose {
+	x, y := g.point(false)
+	return &api.Pose{X: x, Y: y, Heading: g.coordinate(math.Pi)}
+}
+
+// Velocity returns a random valid *api.Velocity.
+func (g *Generator) Velocity() *api.Velocity {
+	return g.velocity(false)
+}
+
+// velocity returns a random *api.Velocity, which is invalid, if invalid is true.
+func (g *Generator) velocity(invalid bool) *api.Velocity {
+	speed := g.rand.Float64() * api.MaxSpeed
+	if invalid {
+		speed = api.MaxSpeed*(1+g.rand.Float64()) + 0.1
+	}
+	dir := g.coordinate(math.Pi)
+	return &api.Velocity{
+		X:       speed * math.Cos(dir),
+		Y:       speed * math.Sin(dir),
+		Angular: g.coordinate(2 * math.Pi),
+	}
+}
+
+// field returns a random value of the field f of api.TurtleState, which is invalid, if invalid is true.
+func (g *Generator) field(f reflect.StructField, invalid bool) reflect.Value {
+	switch f.Type {
+	case reflect.TypeOf((*bool)(nil)):
+		return reflect.ValueOf(BoolPtr(g.rand.Intn(2) == 0))
+
+	case reflect.TypeOf((*uint8)(nil)):
+		max, ok := api.FieldMaximum(f)
+		if !ok {
+			max = math.MaxUint8
+		}
+		if invalid {
+			return reflect.ValueOf(Uint8Ptr(uint8(int(max) + 1 + g.rand.Intn(math.MaxUint8-int(max)))))
+		}
+		return reflect.ValueOf(Uint8Ptr(uint8(g.rand.Intn(int(max) + 1))))
+
+	case positionType:
+		x, y := g.point(invalid)
+		return reflect.ValueOf(&api.Position{X: x, Y: y})
+
+	case poseType:
+		x, y := g.point(invalid)
+		return reflect.ValueOf(&api.Pose{X: x, Y: y, Heading: g.coordinate(math.Pi)})
+
+	case velocityType:
+		return reflect.ValueOf(g.velocity(invalid))
+	}
+
+	if invalid {
+		return reflect.ValueOf(fmt.Sprintf("invalid_%d", g.rand.Intn(1000))).Convert(f.Type)
+	}
+	return g.enum(f.Type)
+}
+
+// TurtleState returns a random *api.TurtleState with all fields set.
+func (g *Generator) TurtleState() *api.TurtleState {
+	ts := &api.TurtleState{}
+	rv := reflect.ValueOf(ts).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		f := rv.Type().Field(i)
+		rv.Field(i).Set(g.field(f, g.invalid[jsonName(f)]))
+	}
+	return ts
+}
+
+// TurtleStateMap returns a random *api.TurtleState map of a random subset of the turtles in the roster of g.
+func (g *Generator) TurtleStateMap() map[string]*api.TurtleState {
+	ret := map[string]*api.TurtleState{}
+	perm := g.rand.Perm(len(g.roster))[:g.rand.Intn(len(g.roster)+1)]
+	for _, i := range perm {
+		ret[g.roster[i]] = g.TurtleState()
+	}
+	return ret
+}
+
+// RosterChange returns a random *api.TurtleState map, in which some turtles
+// in roster leave and some turtles, which are not in roster, join.
+// RosterChange returns the resulting roster along with the map.
+func (g *Generator) RosterChange(roster []string) (map[string]*api.TurtleState, []string) {
+	ret := map[string]*api.TurtleState{}
+	next := make([]string, 0, len(roster))
+	for _, id := range roster {
+		if g.rand.Intn(3) == 0 {
+			ret[id] = nil
+			continue
+		}
+		next = append(next, id)
+	}
+
+	for i := g.rand.Intn(3); i > 0; i-- {
+		id := strconv.Itoa(100 + g.rand.Intn(900))
+		if _, ok := ret[id]; ok {
+			continue
+		}
+		known := false
+		for _, rid := range roster {
+			if rid == id {
+				known = true
+				break
+			}
+		}
+		if known {
+			continue
+		}
+		ret[id] = g.TurtleState()
+		next = append(next, id)
+	}
+	return ret, next
+}
+
+// State returns a random *api.State.
+func (g *Generator) State() *api.State {
+	var pld api.State
+	if g.rand.Intn(2) == 0 {
+		pld.Command = g.Command()
+	}
+	pld.Turtles = g.TurtleStateMap()
+	return &pld
+}
+
+// Version returns a random valid *semver.Version.
+func (g *Generator) Version() *semver.Version {
+	ver := semver.MustParse(
+		fmt.Sprintf("%d.%d.%d", g.rand.Intn(10), g.rand.Intn(10), g.rand.Intn(10)),
+	)
+	return &ver
+}
+
+// MessageType returns a random valid api.MessageType, which is either state, ping or handshake.
+func (g *Generator) MessageType() api.MessageType {
+	return []api.MessageType{
+		api.MessageTypeState,
+		api.MessageTypePing,
+		api.MessageTypeHandshake,
+	}[g.rand.Intn(3)]
+}
+
+// tokenAlphabet is the alphabet of tokens returned by Handshake.
+const tokenAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Handshake returns a random valid *api.Handshake.
+func (g *Generator) Handshake() *api.Handshake {
+	b := make([]byte, 10+g.rand.Intn(10))
+	for i := range b {
+		b[i] = tokenAlphabet[g.rand.Intn(len(tokenAlphabet))]
+	}
+	return &api.Handshake{
+		Version: *g.Version(),
+		Token:   string(b),
+	}
+}
+
+// entropy is an io.Reader reading random bytes from rand.
+// Unlike (*rand.Rand).Read, it does not keep state in rand.Rand itself.
+type entropy struct {
+	rand *rand.Rand
+}
+
+// Read implements io.Reader.
+func (e entropy) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = byte(e.rand.Intn(256))
+	}
+	return len(b), nil
+}
+
+// ULID returns a random valid *ulid.ULID.
+func (g *Generator) ULID() *ulid.ULID {
+	ret := ulid.MustNew(ulid.Now(), entropy{g.rand})
+	return &ret
+}
+
+// Message returns a random valid *api.Message.
+func (g *Generator) Message() *api.Message {
+	var pld interface{}
+
+	mt := g.MessageType()
+	switch mt {
+	case api.MessageTypeHandshake:
+		pld = *g.Handshake()
+	case api.MessageTypeState:
+		pld = *g.State()
+	case api.MessageTypePing:
+		pld = nil
+	default:
+		panic("unmatched Message type")
+	}
+
+	b, err := json.Marshal(pld)
+	if err != nil {
+		panic("failed to marshall payload ")
+	}
+
+	var parentID *ulid.ULID
+	if g.rand.Intn(2) == 0 {
+		parentID = g.ULID()
+	}
+
+	return &api.Message{
+		Type:      mt,
+		MessageID: *g.ULID(),
+		ParentID:  parentID,
+		Payload:   b,
+	}
+}
+
+// CheckStates checks that prop holds for n states generated by g.
+// If it does not, CheckStates fails the test reporting the seed of g and the counterexample shrunk by ShrinkState.
+func (g *Generator) CheckStates(t testing.TB, n int, prop func(*api.State) error) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		st := g.State()
+		if prop(st) == nil {
+			continue
+		}
+
+		st = ShrinkState(st, func(st *api.State) bool {
+			return prop(st) != nil
+		})
+		b, err := json.Marshal(st)
+		if err != nil {
+			t.Fatalf("Failed to encode counterexample: %s", err)
+		}
+		t.Fatalf("Property does not hold for state %d generated with seed %d: %s\nMinimal counterexample: %s",
+			i, g.seed, prop(st), b)
+	}
+}
@@ -0,0 +1,90 @@
+package apitest_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	. "github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: NewGenerator(), WithSeed(), State() in generator.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestGeneratorSeed(t *testing.T) {
+	a := assert.New(t)
+
+	g1 := NewGenerator(WithSeed(42))
+	g2 := NewGenerator(WithSeed(42))
+	a.Equal(int64(42), g1.Seed())
+	for i := 0; i < 10; i++ {
+		st := g1.State()
+		a.Equal(st, g2.State())
+		a.Nil(st.Validate())
+	}
+
+	os.Setenv(SeedEnv, "42")
+	defer os.Unsetenv(SeedEnv)
+	a.Equal(NewGenerator(WithSeed(42)).State(), NewTestGenerator(t).State())
+}
+
+//Test_items: WithRoster(), WithInvalidField() in generator.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestGeneratorConstraints(t *testing.T) {
+	a := assert.New(t)
+
+	g := NewTestGenerator(t, WithRoster("2", "5"), WithInvalidField("batteryvoltage", "pose"))
+	for i := 0; i < 50; i++ {
+		for id, ts := range g.TurtleStateMap() {
+			a.Contains([]string{"2", "5"}, id)
+
+			errs := api.AsValidationErrors(ts.Validate())
+			if a.Len(errs, 2) {
+				a.Equal("batteryvoltage", errs[0].Path)
+				a.Equal(api.ValidationReasonOutOfRange, errs[0].Reason)
+				a.Contains([]string{"pose.x", "pose.y"}, errs[1].Path)
+			}
+		}
+	}
+
+	a.Panics(func() { NewGenerator(WithInvalidField("visionstatus")) })
+	a.Panics(func() { NewGenerator(WithInvalidField("unknown")) })
+}
+
+//Test_items: ShrinkState() in shrink.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestShrinkState(t *testing.T) {
+	a := assert.New(t)
+
+	fails := func(st *api.State) bool {
+		for _, ts := range st.Turtles {
+			if ts != nil && ts.BatteryVoltage != nil && *ts.BatteryVoltage > 50 {
+				return true
+			}
+		}
+		return false
+	}
+
+	g := NewTestGenerator(t)
+	for i := 0; i < 20; i++ {
+		st := g.State()
+		if !fails(st) {
+			continue
+		}
+
+		shrunk := ShrinkState(st, fails)
+		a.True(fails(st))
+		if a.Len(shrunk.Turtles, 1) {
+			for _, ts := range shrunk.Turtles {
+				a.Equal(&api.TurtleState{BatteryVoltage: Uint8Ptr(51)}, ts)
+			}
+		}
+		a.Empty(shrunk.Command)
+	}
+}
@@ -0,0 +1,150 @@
+package apitest
+
+import (
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/mohae/deepcopy"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+// ShrinkState returns a minimal state derived from st, for which fails reports true.
+// fails must report true for st, which is not modified.
+// The state is shrunk by repeatedly removing the command, other top-level fields and turtles,
+// unsetting turtle state fields and decreasing numeric values for as long as fails reports true.
+func ShrinkState(st *api.State, fails func(*api.State) bool) *api.State {
+	cur := deepcopy.Copy(st).(*api.State)
+	for {
+		shrunk := false
+		for _, cand := range stateCandidates(cur) {
+			if fails(cand) {
+				cur = cand
+				shrunk = true
+				break
+			}
+		}
+		if !shrunk {
+			return cur
+		}
+	}
+}
+
+// stateCandidates returns the states, which are one step smaller than st, in order of preference.
+func stateCandidates(st *api.State) []*api.State {
+	var cands []*api.State
+	with := func(f func(st *api.State)) {
+		cand := deepcopy.Copy(st).(*api.State)
+		f(cand)
+		cands = append(cands, cand)
+	}
+
+	rv := reflect.ValueOf(st).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		if isZero(rv.Field(i)) {
+			continue
+		}
+		i := i
+		with(func(st *api.State) {
+			fv := reflect.ValueOf(st).Elem().Field(i)
+			fv.Set(reflect.Zero(fv.Type()))
+		})
+	}
+
+	ids := make([]string, 0, len(st.Turtles))
+	for id := range st.Turtles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		id := id
+		with(func(st *api.State) {
+			delete(st.Turtles, id)
+		})
+	}
+
+	for _, id := range ids {
+		if st.Turtles[id] == nil {
+			continue
+		}
+		for _, ts := range turtleCandidates(st.Turtles[id]) {
+			id, ts := id, ts
+			with(func(st *api.State) {
+				st.Turtles[id] = ts
+			})
+		}
+	}
+	return cands
+}
+
+// turtleCandidates returns the turtle states, which are one step smaller than ts, in order of preference.
+func turtleCandidates(ts *api.TurtleState) []*api.TurtleState {
+	var cands []*api.TurtleState
+	with := func(i int, v reflect.Value) {
+		cand := deepcopy.Copy(ts).(*api.TurtleState)
+		reflect.ValueOf(cand).Elem().Field(i).Set(v)
+		cands = append(cands, cand)
+	}
+
+	rv := reflect.ValueOf(ts).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		fv := rv.Field(i)
+		if isZero(fv) {
+			continue
+		}
+		with(i, reflect.Zero(fv.Type()))
+
+		if fv.Kind() != reflect.Ptr {
+			continue
+		}
+		for _, v := range smaller(fv.Elem()) {
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			with(i, p)
+		}
+	}
+	return cands
+}
+
+// smaller returns the values, which are one step smaller than v, in order of preference.
+// Unsigned integers are halved and decremented, floating point numbers in structs are zeroed and truncated.
+func smaller(v reflect.Value) []reflect.Value {
+	switch v.Kind() {
+	case reflect.Uint8:
+		n := v.Uint()
+		if n == 0 {
+			return nil
+		}
+		vs := []reflect.Value{reflect.ValueOf(uint8(n / 2)).Convert(v.Type())}
+		if n-1 != n/2 {
+			vs = append(vs, reflect.ValueOf(uint8(n-1)).Convert(v.Type()))
+		}
+		return vs
+
+	case reflect.Struct:
+		var vs []reflect.Value
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).Kind() != reflect.Float64 {
+				continue
+			}
+			f := v.Field(i).Float()
+			for _, s := range []float64{0, math.Trunc(f)} {
+				if s == f {
+					continue
+				}
+				cand := reflect.New(v.Type()).Elem()
+				cand.Set(v)
+				cand.Field(i).SetFloat(s)
+				vs = append(vs, cand)
+			}
+		}
+		return vs
+	}
+	return nil
+}
+
+// isZero reports whether v is the zero value of its type.
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
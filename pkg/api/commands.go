@@ -0,0 +1,95 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+)
+
+// CommandCategory is a category of commands.
+type CommandCategory string
+
+const (
+	CommandCategoryRefBox       CommandCategory = "refbox"
+	CommandCategoryRoleAssigner CommandCategory = "role_assigner"
+	CommandCategoryDemo         CommandCategory = "demo"
+)
+
+// CommandInfo describes a command.
+type CommandInfo struct {
+	Command  Command         `json:"command"`
+	Category CommandCategory `json:"category"`
+
+	// Team represents the team the command is issued for, if any.
+	Team TeamColor `json:"team,omitempty"`
+
+	// SetPiece represents whether the command announces a set piece.
+	SetPiece bool `json:"set_piece"`
+
+	// RequiresStopped represents whether the command may only be sent, while the game is stopped.
+	RequiresStopped bool `json:"requires_stopped"`
+
+	// Label represents the human-readable name of the command.
+	Label string `json:"label"`
+}
+
+// commandInfos describes every known command in order of presentation.
+// New commands only need to be declared here.
+var commandInfos = []CommandInfo{
+	{Command: CommandStart, Category: CommandCategoryRefBox, Label: "Start"},
+	{Command: CommandStop, Category: CommandCategoryRefBox, Label: "Stop"},
+	{Command: CommandDroppedBall, Category: CommandCategoryRefBox, SetPiece: true, RequiresStopped: true, Label: "Dropped ball"},
+	{Command: CommandGoIn, Category: CommandCategoryRefBox, RequiresStopped: true, Label: "Go in"},
+	{Command: CommandGoOut, Category: CommandCategoryRefBox, RequiresStopped: true, Label: "Go out"},
+	{Command: CommandKickOffMagenta, Category: CommandCategoryRefBox, Team: TeamColorMagenta, SetPiece: true, RequiresStopped: true, Label: "Kick off"},
+	{Command: CommandKickOffCyan, Category: CommandCategoryRefBox, Team: TeamColorCyan, SetPiece: true, RequiresStopped: true, Label: "Kick off"},
+	{Command: CommandFreeKickMagenta, Category: CommandCategoryRefBox, Team: TeamColorMagenta, SetPiece: true, RequiresStopped: true, Label: "Free kick"},
+	{Command: CommandFreeKickCyan, Category: CommandCategoryRefBox, Team: TeamColorCyan, SetPiece: true, RequiresStopped: true, Label: "Free kick"},
+	{Command: CommandGoalKickMagenta, Category: CommandCategoryRefBox, Team: TeamColorMagenta, SetPiece: true, RequiresStopped: true, Label: "Goal kick"},
+	{Command: CommandGoalKickCyan, Category: CommandCategoryRefBox, Team: TeamColorCyan, SetPiece: true, RequiresStopped: true, Label: "Goal kick"},
+	{Command: CommandThrowInMagenta, Category: CommandCategoryRefBox, Team: TeamColorMagenta, SetPiece: true, RequiresStopped: true, Label: "Throw in"},
+	{Command: CommandThrowInCyan, Category: CommandCategoryRefBox, Team: TeamColorCyan, SetPiece: true, RequiresStopped: true, Label: "Throw in"},
+	{Command: CommandCornerMagenta, Category: CommandCategoryRefBox, Team: TeamColorMagenta, SetPiece: true, RequiresStopped: true, Label: "Corner"},
+	{Command: CommandCornerCyan, Category: CommandCategoryRefBox, Team: TeamColorCyan, SetPiece: true, RequiresStopped: true, Label: "Corner"},
+	{Command: CommandPenaltyMagenta, Category: CommandCategoryRefBox, Team: TeamColorMagenta, SetPiece: true, RequiresStopped: true, Label: "Penalty"},
+	{Command: CommandPenaltyCyan, Category: CommandCategoryRefBox, Team: TeamColorCyan, SetPiece: true, RequiresStopped: true, Label: "Penalty"},
+	{Command: CommandRoleAssignerOn, Category: CommandCategoryRoleAssigner, Label: "Role assigner on"},
+	{Command: CommandRoleAssignerOff, Category: CommandCategoryRoleAssigner, Label: "Role assigner off"},
+	{Command: CommandPassDemo, Category: CommandCategoryDemo, RequiresStopped: true, Label: "Pass demo"},
+	{Command: CommandPenaltyMode, Category: CommandCategoryDemo, RequiresStopped: true, Label: "Penalty demo"},
+	{Command: CommandBallHandlingDemo, Category: CommandCategoryDemo, RequiresStopped: true, Label: "Ball handling demo"},
+}
+
+// commandInfoIndex maps commands to their index in commandInfos.
+var commandInfoIndex = func() map[Command]int {
+	m := make(map[Command]int, len(commandInfos))
+	for i, info := range commandInfos {
+		if _, ok := m[info.Command]; ok {
+			panic(errors.Errorf("command %s is declared more than once", info.Command))
+		}
+		m[info.Command] = i
+	}
+	return m
+}()
+
+// GetCommand returns the description of cmd and whether cmd is known.
+func GetCommand(cmd Command) (CommandInfo, bool) {
+	i, ok := commandInfoIndex[cmd]
+	if !ok {
+		return CommandInfo{}, false
+	}
+	return commandInfos[i], true
+}
+
+// ListCommands returns the descriptions of all known commands in order of presentation.
+func ListCommands() []CommandInfo {
+	return append([]CommandInfo(nil), commandInfos...)
+}
+
+// Validate implements Validator.
+func (c CommandCategory) Validate() error {
+	switch c {
+	case CommandCategoryRefBox, CommandCategoryRoleAssigner, CommandCategoryDemo:
+	default:
+		return errors.Errorf("invalid CommandCategory: %s", c)
+	}
+	return nil
+}
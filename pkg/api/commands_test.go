@@ -0,0 +1,42 @@
+package api_test
+
+import (
+	"testing"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: GetCommand(), ListCommands() in commands.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestCommands(t *testing.T) {
+	a := assert.New(t)
+
+	infos := ListCommands()
+	a.NotEmpty(infos)
+
+	for _, info := range infos {
+		got, ok := GetCommand(info.Command)
+		a.True(ok)
+		a.Equal(info, got)
+
+		a.NoError(info.Command.Validate())
+		a.NoError(info.Category.Validate())
+		a.NotEmpty(info.Label)
+		if info.Team != "" {
+			a.NoError(info.Team.Validate())
+		}
+		if info.SetPiece {
+			a.True(info.RequiresStopped, "set piece %s must require a stopped game", info.Command)
+		}
+	}
+
+	_, ok := GetCommand("unknown")
+	a.False(ok)
+	a.Error(Command("unknown").Validate())
+
+	infos[0].Label = "modified"
+	a.NotEqual(infos[0], ListCommands()[0])
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"reflect"
+)
+
+// enumValues holds the valid values of enumeration types by type.
+// Every enumeration type, which is part of the protocol, must be declared here.
+var enumValues = map[reflect.Type][]interface{}{
+	reflect.TypeOf(TeamColor("")):          {TeamColorMagenta, TeamColorCyan},
+	reflect.TypeOf(HomeGoal("")):           {HomeGoalYellow, HomeGoalBlue},
+	reflect.TypeOf(KinectState("")):        {KinectStateNoState, KinectStateNoBall, KinectStateBall},
+	reflect.TypeOf(BallFound("")):          {BallFoundYes, BallFoundCommunicated, BallFoundNo},
+	reflect.TypeOf(CPB("")):                {CPBYes, CPBCommunicated, CPBNo},
+	reflect.TypeOf(MessageType("")):        {MessageTypeState, MessageTypePing, MessageTypeHandshake, MessageTypeToken},
+	reflect.TypeOf(Encoding("")):           {EncodingJSON, EncodingMsgPack},
+	reflect.TypeOf(Period("")):             {PeriodFirstHalf, PeriodHalfTime, PeriodSecondHalf, PeriodPenalties},
+	reflect.TypeOf(Phase("")):              {PhaseStopped, PhaseSetPiece, PhaseDroppedBall, PhaseRunning},
+	reflect.TypeOf(BatteryChemistry("")):   {BatteryChemistryLiPo, BatteryChemistryLiIon, BatteryChemistryLiFePO4, BatteryChemistryNiMH},
+	reflect.TypeOf(CommandCategory("")):    {CommandCategoryRefBox, CommandCategoryRoleAssigner, CommandCategoryDemo},
+	reflect.TypeOf(LocalizationStatus("")): {LocalizationStatusNoLocalization, LocalizationStatusCompassError, LocalizationStatusLocalization},
+	reflect.TypeOf(Role("")): {
+		RoleNone, RoleInactive, RoleGoalkeeper, RoleAttackerMain, RoleAttackerAssist,
+		RoleDefenderMain, RoleDefenderAssist, RoleDefenderAssist2,
+	},
+	reflect.TypeOf(RefBoxRole("")): {
+		RefBoxRole1, RefBoxRole2, RefBoxRole3, RefBoxRole4, RefBoxRole5, RefBoxRole6,
+	},
+	reflect.TypeOf(ValidationReason("")): {
+		ValidationReasonOutOfRange, ValidationReasonInvalidValue, ValidationReasonInconsistent,
+		ValidationReasonDuplicate, ValidationReasonMissing, ValidationReasonReadOnly,
+	},
+	reflect.TypeOf(Command("")): func() []interface{} {
+		vs := make([]interface{}, len(commandInfos))
+		for i, info := range commandInfos {
+			vs[i] = info.Command
+		}
+		return vs
+	}(),
+}
+
+// EnumValues returns the valid values of the enumeration type t in order of declaration
+// and whether t is an enumeration type.
+func EnumValues(t reflect.Type) ([]string, bool) {
+	vs, ok := enumValues[t]
+	if !ok {
+		return nil, false
+	}
+
+	ret := make([]string, len(vs))
+	for i, v := range vs {
+		ret[i] = reflect.ValueOf(v).String()
+	}
+	return ret, true
+}
+
+// EnumTypes returns all enumeration types.
+func EnumTypes() []reflect.Type {
+	ts := make([]reflect.Type, 0, len(enumValues))
+	for t := range enumValues {
+		ts = append(ts, t)
+	}
+	return ts
+}
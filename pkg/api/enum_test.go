@@ -0,0 +1,39 @@
+package api_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: EnumValues(), EnumTypes() in enum.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestEnumValues(t *testing.T) {
+	validatorType := reflect.TypeOf((*Validator)(nil)).Elem()
+
+	for _, typ := range EnumTypes() {
+		t.Run(typ.Name(), func(t *testing.T) {
+			a := assert.New(t)
+
+			vs, ok := EnumValues(typ)
+			a.True(ok)
+			a.NotEmpty(vs)
+
+			if !typ.Implements(validatorType) {
+				return
+			}
+			for _, v := range vs {
+				a.NoError(reflect.ValueOf(v).Convert(typ).Interface().(Validator).Validate())
+			}
+			a.Error(reflect.ValueOf("bogus").Convert(typ).Interface().(Validator).Validate())
+		})
+	}
+
+	_, ok := EnumValues(reflect.TypeOf(""))
+	a := assert.New(t)
+	a.False(ok)
+}
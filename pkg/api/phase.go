@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Phase is a phase of the game as defined by the MSL referee box rules.
+type Phase string
+
+const (
+	// PhaseStopped represents a stopped game. The robots wait for the next command.
+	PhaseStopped Phase = "stopped"
+
+	// PhaseSetPiece represents a pending set piece, which is executed once the game is started.
+	PhaseSetPiece Phase = "set_piece"
+
+	// PhaseDroppedBall represents a pending dropped ball, which is executed once the game is started.
+	PhaseDroppedBall Phase = "dropped_ball"
+
+	// PhaseRunning represents a running game.
+	PhaseRunning Phase = "running"
+)
+
+// TransitionError is returned if a command is not allowed in the current phase.
+type TransitionError struct {
+	Phase   Phase
+	Command Command
+	Reason  string
+}
+
+// Error implements error.
+func (err *TransitionError) Error() string {
+	return fmt.Sprintf("command %s is not allowed in phase %s: %s", err.Command, err.Phase, err.Reason)
+}
+
+// Validate implements Validator.
+func (p Phase) Validate() error {
+	switch p {
+	case PhaseStopped, PhaseSetPiece, PhaseDroppedBall, PhaseRunning:
+	default:
+		return errors.Errorf("invalid Phase: %s", p)
+	}
+	return nil
+}
+
+// target returns the phase the game is in after cmd is executed in phase p, regardless of whether cmd is allowed.
+func (p Phase) target(cmd Command) Phase {
+	info, _ := GetCommand(cmd)
+	switch {
+	case cmd == CommandStop:
+		return PhaseStopped
+	case cmd == CommandStart:
+		return PhaseRunning
+	case cmd == CommandDroppedBall:
+		return PhaseDroppedBall
+	case info.SetPiece:
+		return PhaseSetPiece
+	case info.Category == CommandCategoryDemo:
+		return PhaseRunning
+	}
+	return p
+}
+
+// Transition returns the phase the game is in after cmd is executed in phase p.
+// If cmd is not allowed in p, Transition returns a *TransitionError along with
+// the phase the game would be in if cmd was executed anyway.
+//
+// The game can always be stopped and may only be started if it is not running already.
+// Commands, which require a stopped game according to GetCommand, e.g. set pieces,
+// are only allowed in PhaseStopped.
+func (p Phase) Transition(cmd Command) (Phase, error) {
+	next := p.target(cmd)
+
+	reject := func(reason string) (Phase, error) {
+		return next, &TransitionError{
+			Phase:   p,
+			Command: cmd,
+			Reason:  reason,
+		}
+	}
+
+	info, ok := GetCommand(cmd)
+	switch {
+	case !ok:
+		return reject("unknown command")
+
+	case cmd == CommandStart && p == PhaseRunning:
+		return reject("game is already running")
+
+	case !info.RequiresStopped || p == PhaseStopped:
+		return next, nil
+
+	case p == PhaseRunning:
+		return reject("game is running, stop it first")
+	}
+	return reject("a set piece is pending, start or stop the game first")
+}
@@ -0,0 +1,53 @@
+package api_test
+
+import (
+	"testing"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Transition() in phase.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestPhaseTransition(t *testing.T) {
+	for _, tc := range []struct {
+		Phase       Phase
+		Command     Command
+		Expected    Phase
+		ShouldError bool
+	}{
+		{PhaseStopped, CommandKickOffCyan, PhaseSetPiece, false},
+		{PhaseStopped, CommandDroppedBall, PhaseDroppedBall, false},
+		{PhaseStopped, CommandStart, PhaseRunning, false},
+		{PhaseStopped, CommandStop, PhaseStopped, false},
+		{PhaseStopped, CommandGoIn, PhaseStopped, false},
+		{PhaseStopped, CommandPassDemo, PhaseRunning, false},
+		{PhaseSetPiece, CommandStart, PhaseRunning, false},
+		{PhaseSetPiece, CommandStop, PhaseStopped, false},
+		{PhaseSetPiece, CommandCornerMagenta, PhaseSetPiece, true},
+		{PhaseDroppedBall, CommandStart, PhaseRunning, false},
+		{PhaseDroppedBall, CommandGoOut, PhaseDroppedBall, true},
+		{PhaseRunning, CommandStop, PhaseStopped, false},
+		{PhaseRunning, CommandRoleAssignerOff, PhaseRunning, false},
+		{PhaseRunning, CommandKickOffCyan, PhaseSetPiece, true},
+		{PhaseRunning, CommandStart, PhaseRunning, true},
+		{PhaseRunning, CommandBallHandlingDemo, PhaseRunning, true},
+	} {
+		t.Run(string(tc.Phase)+"/"+string(tc.Command), func(t *testing.T) {
+			a := assert.New(t)
+
+			got, err := tc.Phase.Transition(tc.Command)
+			a.Equal(tc.Expected, got)
+			if !tc.ShouldError {
+				a.NoError(err)
+				return
+			}
+			if a.IsType(&TransitionError{}, err) {
+				a.Equal(tc.Phase, err.(*TransitionError).Phase)
+				a.Equal(tc.Command, err.(*TransitionError).Command)
+			}
+		})
+	}
+}
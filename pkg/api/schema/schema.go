@@ -0,0 +1,290 @@
+// Package schema generates JSON Schema documents describing the protocol types defined in package api.
+//
+// Enumeration values are obtained from api.EnumValues, maxima of numeric fields from api.FieldMaximum and
+// the ranges of positions on the field from api.DefaultFieldDimensions.
+// TurtleState fields, which may not be written by the operator, are marked as read-only.
+package schema
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+// Draft is the JSON Schema dialect of the generated documents.
+const Draft = "http://json-schema.org/draft-07/schema#"
+
+// Schema is a JSON Schema document or subschema.
+type Schema struct {
+	Schema string `json:"$schema,omitempty"`
+	Ref    string `json:"$ref,omitempty"`
+	Title  string `json:"title,omitempty"`
+
+	Type    string    `json:"type,omitempty"`
+	Enum    []string  `json:"enum,omitempty"`
+	Pattern string    `json:"pattern,omitempty"`
+	Minimum *float64  `json:"minimum,omitempty"`
+	Maximum *float64  `json:"maximum,omitempty"`
+	Items   *Schema   `json:"items,omitempty"`
+	AllOf   []*Schema `json:"allOf,omitempty"`
+	OneOf   []*Schema `json:"oneOf,omitempty"`
+
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+
+	Definitions map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// Document describes a generated schema document.
+type Document struct {
+	// Name is the name of the document, which is also used as the base of its file name.
+	Name string
+
+	// Value is a value of the Go type the document describes.
+	Value interface{}
+}
+
+// Documents lists the schema documents describing the protocol.
+var Documents = []Document{
+	{Name: "message", Value: api.Message{}},
+	{Name: "handshake", Value: api.Handshake{}},
+	{Name: "token", Value: api.TokenUpdate{}},
+	{Name: "state", Value: api.State{}},
+	{Name: "turtle_state", Value: api.TurtleState{}},
+}
+
+// Lookup returns the value of the document named name and whether it exists.
+func Lookup(name string) (interface{}, bool) {
+	for _, doc := range Documents {
+		if doc.Name == name {
+			return doc.Value, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	ulidType        = reflect.TypeOf(ulid.ULID{})
+	semverType      = reflect.TypeOf(semver.Version{})
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+	positionType    = reflect.TypeOf(api.Position{})
+	poseType        = reflect.TypeOf(api.Pose{})
+	matchType       = reflect.TypeOf(api.Match{})
+	turtleStateType = reflect.TypeOf(api.TurtleState{})
+)
+
+// generator generates a schema document and collects the definitions of named struct types.
+type generator struct {
+	definitions map[string]*Schema
+}
+
+// Generate returns the JSON Schema document describing the JSON encoding of v.
+// v must be a struct.
+func Generate(v interface{}) (*Schema, error) {
+	t := reflect.TypeOf(v)
+	if t.Kind() != reflect.Struct {
+		return nil, errors.Errorf("expected a struct, got %s", t)
+	}
+
+	g := &generator{
+		definitions: make(map[string]*Schema),
+	}
+	s, err := g.structSchema(t)
+	if err != nil {
+		return nil, err
+	}
+	s.Schema = Draft
+	s.Title = t.Name()
+	if len(g.definitions) > 0 {
+		s.Definitions = g.definitions
+	}
+	return s, nil
+}
+
+// floatPtr returns a pointer to v.
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+// schema returns the schema of values of type t.
+func (g *generator) schema(t reflect.Type) (*Schema, error) {
+	switch t {
+	case ulidType:
+		return &Schema{Type: "string", Pattern: "^[0-9A-HJKMNP-TV-Z]{26}$"}, nil
+	case semverType:
+		return &Schema{Type: "string", Pattern: `^\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`}, nil
+	case rawMessageType:
+		return &Schema{}, nil
+	}
+
+	if vs, ok := api.EnumValues(t); ok {
+		return &Schema{Type: "string", Enum: vs}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s := &Schema{Type: "integer", Minimum: floatPtr(0)}
+		if t.Bits() < 64 {
+			s.Maximum = floatPtr(float64(uint64(1)<<uint(t.Bits()) - 1))
+		}
+		return s, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}, nil
+
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+
+	case reflect.Slice, reflect.Array:
+		items, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, errors.Errorf("unsupported map key type %s", t.Key())
+		}
+		elem, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		if t.Elem().Kind() == reflect.Ptr {
+			// null values represent removal of the element, e.g. of a turtle leaving the roster.
+			elem = &Schema{OneOf: []*Schema{elem, {Type: "null"}}}
+		}
+		return &Schema{Type: "object", AdditionalProperties: elem}, nil
+
+	case reflect.Interface:
+		return &Schema{}, nil
+
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.definitions[t.Name()]; !ok {
+			// Register the name before recursing to support recursive types.
+			g.definitions[t.Name()] = nil
+			s, err := g.structSchema(t)
+			if err != nil {
+				return nil, err
+			}
+			s.Title = t.Name()
+			g.definitions[t.Name()] = s
+		}
+		return &Schema{Ref: "#/definitions/" + t.Name()}, nil
+	}
+	return nil, errors.Errorf("unsupported type %s", t)
+}
+
+// structSchema returns the schema of struct type t.
+func (g *generator) structSchema(t reflect.Type) (*Schema, error) {
+	s := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema, t.NumField()),
+		AdditionalProperties: false,
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		if name == "" {
+			name = f.Name
+		}
+
+		fs, err := g.schema(f.Type)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to generate schema of field %s of %s", f.Name, t)
+		}
+		if max, ok := api.FieldMaximum(f); ok {
+			fs.Maximum = floatPtr(max)
+		}
+		if t == turtleStateType && f.Tag.Get("api") != "writable" {
+			if fs.Ref != "" {
+				// Keywords adjacent to $ref are ignored.
+				fs = &Schema{AllOf: []*Schema{fs}}
+			}
+			fs.ReadOnly = true
+		}
+		g.fieldRange(t, name, fs)
+		s.Properties[name] = fs
+
+		omitempty := false
+		for _, opt := range opts[1:] {
+			if opt == "omitempty" {
+				omitempty = true
+			}
+		}
+		if !omitempty {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+	return s, nil
+}
+
+// fieldRange sets the range of field name of struct type t in s, if it is restricted by api.Validator implementations,
+// but not declared by tags.
+func (g *generator) fieldRange(t reflect.Type, name string, s *Schema) {
+	if t == matchType && name == "clock" {
+		s.Minimum = floatPtr(0)
+		return
+	}
+	if t != positionType && t != poseType {
+		return
+	}
+
+	field := api.DefaultFieldDimensions()
+	switch name {
+	case "x":
+		s.Minimum = floatPtr(-(field.Length/2 + field.Margin))
+		s.Maximum = floatPtr(field.Length/2 + field.Margin)
+	case "y":
+		s.Minimum = floatPtr(-(field.Width/2 + field.Margin))
+		s.Maximum = floatPtr(field.Width/2 + field.Margin)
+	case "heading":
+		s.Minimum = floatPtr(-math.Pi)
+		s.Maximum = floatPtr(math.Pi)
+	}
+}
+
+// Marshal returns the indented JSON encoding of the document describing v terminated by a newline.
+func Marshal(v interface{}) ([]byte, error) {
+	s, err := Generate(v)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode schema")
+	}
+	return append(b, '\n'), nil
+}
@@ -0,0 +1,41 @@
+package schema_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/api/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "Update the committed schema documents")
+
+//Test_items: Documents, Marshal() in schema.go
+//Input_spec: Committed schema documents
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestDocuments(t *testing.T) {
+	for _, doc := range Documents {
+		t.Run(doc.Name, func(t *testing.T) {
+			a := assert.New(t)
+
+			b, err := Marshal(doc.Value)
+			if !a.NoError(err) {
+				t.FailNow()
+			}
+
+			path := doc.Name + ".json"
+			if *update {
+				a.NoError(ioutil.WriteFile(path, b, 0644))
+				return
+			}
+
+			committed, err := ioutil.ReadFile(path)
+			if !a.NoError(err) {
+				t.FailNow()
+			}
+			a.Equal(string(committed), string(b), "%s drifted from the Go types, regenerate it by running `go test ./pkg/api/schema -update`", path)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// ValidationReasonInconsistent represents a value, which differs from the one shared by the rest of the team.
+	ValidationReasonInconsistent ValidationReason = "inconsistent"
+
+	// ValidationReasonDuplicate represents a value, which must be unique within the team, but is not.
+	ValidationReasonDuplicate ValidationReason = "duplicate"
+
+	// ValidationReasonMissing represents a value, which the team requires, but no turtle has.
+	ValidationReasonMissing ValidationReason = "missing"
+)
+
+// IsActive reports whether the turtle takes part in the game, i.e. whether it has a role other than RoleInactive.
+func (s *TurtleState) IsActive() bool {
+	return s.Role != "" && s.Role != RoleInactive
+}
+
+// ValidateTeam validates the consistency of the team configuration of s.Turtles and
+// returns ValidationErrors listing every violation found or nil.
+//
+// All active turtles must share a team color and a home goal, exactly one of them must be a goalkeeper
+// and no two of them may have the same refbox role. Inactive turtles must not be in the field.
+// Turtles, which deviate from the team color or home goal shared by the majority of the team, are reported.
+func (s *State) ValidateTeam() error {
+	ids := make([]string, 0, len(s.Turtles))
+	for id, ts := range s.Turtles {
+		if ts != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var errs ValidationErrors
+	add := func(id, field string, reason ValidationReason, msg string, args ...interface{}) {
+		path := "turtles"
+		if id != "" {
+			path = mapKeyPath(path, id) + "." + field
+		}
+		errs = append(errs, &ValidationError{
+			Path:    path,
+			Reason:  reason,
+			Message: fmt.Sprintf(msg, args...),
+		})
+	}
+
+	// majority returns the most common non-empty value of the active turtles' field returned by f.
+	// Ties are broken in favor of the value of the turtle with the lowest ID.
+	majority := func(f func(*TurtleState) string) string {
+		counts := make(map[string]int)
+		for _, id := range ids {
+			if ts := s.Turtles[id]; ts.IsActive() && f(ts) != "" {
+				counts[f(ts)]++
+			}
+		}
+
+		var ret string
+		for _, id := range ids {
+			if v := f(s.Turtles[id]); counts[v] > counts[ret] {
+				ret = v
+			}
+		}
+		return ret
+	}
+	teamColor := majority(func(ts *TurtleState) string { return string(ts.TeamColor) })
+	homeGoal := majority(func(ts *TurtleState) string { return string(ts.HomeGoal) })
+
+	var active, goalkeepers int
+	refBoxRoles := make(map[RefBoxRole]string)
+	for _, id := range ids {
+		ts := s.Turtles[id]
+		if !ts.IsActive() {
+			if ts.Role == RoleInactive && ts.RobotInField != nil && *ts.RobotInField {
+				add(id, "robotinfield", ValidationReasonInconsistent, "inactive turtle must not be in the field")
+			}
+			continue
+		}
+		active++
+
+		if ts.TeamColor != "" && string(ts.TeamColor) != teamColor {
+			add(id, "teamcolor", ValidationReasonInconsistent, "team color %s differs from %s used by the team", ts.TeamColor, teamColor)
+		}
+		if ts.HomeGoal != "" && string(ts.HomeGoal) != homeGoal {
+			add(id, "homegoal", ValidationReasonInconsistent, "home goal %s differs from %s used by the team", ts.HomeGoal, homeGoal)
+		}
+
+		if ts.Role == RoleGoalkeeper {
+			goalkeepers++
+			if goalkeepers > 1 {
+				add(id, "role", ValidationReasonDuplicate, "team has more than one goalkeeper")
+			}
+		}
+
+		if ts.RefBoxRole != "" {
+			if other, ok := refBoxRoles[ts.RefBoxRole]; ok {
+				add(id, "refboxrole", ValidationReasonDuplicate, "refbox role %s is already assigned to turtle %s", ts.RefBoxRole, other)
+			} else {
+				refBoxRoles[ts.RefBoxRole] = id
+			}
+		}
+	}
+	if active > 0 && goalkeepers == 0 {
+		add("", "", ValidationReasonMissing, "team has no goalkeeper")
+	}
+	return errs.err()
+}
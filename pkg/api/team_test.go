@@ -0,0 +1,85 @@
+package api_test
+
+import (
+	"testing"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: ValidateTeam() in team.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestValidateTeam(t *testing.T) {
+	inField := true
+
+	type violation struct {
+		Path   string
+		Reason ValidationReason
+	}
+
+	for _, tc := range []struct {
+		Name     string
+		Turtles  map[string]*TurtleState
+		Expected []violation
+	}{
+		{
+			Name: "unknown roles",
+			Turtles: map[string]*TurtleState{
+				"1": {},
+				"2": nil,
+			},
+		},
+		{
+			Name: "a consistent team",
+			Turtles: map[string]*TurtleState{
+				"1": {Role: RoleGoalkeeper, TeamColor: TeamColorCyan, HomeGoal: HomeGoalBlue, RefBoxRole: RefBoxRole1},
+				"2": {Role: RoleAttackerMain, TeamColor: TeamColorCyan, HomeGoal: HomeGoalBlue, RefBoxRole: RefBoxRole2},
+				"3": {Role: RoleInactive, TeamColor: TeamColorMagenta, RefBoxRole: RefBoxRole1},
+			},
+		},
+		{
+			Name: "an inconsistent team",
+			Turtles: map[string]*TurtleState{
+				"1": {Role: RoleGoalkeeper, TeamColor: TeamColorCyan, HomeGoal: HomeGoalBlue, RefBoxRole: RefBoxRole1},
+				"2": {Role: RoleGoalkeeper, TeamColor: TeamColorMagenta, HomeGoal: HomeGoalBlue, RefBoxRole: RefBoxRole2},
+				"3": {Role: RoleDefenderMain, TeamColor: TeamColorCyan, HomeGoal: HomeGoalYellow, RefBoxRole: RefBoxRole1},
+				"4": {Role: RoleInactive, RobotInField: &inField},
+			},
+			Expected: []violation{
+				{`turtles["2"].teamcolor`, ValidationReasonInconsistent},
+				{`turtles["2"].role`, ValidationReasonDuplicate},
+				{`turtles["3"].homegoal`, ValidationReasonInconsistent},
+				{`turtles["3"].refboxrole`, ValidationReasonDuplicate},
+				{`turtles["4"].robotinfield`, ValidationReasonInconsistent},
+			},
+		},
+		{
+			Name: "a team without goalkeeper",
+			Turtles: map[string]*TurtleState{
+				"1": {Role: RoleAttackerMain},
+				"2": {Role: RoleInactive},
+			},
+			Expected: []violation{
+				{`turtles`, ValidationReasonMissing},
+			},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			a := assert.New(t)
+
+			err := (&State{Turtles: tc.Turtles}).ValidateTeam()
+			if len(tc.Expected) == 0 {
+				a.Nil(err)
+				return
+			}
+
+			var got []violation
+			for _, err := range AsValidationErrors(err) {
+				got = append(got, violation{err.Path, err.Reason})
+			}
+			a.Equal(tc.Expected, got)
+		})
+	}
+}
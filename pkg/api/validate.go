@@ -1,7 +1,13 @@
 package api
 
 import (
+	"fmt"
+	"math"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/pkg/errors"
 )
@@ -11,6 +17,108 @@ type Validator interface {
 	Validate() error
 }
 
+// ValidationReason is a machine-readable reason of a validation error.
+type ValidationReason string
+
+const (
+	// ValidationReasonOutOfRange represents a value outside of the allowed range.
+	ValidationReasonOutOfRange ValidationReason = "out_of_range"
+
+	// ValidationReasonInvalidValue represents a value, which is not one of the allowed values.
+	ValidationReasonInvalidValue ValidationReason = "invalid_value"
+)
+
+// ValidationError represents a single violation found during validation.
+type ValidationError struct {
+	// Path represents the path to the invalid field in JSON notation relative to the validated value,
+	// e.g. turtles["3"].batteryvoltage. Path is empty if the validated value itself is invalid.
+	Path string `json:"path"`
+
+	// Reason represents the reason of the violation.
+	Reason ValidationReason `json:"reason"`
+
+	// Message represents the human-readable description of the violation.
+	Message string `json:"message"`
+}
+
+// Error implements error.
+func (err *ValidationError) Error() string {
+	if err.Path == "" {
+		return err.Message
+	}
+	return fmt.Sprintf("%s: %s", err.Path, err.Message)
+}
+
+// ValidationErrors is a collection of violations found during validation.
+// Validate methods of composite values return ValidationErrors listing every violation found.
+type ValidationErrors []*ValidationError
+
+// Error implements error.
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// AsValidationErrors returns err as ValidationErrors.
+// If err is not ValidationErrors, it is returned as a single violation of the value itself
+// with ValidationReasonInvalidValue.
+func AsValidationErrors(err error) ValidationErrors {
+	switch err := errors.Cause(err).(type) {
+	case nil:
+		return nil
+	case ValidationErrors:
+		return err
+	case *ValidationError:
+		return ValidationErrors{err}
+	default:
+		return ValidationErrors{{
+			Reason:  ValidationReasonInvalidValue,
+			Message: err.Error(),
+		}}
+	}
+}
+
+// err returns errs as an error, or nil if errs is empty.
+func (errs ValidationErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// add appends the violations in err to errs with paths prefixed by path.
+func (errs *ValidationErrors) add(path string, err error) {
+	for _, v := range AsValidationErrors(err) {
+		cp := *v
+		switch {
+		case cp.Path == "":
+			cp.Path = path
+		case strings.HasPrefix(cp.Path, "["):
+			cp.Path = path + cp.Path
+		case path != "":
+			cp.Path = path + "." + cp.Path
+		}
+		*errs = append(*errs, &cp)
+	}
+}
+
+// addRange appends an out-of-range violation at path to errs.
+func (errs *ValidationErrors) addRange(path, msg string, args ...interface{}) {
+	*errs = append(*errs, &ValidationError{
+		Path:    path,
+		Reason:  ValidationReasonOutOfRange,
+		Message: fmt.Sprintf(msg, args...),
+	})
+}
+
+// mapKeyPath returns the path of element identified by key in map at path.
+func mapKeyPath(path, key string) string {
+	return fmt.Sprintf("%s[%s]", path, strconv.Quote(key))
+}
+
 // Validate implements Validator.
 func (v BallFound) Validate() error {
 	switch v {
@@ -93,88 +201,267 @@ func (v KinectState) Validate() error {
 }
 
 // Validate implements Validator.
+// Valid commands are the ones described by ListCommands.
 func (v Command) Validate() error {
+	if _, ok := GetCommand(v); !ok {
+		return errors.Errorf("invalid Command: %s", v)
+	}
+	return nil
+}
+
+// Contains reports whether the point (x, y) is located on the field including the margin.
+func (d FieldDimensions) Contains(x, y float64) bool {
+	return math.Abs(x) <= d.Length/2+d.Margin && math.Abs(y) <= d.Width/2+d.Margin
+}
+
+// Validate implements Validator.
+func (d FieldDimensions) Validate() error {
+	switch {
+	case !(d.Length > 0):
+		return rangeError("Length")
+	case !(d.Width > 0):
+		return rangeError("Width")
+	case !(d.Margin >= 0):
+		return rangeError("Margin")
+	}
+	return nil
+}
+
+// FieldValidator represents an entity, which contains positions on the field and can validate itself
+// against field dimensions.
+type FieldValidator interface {
+	ValidateOn(d FieldDimensions) error
+}
+
+// validatePoint appends violations of point (x, y) not being located on d to errs.
+func validatePoint(errs *ValidationErrors, d FieldDimensions, x, y float64) {
+	if maxX := d.Length/2 + d.Margin; !(math.Abs(x) <= maxX) {
+		errs.addRange("x", "must be within -%g … %g, got %g", maxX, maxX, x)
+	}
+	if maxY := d.Width/2 + d.Margin; !(math.Abs(y) <= maxY) {
+		errs.addRange("y", "must be within -%g … %g, got %g", maxY, maxY, y)
+	}
+}
+
+// Validate implements Validator.
+// Position is validated against DefaultFieldDimensions.
+func (v Position) Validate() error {
+	return v.ValidateOn(DefaultFieldDimensions())
+}
+
+// ValidateOn implements FieldValidator.
+func (v Position) ValidateOn(d FieldDimensions) error {
+	var errs ValidationErrors
+	validatePoint(&errs, d, v.X, v.Y)
+	return errs.err()
+}
+
+// Validate implements Validator.
+// Pose is validated against DefaultFieldDimensions.
+func (v Pose) Validate() error {
+	return v.ValidateOn(DefaultFieldDimensions())
+}
+
+// ValidateOn implements FieldValidator.
+func (v Pose) ValidateOn(d FieldDimensions) error {
+	var errs ValidationErrors
+	validatePoint(&errs, d, v.X, v.Y)
+	if !(math.Abs(v.Heading) <= math.Pi) {
+		errs.addRange("heading", "must be within -π … π, got %g", v.Heading)
+	}
+	return errs.err()
+}
+
+// Validate implements Validator.
+func (v Velocity) Validate() error {
+	var errs ValidationErrors
+	if speed := math.Hypot(v.X, v.Y); !(speed <= MaxSpeed) {
+		errs.addRange("", "speed must be at most %g, got %g", MaxSpeed, speed)
+	}
+	if math.IsNaN(v.Angular) || math.IsInf(v.Angular, 0) {
+		errs.addRange("angular", "must be finite, got %g", v.Angular)
+	}
+	return errs.err()
+}
+
+// IsSetPiece reports whether v is a set-piece command.
+func (v Command) IsSetPiece() bool {
+	info, ok := GetCommand(v)
+	return ok && info.SetPiece
+}
+
+// Validate implements Validator.
+func (v Period) Validate() error {
 	switch v {
-	case CommandDroppedBall,
-		CommandStart,
-		CommandStop,
-		CommandGoIn,
-		CommandGoOut,
-		CommandKickOffMagenta,
-		CommandKickOffCyan,
-		CommandFreeKickMagenta,
-		CommandFreeKickCyan,
-		CommandGoalKickMagenta,
-		CommandGoalKickCyan,
-		CommandThrowInMagenta,
-		CommandThrowInCyan,
-		CommandCornerMagenta,
-		CommandCornerCyan,
-		CommandPenaltyMagenta,
-		CommandPenaltyCyan,
-		CommandRoleAssignerOn,
-		CommandRoleAssignerOff,
-		CommandPassDemo,
-		CommandPenaltyMode,
-		CommandBallHandlingDemo:
+	case PeriodFirstHalf, PeriodHalfTime, PeriodSecondHalf, PeriodPenalties:
 	default:
-		return errors.Errorf("invalid Command: %s", v)
+		return errors.Errorf("invalid Period: %s", v)
+	}
+	return nil
+}
+
+// Validate implements Validator.
+func (m *Match) Validate() error {
+	var errs ValidationErrors
+	if err := m.Period.Validate(); err != nil {
+		errs.add("period", err)
+	}
+	if err := m.Phase.Validate(); err != nil {
+		errs.add("phase", err)
+	}
+	if !(m.Clock >= 0) {
+		errs.addRange("clock", "must not be negative, got %g", m.Clock)
+	}
+	if m.LastSetPiece != "" && !m.LastSetPiece.IsSetPiece() {
+		errs.add("last_set_piece", errors.Errorf("invalid set piece: %s", m.LastSetPiece))
+	}
+	return errs.err()
+}
+
+// Validate implements Validator.
+// The token must be non-empty and must not contain control characters.
+func (u *TokenUpdate) Validate() error {
+	if u.Token == "" {
+		return errors.New("token must not be empty")
+	}
+	for _, r := range u.Token {
+		if unicode.IsControl(r) {
+			return errors.Errorf("token must not contain control characters, got %q", r)
+		}
+	}
+	return nil
+}
+
+// Validate implements Validator.
+func (u *MatchUpdate) Validate() error {
+	if u.Period != "" {
+		return u.Period.Validate()
+	}
+	return nil
+}
+
+// Validate implements Validator.
+func (v BatteryChemistry) Validate() error {
+	switch v {
+	case BatteryChemistryLiPo, BatteryChemistryLiIon, BatteryChemistryLiFePO4, BatteryChemistryNiMH:
+	default:
+		return errors.Errorf("invalid BatteryChemistry: %s", v)
 	}
 	return nil
 }
 
+// Validate implements Validator.
+func (i *TurtleInfo) Validate() error {
+	var errs ValidationErrors
+	if i.BatteryChemistry != "" {
+		if err := i.BatteryChemistry.Validate(); err != nil {
+			errs.add("battery_chemistry", err)
+		}
+	}
+	return errs.err()
+}
+
 // rangeError returns an out-of-range error.
 func rangeError(source string) error {
 	return errors.Errorf("%s out of range", source)
 }
 
+// FieldMaximum returns the maximum value of the numeric field f declared by its `max` tag
+// and whether f declares one.
+func FieldMaximum(f reflect.StructField) (float64, bool) {
+	tag, ok := f.Tag.Lookup("max")
+	if !ok {
+		return 0, false
+	}
+
+	max, err := strconv.ParseFloat(tag, 64)
+	if err != nil {
+		panic(errors.Wrapf(err, "invalid max tag of field %s", f.Name))
+	}
+	return max, true
+}
+
+// jsonName returns the name of the JSON object key f is encoded as.
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
 // Validate implements Validator.
+// Validate returns ValidationErrors listing every invalid field of s.
+// Positions are validated against DefaultFieldDimensions.
 func (s *TurtleState) Validate() error {
-	switch {
-	case s.RestartCountVision != nil && *s.RestartCountVision > 99:
-		return rangeError("RestartCountVision")
-	case s.RestartCountWorldmodel != nil && *s.RestartCountWorldmodel > 99:
-		return rangeError("RestartCountWorldmodel")
-	case s.BatteryVoltage != nil && *s.BatteryVoltage > 99:
-		return rangeError("BatteryVoltage")
-	case s.EmergencyStatus != nil && *s.EmergencyStatus > 100:
-		return rangeError("EmergencyStatus")
-	case s.ActiveDevPC != nil && *s.ActiveDevPC > 90:
-		return rangeError("ActiveDevPC")
-	}
+	return s.ValidateOn(DefaultFieldDimensions())
+}
+
+// ValidateOn implements FieldValidator.
+// ValidateOn returns ValidationErrors listing every invalid field of s, where positions are validated against d.
+func (s *TurtleState) ValidateOn(d FieldDimensions) error {
+	var errs ValidationErrors
 
 	rv := reflect.Indirect(reflect.ValueOf(s))
 	for i := 0; i < rv.NumField(); i++ {
 		fv := reflect.Indirect(rv.Field(i))
-		if !fv.IsValid() || reflect.DeepEqual(fv.Interface(), reflect.Zero(fv.Type()).Interface()) {
+		if !fv.IsValid() {
 			continue
 		}
 
-		v, ok := fv.Interface().(Validator)
-		if !ok {
+		if max, ok := FieldMaximum(rv.Type().Field(i)); ok && float64(fv.Uint()) > max {
+			errs.addRange(jsonName(rv.Type().Field(i)), "must be at most %g, got %d", max, fv.Uint())
+		}
+
+		if reflect.DeepEqual(fv.Interface(), reflect.Zero(fv.Type()).Interface()) {
 			continue
 		}
 
-		if err := v.Validate(); err != nil {
-			return errors.Wrapf(err, "invalid value of %s", rv.Type().Field(i).Name)
+		var err error
+		switch v := fv.Interface().(type) {
+		case FieldValidator:
+			err = v.ValidateOn(d)
+		case Validator:
+			err = v.Validate()
+		}
+		if err != nil {
+			errs.add(jsonName(rv.Type().Field(i)), err)
 		}
 	}
-	return nil
+	return errs.err()
 }
 
 // Validate implements Validator.
+// Validate returns ValidationErrors listing every invalid field of s including the ones of all turtles.
+// Positions are validated against DefaultFieldDimensions.
 func (s *State) Validate() error {
-	if s.Command != "" && s.Command.Validate() != nil {
-		return s.Command.Validate()
+	return s.ValidateOn(DefaultFieldDimensions())
+}
+
+// ValidateOn implements FieldValidator.
+// ValidateOn returns ValidationErrors listing every invalid field of s including the ones of all turtles,
+// where positions are validated against d.
+func (s *State) ValidateOn(d FieldDimensions) error {
+	var errs ValidationErrors
+	if s.Command != "" {
+		if err := s.Command.Validate(); err != nil {
+			errs.add("command", err)
+		}
+	}
+	ids := make([]string, 0, len(s.Turtles))
+	for id := range s.Turtles {
+		ids = append(ids, id)
 	}
-	for _, ts := range s.Turtles {
+	sort.Strings(ids)
+	for _, id := range ids {
+		ts := s.Turtles[id]
 		if ts == nil {
 			continue
 		}
 
-		if err := ts.Validate(); err != nil {
-			return err
+		if err := ts.ValidateOn(d); err != nil {
+			errs.add(mapKeyPath("turtles", id), err)
 		}
 	}
-	return nil
+	return errs.err()
 }
@@ -3,6 +3,7 @@ package api_test
 import (
 	"testing"
 
+	"github.com/pkg/errors"
 	. "github.com/rvolosatovs/turtlitto/pkg/api"
 	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
 	"github.com/stretchr/testify/assert"
@@ -111,6 +112,70 @@ func TestValidate(t *testing.T) {
 			},
 			ShouldError: true,
 		},
+		{
+			Name: "a pose on the field",
+			Input: &TurtleState{
+				Pose:     &Pose{X: 11.5, Y: -7.5, Heading: -3},
+				Velocity: &Velocity{X: 1, Y: -2, Angular: 1},
+				Ball:     &Position{X: -11, Y: 7},
+			},
+			ShouldError: false,
+		},
+		{
+			Name: "a pose outside of the field",
+			Input: &TurtleState{
+				Pose: &Pose{X: 12.5},
+			},
+			ShouldError: true,
+		},
+		{
+			Name: "a pose with invalid heading",
+			Input: &TurtleState{
+				Pose: &Pose{Heading: 4},
+			},
+			ShouldError: true,
+		},
+		{
+			Name: "a ball outside of the field",
+			Input: &TurtleState{
+				Ball: &Position{Y: 8.5},
+			},
+			ShouldError: true,
+		},
+		{
+			Name: "a velocity exceeding maximum speed",
+			Input: &TurtleState{
+				Velocity: &Velocity{X: 8, Y: 8},
+			},
+			ShouldError: true,
+		},
+		{
+			Name: "a valid match",
+			Input: &Match{
+				Period:       PeriodSecondHalf,
+				Phase:        PhaseSetPiece,
+				Clock:        42,
+				LastSetPiece: CommandCornerCyan,
+			},
+			ShouldError: false,
+		},
+		{
+			Name: "a match with invalid period",
+			Input: &Match{
+				Period: "overtime",
+				Phase:  PhaseStopped,
+			},
+			ShouldError: true,
+		},
+		{
+			Name: "a match with invalid last set piece",
+			Input: &Match{
+				Period:       PeriodFirstHalf,
+				Phase:        PhaseRunning,
+				LastSetPiece: CommandStart,
+			},
+			ShouldError: true,
+		},
 	} {
 		t.Run(tc.Name, func(t *testing.T) {
 			err := tc.Input.Validate()
@@ -121,4 +186,122 @@ func TestValidate(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("random states", func(t *testing.T) {
+		apitest.NewTestGenerator(t).CheckStates(t, 100, func(st *State) error {
+			return st.Validate()
+		})
+	})
+}
+
+//Test_items: Validate(), ValidationErrors in validate.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestValidationErrors(t *testing.T) {
+	a := assert.New(t)
+
+	err := (&State{
+		Command: "dance",
+		Turtles: map[string]*TurtleState{
+			"1": {
+				BatteryVoltage: apitest.Uint8Ptr(42),
+			},
+			"3": {
+				BatteryVoltage:     apitest.Uint8Ptr(100),
+				RestartCountMotion: apitest.Uint8Ptr(100),
+				HomeGoal:           "red",
+				Pose:               &Pose{X: 12.5, Heading: 4},
+			},
+			"4": nil,
+		},
+	}).Validate()
+
+	errs, ok := err.(ValidationErrors)
+	if !a.True(ok) {
+		t.FailNow()
+	}
+
+	type violation struct {
+		Path   string
+		Reason ValidationReason
+	}
+	var got []violation
+	for _, err := range errs {
+		a.NotEmpty(err.Message)
+		got = append(got, violation{err.Path, err.Reason})
+	}
+	a.ElementsMatch([]violation{
+		{`command`, ValidationReasonInvalidValue},
+		{`turtles["3"].restartcountmotion`, ValidationReasonOutOfRange},
+		{`turtles["3"].batteryvoltage`, ValidationReasonOutOfRange},
+		{`turtles["3"].homegoal`, ValidationReasonInvalidValue},
+		{`turtles["3"].pose.x`, ValidationReasonOutOfRange},
+		{`turtles["3"].pose.heading`, ValidationReasonOutOfRange},
+	}, got)
+
+	a.Equal(ValidationErrors{{
+		Reason:  ValidationReasonInvalidValue,
+		Message: "test",
+	}}, AsValidationErrors(errors.New("test")))
+	a.Nil(AsValidationErrors(nil))
+}
+
+//Test_items: ValidateOn() in validate.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestValidateOn(t *testing.T) {
+	a := assert.New(t)
+
+	st := &State{
+		Turtles: map[string]*TurtleState{
+			"1": {
+				Pose: &Pose{X: 15},
+				Ball: &Position{Y: 10},
+			},
+		},
+	}
+	a.Len(AsValidationErrors(st.Validate()), 2)
+
+	large := FieldDimensions{Length: 36, Width: 24, Margin: 1}
+	a.Nil(st.ValidateOn(large))
+	a.Nil((&Pose{X: 15}).ValidateOn(large))
+	a.NotNil((&Position{X: 20}).ValidateOn(large))
+}
+
+//Test_items: ValidateWritable(), Writable(), WritableTurtleFields() in writable.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestValidateWritable(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal([]string{"homegoal", "refboxrole", "robotinfield", "role", "teamcolor"}, WritableTurtleFields())
+
+	inField := true
+	ts := &TurtleState{
+		Role:               RoleGoalkeeper,
+		RobotInField:       &inField,
+		BatteryVoltage:     apitest.Uint8Ptr(42),
+		RestartCountVision: apitest.Uint8Ptr(1),
+	}
+	a.Equal(&TurtleState{
+		Role:         RoleGoalkeeper,
+		RobotInField: &inField,
+	}, ts.Writable())
+
+	a.Nil(ValidateWritable(map[string]*TurtleState{
+		"1": ts.Writable(),
+		"2": nil,
+	}))
+
+	errs := AsValidationErrors(ValidateWritable(map[string]*TurtleState{
+		"1": ts,
+	}))
+	if a.Len(errs, 2) {
+		a.Equal(`turtles["1"].restartcountvision`, errs[0].Path)
+		a.Equal(`turtles["1"].batteryvoltage`, errs[1].Path)
+		a.Equal(ValidationReasonReadOnly, errs[0].Reason)
+	}
 }
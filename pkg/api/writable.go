@@ -0,0 +1,83 @@
+package api
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ValidationReasonReadOnly represents a value, which may not be written, because it is telemetry.
+const ValidationReasonReadOnly ValidationReason = "read_only"
+
+// writableTurtleFields holds the indexes of TurtleState fields tagged with `api:"writable"`.
+var writableTurtleFields = func() map[int]struct{} {
+	m := make(map[int]struct{})
+	rt := reflect.TypeOf(TurtleState{})
+	for i := 0; i < rt.NumField(); i++ {
+		if rt.Field(i).Tag.Get("api") == "writable" {
+			m[i] = struct{}{}
+		}
+	}
+	return m
+}()
+
+// WritableTurtleFields returns the JSON names of TurtleState fields, which may be changed by the operator.
+func WritableTurtleFields() []string {
+	rt := reflect.TypeOf(TurtleState{})
+
+	names := make([]string, 0, len(writableTurtleFields))
+	for i := range writableTurtleFields {
+		names = append(names, jsonName(rt.Field(i)))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Writable returns a copy of s, which only contains the fields, which may be changed by the operator.
+func (s *TurtleState) Writable() *TurtleState {
+	rv := reflect.ValueOf(s).Elem()
+
+	ret := &TurtleState{}
+	wv := reflect.ValueOf(ret).Elem()
+	for i := range writableTurtleFields {
+		wv.Field(i).Set(rv.Field(i))
+	}
+	return ret
+}
+
+// validateWritable appends a violation to errs for every telemetry field set in s.
+func (s *TurtleState) validateWritable(errs *ValidationErrors, path string) {
+	rv := reflect.ValueOf(s).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		if _, ok := writableTurtleFields[i]; ok {
+			continue
+		}
+
+		fv := rv.Field(i)
+		if reflect.DeepEqual(fv.Interface(), reflect.Zero(fv.Type()).Interface()) {
+			continue
+		}
+		*errs = append(*errs, &ValidationError{
+			Path:    path + "." + jsonName(rv.Type().Field(i)),
+			Reason:  ValidationReasonReadOnly,
+			Message: "telemetry field may not be written",
+		})
+	}
+}
+
+// ValidateWritable returns ValidationErrors listing every telemetry field set in turtles or nil.
+// Paths are relative to State, e.g. turtles["3"].batteryvoltage.
+func ValidateWritable(turtles map[string]*TurtleState) error {
+	ids := make([]string, 0, len(turtles))
+	for id := range turtles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs ValidationErrors
+	for _, id := range ids {
+		if ts := turtles[id]; ts != nil {
+			ts.validateWritable(&errs, mapKeyPath("turtles", id))
+		}
+	}
+	return errs.err()
+}
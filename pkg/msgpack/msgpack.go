@@ -0,0 +1,327 @@
+// Package msgpack implements MessagePack encoding of values, which can be encoded as JSON.
+//
+// Values are converted to and from MessagePack through their JSON representation,
+// hence the `json` struct tags and json.Marshaler/json.Unmarshaler implementations are respected.
+// Only the MessagePack types representable in JSON are supported, i.e. nil, bool,
+// integers, floats, strings, arrays and maps with string keys.
+package msgpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Marshal returns the MessagePack encoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := encode(buf, tree); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes the MessagePack-encoded data b and stores the result in v.
+// If strict is true, unknown fields in b are considered an error, see json.Decoder.DisallowUnknownFields.
+func Unmarshal(b []byte, v interface{}, strict bool) error {
+	r := bytes.NewReader(b)
+	tree, err := decode(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return errors.Errorf("%d trailing bytes after MessagePack value", r.Len())
+	}
+
+	jb, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(jb))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(v)
+}
+
+// writeUint writes the big-endian representation of v of size n bytes prefixed by code to buf.
+func writeUint(buf *bytes.Buffer, code byte, v uint64, n int) {
+	buf.WriteByte(code)
+	for i := n - 1; i >= 0; i-- {
+		buf.WriteByte(byte(v >> (8 * uint(i))))
+	}
+}
+
+// writeLen writes the header of a string, array or map of length n to buf.
+// fix is the code of the fixed-size variant, which is used if n <= fixMax.
+// code16 is the code of the 16-bit variant, code16+1 is the code of the 32-bit variant.
+func writeLen(buf *bytes.Buffer, n int, fix byte, fixMax int, code8, code16 byte) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		writeUint(buf, code8, uint64(n), 1)
+	case n <= math.MaxUint16:
+		writeUint(buf, code16, uint64(n), 2)
+	default:
+		writeUint(buf, code16+1, uint64(n), 4)
+	}
+}
+
+// encode writes the MessagePack encoding of the JSON tree v to buf.
+func encode(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			switch {
+			case i >= 0 && i <= 0x7f:
+				buf.WriteByte(byte(i))
+			case i >= -32 && i < 0:
+				buf.WriteByte(byte(int8(i)))
+			case i >= 0 && i <= math.MaxUint8:
+				writeUint(buf, 0xcc, uint64(i), 1)
+			case i >= 0 && i <= math.MaxUint16:
+				writeUint(buf, 0xcd, uint64(i), 2)
+			case i >= 0 && i <= math.MaxUint32:
+				writeUint(buf, 0xce, uint64(i), 4)
+			case i >= 0:
+				writeUint(buf, 0xcf, uint64(i), 8)
+			case i >= math.MinInt8:
+				writeUint(buf, 0xd0, uint64(uint8(i)), 1)
+			case i >= math.MinInt16:
+				writeUint(buf, 0xd1, uint64(uint16(i)), 2)
+			case i >= math.MinInt32:
+				writeUint(buf, 0xd2, uint64(uint32(i)), 4)
+			default:
+				writeUint(buf, 0xd3, uint64(i), 8)
+			}
+			return nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			writeUint(buf, 0xcf, u, 8)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return errors.Wrapf(err, "invalid number %s", v)
+		}
+		writeUint(buf, 0xcb, math.Float64bits(f), 8)
+
+	case string:
+		writeLen(buf, len(v), 0xa0, 31, 0xd9, 0xda)
+		buf.WriteString(v)
+
+	case []interface{}:
+		writeLen(buf, len(v), 0x90, 15, 0, 0xdc)
+		for _, e := range v {
+			if err := encode(buf, e); err != nil {
+				return err
+			}
+		}
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		writeLen(buf, len(v), 0x80, 15, 0, 0xde)
+		for _, k := range keys {
+			if err := encode(buf, k); err != nil {
+				return err
+			}
+			if err := encode(buf, v[k]); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return errors.Errorf("unsupported type %T", v)
+	}
+	return nil
+}
+
+// readN reads n bytes from r.
+func readN(r *bytes.Reader, n uint64) ([]byte, error) {
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+// readUint reads a big-endian unsigned integer of size n bytes from r.
+func readUint(r *bytes.Reader, n int) (uint64, error) {
+	b, err := readN(r, uint64(n))
+	if err != nil {
+		return 0, err
+	}
+
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// decodeString reads a string of length n from r.
+func decodeString(r *bytes.Reader, n uint64) (string, error) {
+	b, err := readN(r, n)
+	return string(b), err
+}
+
+// decodeArray reads an array of length n from r.
+func decodeArray(r *bytes.Reader, n uint64) ([]interface{}, error) {
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	vs := make([]interface{}, n)
+	for i := range vs {
+		v, err := decode(r)
+		if err != nil {
+			return nil, err
+		}
+		vs[i] = v
+	}
+	return vs, nil
+}
+
+// decodeMap reads a map of length n from r.
+func decodeMap(r *bytes.Reader, n uint64) (map[string]interface{}, error) {
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	m := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		k, err := decode(r)
+		if err != nil {
+			return nil, err
+		}
+		ks, ok := k.(string)
+		if !ok {
+			return nil, errors.Errorf("unsupported map key type %T", k)
+		}
+
+		v, err := decode(r)
+		if err != nil {
+			return nil, err
+		}
+		m[ks] = v
+	}
+	return m, nil
+}
+
+// decode reads a single MessagePack value from r into a JSON tree.
+func decode(r *bytes.Reader) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return decodeString(r, uint64(c&0x1f))
+	case c&0xf0 == 0x90:
+		return decodeArray(r, uint64(c&0x0f))
+	case c&0xf0 == 0x80:
+		return decodeMap(r, uint64(c&0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return readUint(r, 1<<(c-0xcc))
+
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (c - 0xd0)
+		u, err := readUint(r, n)
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend
+		shift := uint(64 - 8*n)
+		return int64(u<<shift) >> shift, nil
+
+	case 0xca:
+		u, err := readUint(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(uint32(u))), nil
+
+	case 0xcb:
+		u, err := readUint(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(u), nil
+
+	case 0xd9, 0xda, 0xdb:
+		n, err := readUint(r, 1<<(c-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return decodeString(r, n)
+
+	case 0xdc, 0xdd:
+		n, err := readUint(r, 2<<(c-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return decodeArray(r, n)
+
+	case 0xde, 0xdf:
+		n, err := readUint(r, 2<<(c-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMap(r, n)
+	}
+	return nil, errors.Errorf("unsupported MessagePack type 0x%x", c)
+}
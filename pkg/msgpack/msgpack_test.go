@@ -0,0 +1,105 @@
+package msgpack_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	. "github.com/rvolosatovs/turtlitto/pkg/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Marshal() in msgpack.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestMarshal(t *testing.T) {
+	for _, tc := range []struct {
+		Input    interface{}
+		Expected string
+	}{
+		{nil, "c0"},
+		{true, "c3"},
+		{false, "c2"},
+		{0, "00"},
+		{127, "7f"},
+		{128, "cc80"},
+		{-1, "ff"},
+		{-32, "e0"},
+		{-33, "d0df"},
+		{65535, "cdffff"},
+		{-32769, "d2ffff7fff"},
+		{uint64(math.MaxUint64), "cfffffffffffffffff"},
+		{1.5, "cb3ff8000000000000"},
+		{"", "a0"},
+		{"abc", "a3616263"},
+		{[]int{1, 2}, "920102"},
+		{map[string]int{"b": 2, "a": 1}, "82a16101a16202"},
+	} {
+		b, err := Marshal(tc.Input)
+		if assert.NoError(t, err) {
+			assert.Equal(t, tc.Expected, hex.EncodeToString(b), "%v", tc.Input)
+		}
+	}
+}
+
+//Test_items: Marshal(), Unmarshal() in msgpack.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestRoundtrip(t *testing.T) {
+	a := assert.New(t)
+
+	g := apitest.NewTestGenerator(t)
+	for i := 0; i < 100; i++ {
+		expected := g.Message()
+
+		b, err := Marshal(expected)
+		a.NoError(err)
+
+		got := &api.Message{}
+		err = Unmarshal(b, got, true)
+		a.NoError(err)
+		a.Equal(expected.MessageID, got.MessageID)
+		a.Equal(expected.ParentID, got.ParentID)
+		a.Equal(expected.Type, got.Type)
+		a.JSONEq(string(expected.Payload), string(got.Payload))
+	}
+
+	g.CheckStates(t, 100, func(expected *api.State) error {
+		b, err := Marshal(expected)
+		if err != nil {
+			return err
+		}
+		got := &api.State{}
+		if err := Unmarshal(b, got, true); err != nil {
+			return err
+		}
+		eb, err := json.Marshal(expected)
+		if err != nil {
+			return err
+		}
+		gb, err := json.Marshal(got)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(eb, gb) {
+			return errors.Errorf("decoded state %s does not match", gb)
+		}
+		return nil
+	})
+
+	b, err := Marshal(map[string]int{"unknown": 42})
+	a.NoError(err)
+	a.Error(Unmarshal(b, &api.Message{}, true))
+	a.NoError(Unmarshal(b, &api.Message{}, false))
+
+	a.Error(Unmarshal([]byte{0x92, 0x01}, &[]int{}, false), "truncated array")
+	a.Error(Unmarshal([]byte{0x01, 0x02}, new(int), false), "trailing bytes")
+	a.Error(Unmarshal([]byte{0xc1}, new(int), false), "reserved type")
+}
@@ -0,0 +1,272 @@
+package srrstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mohae/deepcopy"
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/webapi"
+)
+
+// StatusError is returned by Client, if the web API responds with a status code other than 2xx.
+type StatusError struct {
+	// Code is the HTTP status code of the response.
+	Code int
+	// Message is the error message in the response body.
+	Message string
+	// Violations are the violations listed in the response body, if any.
+	Violations api.ValidationErrors
+}
+
+// Error implements error.
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", err.Code, http.StatusText(err.Code), err.Message)
+}
+
+// StatusCode returns the HTTP status code of err, if it is caused by a *StatusError, and 0 otherwise.
+func StatusCode(err error) int {
+	if serr, ok := errors.Cause(err).(*StatusError); ok {
+		return serr.Code
+	}
+	return 0
+}
+
+// Client is a typed client of the web API of a single TRC.
+// Client is safe for concurrent use by multiple goroutines.
+type Client struct {
+	// URL is the base URL of the web API, e.g. http://127.0.0.1:4242.
+	URL string
+	// HTTP is the client used to perform HTTP requests.
+	HTTP *http.Client
+	// TRC is the name of the TRC, the endpoints of which are scoped by it, see webapi.ScopedEndpoint.
+	// If empty, the endpoints of the single TRC served are used.
+	TRC string
+
+	keyMu sync.RWMutex
+	key   string
+}
+
+// NewClient returns a new *Client of the web API served at url.
+func NewClient(url string) *Client {
+	return &Client{
+		URL:  strings.TrimSuffix(url, "/"),
+		HTTP: &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+// SessionKey returns the session key obtained by the last successful call to Auth.
+func (c *Client) SessionKey() string {
+	c.keyMu.RLock()
+	defer c.keyMu.RUnlock()
+	return c.key
+}
+
+// do performs a request with method on ep, where body, if not nil, is encoded as JSON.
+// If v is not nil, the response body is decoded into it.
+func (c *Client) do(method, ep, user, pass string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode request body")
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.URL+"/"+ep, r)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(user, pass)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read response body")
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		serr := &StatusError{
+			Code:    resp.StatusCode,
+			Message: strings.TrimSpace(string(b)),
+		}
+		if resp.Header.Get("Content-Type") == "application/json" {
+			var er webapi.ErrorResponse
+			if err := json.Unmarshal(b, &er); err == nil {
+				serr.Message = er.Error
+				serr.Violations = er.Violations
+			}
+		}
+		return serr
+	}
+
+	if v == nil {
+		return nil
+	}
+	if raw, ok := v.(*[]byte); ok {
+		*raw = b
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(b, v), "failed to decode response body")
+}
+
+// endpoint returns the endpoint ep of the TRC of c.
+func (c *Client) endpoint(ep string) string {
+	if c.TRC == "" {
+		return ep
+	}
+	return webapi.ScopedEndpoint(c.TRC, ep)
+}
+
+// authorized performs a request with method on the endpoint ep of the TRC of c authorized by the session key.
+func (c *Client) authorized(method, ep string, body, v interface{}) error {
+	return c.do(method, c.endpoint(ep), "", c.SessionKey(), body, v)
+}
+
+// Auth authenticates the client using token and stores the session key obtained.
+func (c *Client) Auth(token string) error {
+	var b []byte
+	if err := c.do("GET", c.endpoint(webapi.AuthEndpoint), "", token, nil, &b); err != nil {
+		return err
+	}
+
+	c.keyMu.Lock()
+	c.key = string(b)
+	c.keyMu.Unlock()
+	return nil
+}
+
+// SendCommand sends cmd to TRC.
+func (c *Client) SendCommand(cmd api.Command) error {
+	return c.authorized("POST", webapi.CommandEndpoint, cmd, nil)
+}
+
+// SetTurtles sends the turtle states st to TRC.
+func (c *Client) SetTurtles(st map[string]*api.TurtleState) error {
+	return c.authorized("POST", webapi.TurtleEndpoint, st, nil)
+}
+
+// UpdateMatch applies u to the state of the match.
+func (c *Client) UpdateMatch(u *api.MatchUpdate) error {
+	return c.authorized("POST", webapi.MatchEndpoint, u, nil)
+}
+
+// TurtleInfo returns the information about all registered turtles.
+func (c *Client) TurtleInfo() (map[string]*api.TurtleInfo, error) {
+	var infos map[string]*api.TurtleInfo
+	if err := c.authorized("GET", webapi.TurtleInfoEndpoint, nil, &infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// UpdateTurtleInfo applies upd to the turtle registry and returns the resulting information about all registered turtles.
+func (c *Client) UpdateTurtleInfo(upd map[string]*api.TurtleInfo) (map[string]*api.TurtleInfo, error) {
+	var infos map[string]*api.TurtleInfo
+	if err := c.authorized("POST", webapi.TurtleInfoEndpoint, upd, &infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// Commands returns the known commands.
+func (c *Client) Commands() ([]api.CommandInfo, error) {
+	var cmds []api.CommandInfo
+	if err := c.do("GET", webapi.CommandsEndpoint, "", "", nil, &cmds); err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
+
+// Schema returns the JSON Schema document named name.
+func (c *Client) Schema(name string) ([]byte, error) {
+	var b []byte
+	if err := c.do("GET", webapi.SchemaEndpoint+"/"+name+".json", "", "", nil, &b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// OpenState opens a WebSocket on webapi.StateEndpoint using the session key and reads the initial state.
+func (c *Client) OpenState() (*StateConn, error) {
+	wsConn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(c.URL, "http")+"/"+webapi.StateEndpoint, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open WebSocket")
+	}
+
+	sc := &StateConn{
+		conn:  wsConn,
+		state: &webapi.State{},
+	}
+	if err := wsConn.WriteJSON(c.SessionKey()); err != nil {
+		wsConn.Close()
+		return nil, errors.Wrap(err, "failed to write session key")
+	}
+	if _, err := sc.Next(DefaultTimeout); err != nil {
+		wsConn.Close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+// StateConn is a WebSocket connection to webapi.StateEndpoint.
+// StateConn is not safe for concurrent use by multiple goroutines.
+type StateConn struct {
+	conn  *websocket.Conn
+	state *webapi.State
+}
+
+// Next waits at most timeout for the next state update, merges it into the state received so far
+// and returns the resulting state.
+// The WebSocket cannot be used anymore once Next returned an error.
+func (sc *StateConn) Next(timeout time.Duration) (*webapi.State, error) {
+	if err := sc.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, errors.Wrap(err, "failed to set read deadline")
+	}
+
+	_, b, err := sc.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	st := deepcopy.Copy(sc.state).(*webapi.State)
+	// The turtle information and warnings are always sent in full, hence they are replaced instead of merged.
+	// Warnings are omitted, if there are none.
+	st.TurtleInfo = nil
+	st.Warnings = nil
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, errors.Wrap(err, "failed to decode state")
+	}
+	for id, ts := range st.Turtles {
+		if ts == nil {
+			delete(st.Turtles, id)
+		}
+	}
+	sc.state = st
+	return sc.State(), nil
+}
+
+// State returns the state received so far.
+func (sc *StateConn) State() *webapi.State {
+	return deepcopy.Copy(sc.state).(*webapi.State)
+}
+
+// Close closes the WebSocket.
+func (sc *StateConn) Close() error {
+	return sc.conn.Close()
+}
@@ -0,0 +1,327 @@
+// Package srrstest runs SRRS in-process for end-to-end testing of the web API.
+//
+// An Env connects the web API to a mock TRC over an in-memory connection and serves it on a local HTTP server,
+// such that tests can drive it using a typed Client and inspect the messages received by TRC.
+package srrstest
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	"github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultToken is the token sent by TRC in the handshake, unless specified otherwise.
+	DefaultToken = "test"
+
+	// DefaultTimeout is the time the helpers of Env wait for, unless specified otherwise.
+	DefaultTimeout = time.Second
+)
+
+// config is the configuration of an Env.
+type config struct {
+	token    string
+	roster   []string
+	timeout  time.Duration
+	webOpts  []webapi.Option
+	trcOpts  []trctest.Option
+	connOpts []trcapi.Option
+}
+
+// Option represents an Env option.
+type Option func(*config)
+
+// WithToken sets the token sent by TRC in the handshake.
+func WithToken(tok string) Option {
+	return func(conf *config) {
+		conf.token = tok
+	}
+}
+
+// WithRoster sets the IDs of turtles initially known to SRRS.
+func WithRoster(ids ...string) Option {
+	return func(conf *config) {
+		conf.roster = ids
+	}
+}
+
+// WithTimeout sets the time the helpers of Env wait for.
+func WithTimeout(d time.Duration) Option {
+	return func(conf *config) {
+		conf.timeout = d
+	}
+}
+
+// WithWebAPIOptions configures the web API using opts.
+func WithWebAPIOptions(opts ...webapi.Option) Option {
+	return func(conf *config) {
+		conf.webOpts = append(conf.webOpts, opts...)
+	}
+}
+
+// WithTRCOptions configures the mock TRC using opts.
+// Handlers of state, ping, handshake and token messages are registered by Env and must not be registered again.
+func WithTRCOptions(opts ...trctest.Option) Option {
+	return func(conf *config) {
+		conf.trcOpts = append(conf.trcOpts, opts...)
+	}
+}
+
+// WithConnOptions configures the SRRS-side TRC connections using opts.
+func WithConnOptions(opts ...trcapi.Option) Option {
+	return func(conf *config) {
+		conf.connOpts = append(conf.connOpts, opts...)
+	}
+}
+
+// Env is an in-process SRRS connected to a mock TRC.
+// Env is safe for concurrent use by multiple goroutines.
+type Env struct {
+	// Pool is the pool of connections to TRC used by the web API.
+	Pool *trcapi.Pool
+	// Server serves the web API.
+	Server *httptest.Server
+	// Client is a client of the web API served by Server.
+	Client *Client
+
+	token   string
+	timeout time.Duration
+
+	trcMu sync.RWMutex
+	trc   *trctest.Conn
+	err   error
+
+	receivedMu sync.Mutex
+	received   []*api.Message
+	// matched holds the indexes of messages in received matched by ExpectTRCReceived.
+	matched map[int]bool
+	// receivedCh is closed and replaced every time a message is received.
+	receivedCh chan struct{}
+}
+
+// New returns a new *Env configured by opts.
+// The connection to TRC is established before New returns.
+func New(opts ...Option) (*Env, error) {
+	conf := &config{
+		token:   DefaultToken,
+		roster:  api.DefaultRoster,
+		timeout: DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(conf)
+	}
+
+	env := &Env{
+		token:      conf.token,
+		timeout:    conf.timeout,
+		matched:    make(map[int]bool),
+		receivedCh: make(chan struct{}),
+	}
+	env.Pool = trcapi.NewPool(func() (*trcapi.Conn, func(), error) {
+		return env.connect(conf)
+	})
+	if _, err := env.Pool.Conn(); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	webapi.RegisterHandlers(env.Pool, mux, conf.webOpts...)
+	env.Server = httptest.NewServer(mux)
+	env.Client = NewClient(env.Server.URL)
+	return env, nil
+}
+
+// record returns a handler, which records the messages received by TRC and calls h.
+func (env *Env) record(h trctest.Handler) trctest.Handler {
+	return func(msg *api.Message) (*api.Message, error) {
+		env.receivedMu.Lock()
+		env.received = append(env.received, msg)
+		close(env.receivedCh)
+		env.receivedCh = make(chan struct{})
+		env.receivedMu.Unlock()
+		return h(msg)
+	}
+}
+
+// connect establishes a new connection to a new mock TRC configured by conf.
+func (env *Env) connect(conf *config) (*trcapi.Conn, func(), error) {
+	srrsConn, trcConn := net.Pipe()
+
+	trc := trctest.Connect(trcConn, trcConn, append([]trctest.Option{
+		trctest.WithHandler(api.MessageTypeState, env.record(trctest.DefaultStateHandler)),
+		trctest.WithHandler(api.MessageTypePing, env.record(trctest.DefaultPingHandler)),
+		trctest.WithHandler(api.MessageTypeHandshake, env.record(trctest.DefaultHandshakeHandler)),
+		trctest.WithHandler(api.MessageTypeToken, env.record(trctest.DefaultTokenHandler)),
+	}, conf.trcOpts...)...)
+
+	go func() {
+		for err := range trc.Errors() {
+			zap.L().Error("Mock TRC failed", zap.Error(err))
+
+			env.trcMu.Lock()
+			if env.err == nil {
+				env.err = err
+			}
+			env.trcMu.Unlock()
+		}
+	}()
+
+	hsCh := make(chan error, 1)
+	go func() {
+		hsCh <- trc.SendHandshake(&api.Handshake{
+			Version: trcapi.DefaultVersion,
+			Token:   conf.token,
+		})
+	}()
+
+	closeFn := func() {
+		trc.Close()
+		trcConn.Close()
+		srrsConn.Close()
+	}
+
+	conn, err := trcapi.Connect(trcapi.DefaultVersion, srrsConn, srrsConn, append([]trcapi.Option{
+		trcapi.WithRoster(conf.roster...),
+	}, conf.connOpts...)...)
+	if err != nil {
+		closeFn()
+		return nil, nil, errors.Wrap(err, "failed to connect to mock TRC")
+	}
+	if err := <-hsCh; err != nil {
+		closeFn()
+		return nil, nil, errors.Wrap(err, "failed to send handshake")
+	}
+
+	env.trcMu.Lock()
+	env.trc = trc
+	env.trcMu.Unlock()
+	return conn, func() {
+		conn.Close()
+		closeFn()
+	}, nil
+}
+
+// TRC returns the mock TRC SRRS is currently connected to.
+func (env *Env) TRC() *trctest.Conn {
+	env.trcMu.RLock()
+	defer env.trcMu.RUnlock()
+	return env.trc
+}
+
+// Token returns the token sent by TRC in the handshake.
+func (env *Env) Token() string {
+	return env.token
+}
+
+// Err returns the first error encountered by the mock TRC, if any.
+func (env *Env) Err() error {
+	env.trcMu.RLock()
+	defer env.trcMu.RUnlock()
+	return env.err
+}
+
+// Received returns the messages received by TRC so far in order of reception.
+func (env *Env) Received() []*api.Message {
+	env.receivedMu.Lock()
+	defer env.receivedMu.Unlock()
+	return append([]*api.Message{}, env.received...)
+}
+
+// Close stops the server and closes the connection to TRC.
+func (env *Env) Close() error {
+	err := env.Pool.Close()
+	env.Server.Close()
+	return err
+}
+
+// AuthAndOpenState authenticates Client using the token of TRC and opens a WebSocket on webapi.StateEndpoint.
+// AuthAndOpenState fails the test on error.
+func (env *Env) AuthAndOpenState(t testing.TB) *StateConn {
+	t.Helper()
+
+	if err := env.Client.Auth(env.token); err != nil {
+		t.Fatalf("Failed to authenticate: %s", err)
+	}
+	sc, err := env.Client.OpenState()
+	if err != nil {
+		t.Fatalf("Failed to open state WebSocket: %s", err)
+	}
+	return sc
+}
+
+// ExpectStateWithin reads state updates on sc until the state received so far satisfies pred and returns it.
+// ExpectStateWithin fails the test if that does not happen within d.
+func (env *Env) ExpectStateWithin(t testing.TB, sc *StateConn, d time.Duration, pred func(*webapi.State) bool) *webapi.State {
+	t.Helper()
+
+	if st := sc.State(); pred(st) {
+		return st
+	}
+
+	deadline := time.Now().Add(d)
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			t.Fatalf("State not matched within %s, last state: %s", d, marshalString(sc.State()))
+		}
+
+		st, err := sc.Next(left)
+		if err != nil {
+			t.Fatalf("State not matched within %s, last state: %s, error: %s", d, marshalString(sc.State()), err)
+		}
+		if pred(st) {
+			return st
+		}
+	}
+}
+
+// ExpectTRCReceived waits for TRC to receive a message of type typ, which satisfies pred, and returns it.
+// pred may be nil, in which case any message of type typ matches.
+// Messages returned by previous calls to ExpectTRCReceived are not matched again.
+// ExpectTRCReceived fails the test if no such message is received within the timeout of env.
+func (env *Env) ExpectTRCReceived(t testing.TB, typ api.MessageType, pred func(*api.Message) bool) *api.Message {
+	t.Helper()
+
+	timer := time.NewTimer(env.timeout)
+	defer timer.Stop()
+
+	for {
+		env.receivedMu.Lock()
+		for i, msg := range env.received {
+			if env.matched[i] || msg.Type != typ || pred != nil && !pred(msg) {
+				continue
+			}
+			env.matched[i] = true
+			env.receivedMu.Unlock()
+			return msg
+		}
+		ch := env.receivedCh
+		env.receivedMu.Unlock()
+
+		select {
+		case <-ch:
+		case <-timer.C:
+			t.Fatalf("TRC did not receive a matching message of type %s within %s", typ, env.timeout)
+		}
+	}
+}
+
+// marshalString returns the JSON encoding of v or the error message, if v cannot be encoded.
+func marshalString(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
@@ -0,0 +1,144 @@
+package srrstest_test
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	. "github.com/rvolosatovs/turtlitto/pkg/srrstest"
+	"github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingTB is a testing.TB, which records fatal failures instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	failure string
+}
+
+// Helper implements testing.TB.
+func (tb *recordingTB) Helper() {}
+
+// Fatalf implements testing.TB.
+func (tb *recordingTB) Fatalf(format string, args ...interface{}) {
+	tb.failure = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+// run calls f with tb in a separate goroutine and returns the failure recorded, if any.
+func (tb *recordingTB) run(f func(testing.TB)) string {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(tb)
+	}()
+	<-done
+	return tb.failure
+}
+
+func newEnv(t *testing.T, opts ...Option) *Env {
+	env, err := New(opts...)
+	if err != nil {
+		t.Fatalf("Failed to start SRRS: %s", err)
+	}
+	return env
+}
+
+//Test_items: ExpectStateWithin() in srrstest.go, Next() in client.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestExpectStateWithin(t *testing.T) {
+	a := assert.New(t)
+
+	env := newEnv(t)
+	defer env.Close()
+
+	sc := env.AuthAndOpenState(t)
+	defer sc.Close()
+
+	a.NoError(env.TRC().SendState(&api.State{Command: api.CommandStop}))
+	st := env.ExpectStateWithin(t, sc, DefaultTimeout, func(st *webapi.State) bool {
+		return st.Command == api.CommandStop
+	})
+	a.Equal(api.CommandStop, st.Command)
+
+	// The state received so far is matched without waiting.
+	tb := &recordingTB{TB: t}
+	a.Empty(tb.run(func(tb testing.TB) {
+		env.ExpectStateWithin(tb, sc, 0, func(st *webapi.State) bool {
+			return st.Command == api.CommandStop
+		})
+	}))
+
+	tb = &recordingTB{TB: t}
+	failure := tb.run(func(tb testing.TB) {
+		env.ExpectStateWithin(tb, sc, 50*time.Millisecond, func(st *webapi.State) bool {
+			return st.Command == api.CommandStart
+		})
+	})
+	a.Contains(failure, "State not matched within 50ms")
+	a.Contains(failure, `"command":"stop"`)
+}
+
+//Test_items: Next() in client.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestStateConnNext(t *testing.T) {
+	a := assert.New(t)
+
+	env := newEnv(t)
+	defer env.Close()
+
+	sc := env.AuthAndOpenState(t)
+	defer sc.Close()
+
+	a.NoError(env.TRC().SendState(&api.State{Command: api.CommandStop}))
+	st, err := sc.Next(DefaultTimeout)
+	if a.NoError(err) {
+		a.Equal(api.CommandStop, st.Command)
+	}
+	a.Equal(st, sc.State())
+
+	start := time.Now()
+	_, err = sc.Next(50 * time.Millisecond)
+	a.Error(err)
+	a.True(time.Since(start) < DefaultTimeout, "Next must return once the timeout expires")
+	a.Equal(api.CommandStop, sc.State().Command, "state must be kept on error")
+}
+
+//Test_items: ExpectTRCReceived() in srrstest.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestExpectTRCReceived(t *testing.T) {
+	a := assert.New(t)
+
+	env := newEnv(t, WithTimeout(50*time.Millisecond))
+	defer env.Close()
+
+	a.NoError(env.Client.Auth(env.Token()))
+	a.NoError(env.Client.SendCommand(api.CommandStart))
+
+	isRequest := func(msg *api.Message) bool {
+		return msg.ParentID == nil
+	}
+	msg := env.ExpectTRCReceived(t, api.MessageTypeState, isRequest)
+	a.Equal(api.MessageTypeState, msg.Type)
+
+	// Messages matched already are not matched again.
+	tb := &recordingTB{TB: t}
+	failure := tb.run(func(tb testing.TB) {
+		env.ExpectTRCReceived(tb, api.MessageTypeState, isRequest)
+	})
+	a.Equal("TRC did not receive a matching message of type state within 50ms", failure)
+
+	tb = &recordingTB{TB: t}
+	failure = tb.run(func(tb testing.TB) {
+		env.ExpectTRCReceived(tb, api.MessageTypeToken, nil)
+	})
+	a.Equal("TRC did not receive a matching message of type token within 50ms", failure)
+}
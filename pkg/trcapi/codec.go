@@ -0,0 +1,183 @@
+package trcapi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/msgpack"
+)
+
+// MaxFrameSize is the maximum size of a frame payload in bytes.
+const MaxFrameSize = 1 << 20
+
+// frameHeaderSize is the size of the big-endian frame length prefix in bytes.
+const frameHeaderSize = 4
+
+// SupportedEncodings are the encodings supported by this package in order of preference.
+var SupportedEncodings = []api.Encoding{
+	api.EncodingMsgPack,
+	api.EncodingJSON,
+}
+
+// Encoder encodes values.
+type Encoder interface {
+	Encode(v interface{}) (err error)
+}
+
+// Decoder decodes values.
+type Decoder interface {
+	Decode(v interface{}) (err error)
+}
+
+// FrameError is returned by framed decoders if a single frame could not be decoded.
+// The frame is skipped, hence decoding may continue after a FrameError.
+type FrameError struct {
+	Err error
+}
+
+// Error implements error.
+func (err *FrameError) Error() string {
+	return "malformed frame: " + err.Err.Error()
+}
+
+// IsFrameError reports whether the cause of err is a *FrameError.
+func IsFrameError(err error) bool {
+	_, ok := errors.Cause(err).(*FrameError)
+	return ok
+}
+
+// frameEncoder encodes values as length-prefixed MessagePack frames.
+type frameEncoder struct {
+	w io.Writer
+}
+
+// Encode implements Encoder.
+// Each frame is written using a single Write call.
+func (e *frameEncoder) Encode(v interface{}) error {
+	b, err := msgpack.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) > MaxFrameSize {
+		return errors.Errorf("frame size %d exceeds maximum of %d", len(b), MaxFrameSize)
+	}
+
+	buf := make([]byte, frameHeaderSize+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b)))
+	copy(buf[frameHeaderSize:], b)
+
+	_, err = e.w.Write(buf)
+	return err
+}
+
+// frameDecoder decodes values from length-prefixed MessagePack frames.
+type frameDecoder struct {
+	r      io.Reader
+	header []byte
+}
+
+// Decode implements Decoder.
+// Decode returns io.EOF if r is exhausted at a frame boundary and *FrameError if the frame payload is malformed.
+func (d *frameDecoder) Decode(v interface{}) error {
+	if _, err := io.ReadFull(d.r, d.header); err != nil {
+		return err
+	}
+
+	n := binary.BigEndian.Uint32(d.header)
+	if n > MaxFrameSize {
+		// The stream cannot be resynchronized reliably, since the length prefix is most probably corrupted.
+		return errors.Errorf("frame size %d exceeds maximum of %d", n, MaxFrameSize)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if err := msgpack.Unmarshal(b, v, true); err != nil {
+		return &FrameError{Err: err}
+	}
+	return nil
+}
+
+// NewEncoder returns a new Encoder, which writes values encoded using enc to w.
+func NewEncoder(w io.Writer, enc api.Encoding) (Encoder, error) {
+	switch enc {
+	case api.EncodingJSON:
+		return json.NewEncoder(w), nil
+	case api.EncodingMsgPack:
+		return &frameEncoder{w: w}, nil
+	}
+	return nil, errors.Errorf("unsupported encoding: %s", enc)
+}
+
+// NewDecoder returns a new Decoder, which reads values encoded using enc from r.
+// Unknown fields are considered an error.
+func NewDecoder(r io.Reader, enc api.Encoding) (Decoder, error) {
+	switch enc {
+	case api.EncodingJSON:
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		return dec, nil
+	case api.EncodingMsgPack:
+		return &frameDecoder{
+			r:      r,
+			header: make([]byte, frameHeaderSize),
+		}, nil
+	}
+	return nil, errors.Errorf("unsupported encoding: %s", enc)
+}
+
+// NegotiateEncoding returns the first encoding in offered, which is also contained in supported.
+// NegotiateEncoding returns api.EncodingJSON if there is no such encoding.
+func NegotiateEncoding(offered, supported []api.Encoding) api.Encoding {
+	for _, o := range offered {
+		for _, s := range supported {
+			if o == s {
+				return o
+			}
+		}
+	}
+	return api.EncodingJSON
+}
+
+// spaceSkippingReader skips whitespace preceding the data read from r.
+type spaceSkippingReader struct {
+	r       *bufio.Reader
+	skipped bool
+}
+
+// Read implements io.Reader.
+func (r *spaceSkippingReader) Read(p []byte) (int, error) {
+	for !r.skipped {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		if err := r.r.UnreadByte(); err != nil {
+			return 0, err
+		}
+		r.skipped = true
+	}
+	return r.r.Read(p)
+}
+
+// RemainingReader returns an io.Reader, which reads the data buffered by dec followed by r.
+// Whitespace separating the last JSON value decoded by dec and the following data is skipped.
+// RemainingReader is used to switch the encoding after the JSON-encoded handshake.
+func RemainingReader(dec *json.Decoder, r io.Reader) io.Reader {
+	return &spaceSkippingReader{
+		r: bufio.NewReader(io.MultiReader(dec.Buffered(), r)),
+	}
+}
@@ -0,0 +1,169 @@
+package trcapi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	. "github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: NewEncoder(), NewDecoder() in codec.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestFrameDecoderRecovery(t *testing.T) {
+	a := assert.New(t)
+
+	g := apitest.NewTestGenerator(t)
+
+	buf := &bytes.Buffer{}
+	enc, err := NewEncoder(buf, api.EncodingMsgPack)
+	a.NoError(err)
+
+	first := api.NewMessage(api.MessageTypePing, nil, nil)
+	a.NoError(enc.Encode(first))
+
+	// Frame with a truncated MessagePack payload.
+	buf.Write([]byte{0, 0, 0, 2, 0x92, 0x01})
+
+	second := api.NewMessage(api.MessageTypePing, nil, g.ULID())
+	a.NoError(enc.Encode(second))
+
+	dec, err := NewDecoder(buf, api.EncodingMsgPack)
+	a.NoError(err)
+
+	var msg api.Message
+	a.NoError(dec.Decode(&msg))
+	a.Equal(first.MessageID, msg.MessageID)
+
+	err = dec.Decode(&api.Message{})
+	a.Error(err)
+	a.True(IsFrameError(err))
+
+	msg = api.Message{}
+	a.NoError(dec.Decode(&msg))
+	a.Equal(second.MessageID, msg.MessageID)
+	a.Equal(second.ParentID, msg.ParentID)
+
+	a.Equal(io.EOF, dec.Decode(&api.Message{}))
+
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, MaxFrameSize+1)
+	dec, err = NewDecoder(bytes.NewReader(header), api.EncodingMsgPack)
+	a.NoError(err)
+
+	err = dec.Decode(&api.Message{})
+	a.Error(err)
+	a.False(IsFrameError(err))
+}
+
+//Test_items: NegotiateEncoding() in codec.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestNegotiateEncoding(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(api.EncodingJSON, NegotiateEncoding(nil, SupportedEncodings))
+	a.Equal(api.EncodingJSON, NegotiateEncoding([]api.Encoding{"cbor"}, SupportedEncodings))
+	a.Equal(api.EncodingMsgPack, NegotiateEncoding([]api.Encoding{"cbor", api.EncodingMsgPack}, SupportedEncodings))
+	a.Equal(api.EncodingJSON, NegotiateEncoding([]api.Encoding{api.EncodingJSON, api.EncodingMsgPack}, SupportedEncodings))
+	a.Equal(api.EncodingJSON, NegotiateEncoding([]api.Encoding{api.EncodingMsgPack}, []api.Encoding{api.EncodingJSON}))
+}
+
+//Test_items: Connect(), SetState(), State() in conn.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestMsgPackEncoding(t *testing.T) {
+	a := assert.New(t)
+
+	srrsConn, trcConn := net.Pipe()
+
+	trc := trctest.Connect(trcConn, trcConn,
+		trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+		trctest.WithHandler(api.MessageTypeState, trctest.DefaultStateHandler),
+		trctest.WithHandler(api.MessageTypePing, trctest.DefaultPingHandler),
+	)
+	defer trc.Close()
+
+	go func() {
+		for err := range trc.Errors() {
+			t.Errorf("TRC error: %s", err)
+		}
+	}()
+
+	hsErrCh := make(chan error, 1)
+	go func() {
+		hsErrCh <- trc.SendHandshake(&api.Handshake{
+			Version:   DefaultVersion,
+			Encodings: []api.Encoding{api.EncodingMsgPack, api.EncodingJSON},
+		})
+	}()
+
+	conn, err := Connect(DefaultVersion, srrsConn, srrsConn)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	frameErrCh := make(chan error, 1)
+	go func() {
+		for err := range conn.Errors() {
+			if IsFrameError(err) {
+				frameErrCh <- err
+				continue
+			}
+			t.Errorf("SRRS error: %s", err)
+		}
+	}()
+
+	select {
+	case err := <-hsErrCh:
+		a.NoError(err)
+	case <-time.After(time.Second):
+		t.Fatal("Handshake response not received")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	a.NoError(conn.Ping(ctx))
+	a.NoError(conn.SetCommand(ctx, api.CommandStart))
+
+	ch, closeFn, err := conn.SubscribeStateChanges(ctx)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer closeFn()
+
+	a.NoError(trc.SendState(&api.State{
+		Command: api.CommandStop,
+	}))
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("No update received")
+	}
+	a.Equal(api.CommandStop, conn.State(ctx).Command)
+
+	// A malformed frame must be reported and skipped without tearing down the connection.
+	_, err = trcConn.Write([]byte{0, 0, 0, 1, 0xc1})
+	a.NoError(err)
+	select {
+	case <-frameErrCh:
+	case <-time.After(time.Second):
+		t.Fatal("Malformed frame not reported")
+	}
+	a.NoError(conn.Ping(ctx))
+}
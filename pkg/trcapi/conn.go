@@ -2,6 +2,7 @@
 package trcapi
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rvolosatovs/turtlitto/pkg/api"
 	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/recording"
 	"go.uber.org/zap"
 )
 
@@ -23,24 +25,19 @@ var DefaultVersion = semver.MustParse("1.0.0")
 // ErrClosed represents an error, which occurs when the *Conn is closed.
 var ErrClosed = errors.New("Conn is closed")
 
-// encoder encodes values.
-type encoder interface {
-	Encode(v interface{}) (err error)
-}
-
-// decoder decodes values.
-type decoder interface {
-	Decode(v interface{}) (err error)
-}
-
 // Conn is a connection to TRC.
 // Conn is safe for concurrent use by multiple goroutines.
 type Conn struct {
 	version semver.Version
 	token   *atomic.Value
 
-	decoder decoder
-	encoder encoder
+	decoder   Decoder
+	encoder   Encoder
+	recorder  *recording.Writer
+	encodings []api.Encoding
+	roster    []string
+	// field are the field dimensions positions sent to TRC are validated against.
+	field api.FieldDimensions
 
 	closeChMu *sync.RWMutex
 	closeCh   chan struct{}
@@ -54,42 +51,103 @@ type Conn struct {
 	stateSubsMu *sync.RWMutex
 	stateSubs   map[chan<- struct{}]struct{}
 
+	tokenSubsMu *sync.RWMutex
+	tokenSubs   map[chan<- struct{}]struct{}
+
 	pendingReqsMu *sync.RWMutex
 	pendingReqs   map[ulid.ULID]chan *api.Message
 }
 
+// Option represents a Conn option.
+type Option func(*Conn)
+
+// WithRecorder records all messages exchanged on Conn using rec.
+func WithRecorder(rec *recording.Writer) Option {
+	return func(c *Conn) {
+		c.recorder = rec
+	}
+}
+
+// WithRoster sets the IDs of turtles initially controlled by TRC.
+// By default, api.DefaultRoster is used.
+func WithRoster(ids ...string) Option {
+	return func(c *Conn) {
+		c.roster = ids
+	}
+}
+
+// WithFieldDimensions sets the field dimensions positions sent to TRC are validated against.
+// By default, api.DefaultFieldDimensions are used.
+func WithFieldDimensions(d api.FieldDimensions) Option {
+	return func(c *Conn) {
+		c.field = d
+	}
+}
+
+// WithEncodings sets the encodings, which may be negotiated during the handshake.
+// The first encoding offered by TRC, which is contained in encs, is chosen.
+// JSON is used if there is no such encoding.
+// By default, SupportedEncodings are used.
+func WithEncodings(encs ...api.Encoding) Option {
+	return func(c *Conn) {
+		c.encodings = encs
+	}
+}
+
+// setCodec sets the encoder and decoder used by c.
+func (c *Conn) setCodec(enc Encoder, dec Decoder) {
+	if c.recorder != nil {
+		enc = c.recorder.WrapEncoder(enc, recording.DirectionToTRC)
+		dec = c.recorder.WrapDecoder(dec, recording.DirectionToSRRS)
+	}
+	c.encoder = enc
+	c.decoder = dec
+}
+
+// decodePayload decodes the message payload pld into v.
+// Fields unknown to the protocol are rejected, like in the message itself.
+func decodePayload(pld json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(pld))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
 // Connect establishes the SRRS-side connection according to TRC API protocol
 // specification of version ver.
 // Messages are written to w and read from r.
-func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
+func Connect(ver semver.Version, w io.Writer, r io.Reader, opts ...Option) (*Conn, error) {
 	logger := zap.L()
 
 	dec := json.NewDecoder(r)
 	dec.DisallowUnknownFields()
 	conn := &Conn{
-		version:   ver,
-		token:     &atomic.Value{},
-		closeChMu: &sync.RWMutex{},
-		closeCh:   make(chan struct{}),
-		decoder:   dec,
-		encoder:   json.NewEncoder(w),
-		errCh:     make(chan error),
-		stateMu:   &sync.RWMutex{},
-		state: &api.State{
-			Turtles: map[string]*api.TurtleState{
-				"1": {},
-				"2": {},
-				"3": {},
-				"4": {},
-				"5": {},
-				"6": {},
-			},
-		},
+		version:       ver,
+		token:         &atomic.Value{},
+		closeChMu:     &sync.RWMutex{},
+		closeCh:       make(chan struct{}),
+		encodings:     SupportedEncodings,
+		errCh:         make(chan error),
+		roster:        api.DefaultRoster,
+		field:         api.DefaultFieldDimensions(),
+		stateMu:       &sync.RWMutex{},
 		stateSubsMu:   &sync.RWMutex{},
 		stateSubs:     make(map[chan<- struct{}]struct{}),
+		tokenSubsMu:   &sync.RWMutex{},
+		tokenSubs:     make(map[chan<- struct{}]struct{}),
 		pendingReqsMu: &sync.RWMutex{},
 		pendingReqs:   make(map[ulid.ULID]chan *api.Message),
 	}
+	for _, opt := range opts {
+		opt(conn)
+	}
+	conn.setCodec(json.NewEncoder(w), dec)
+
+	conn.state = &api.State{
+		Turtles: make(map[string]*api.TurtleState, len(conn.roster)),
+	}
+	for _, id := range conn.roster {
+		conn.state.Turtles[id] = &api.TurtleState{}
+	}
 
 	var req api.Message
 	if err := conn.decoder.Decode(&req); err != nil {
@@ -113,7 +171,7 @@ func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
 
 	logger.Debug("Decoding handshake payload...")
 	var hs api.Handshake
-	if err := json.Unmarshal(req.Payload, &hs); err != nil {
+	if err := decodePayload(req.Payload, &hs); err != nil {
 		return nil, errors.Wrap(err, "failed to decode handshake")
 	}
 	logger.Debug("Handshake payload decoded successfully",
@@ -131,6 +189,10 @@ func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
 	}
 	conn.version = resp.Version
 
+	if len(hs.Encodings) > 0 {
+		resp.Encoding = NegotiateEncoding(hs.Encodings, conn.encodings)
+	}
+
 	logger.Debug("Updating token...")
 	conn.token.Store(hs.Token)
 
@@ -146,6 +208,22 @@ func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
 		return nil, err
 	}
 
+	if resp.Encoding != "" && resp.Encoding != api.EncodingJSON {
+		logger.Debug("Switching encoding...",
+			zap.String("encoding", string(resp.Encoding)),
+		)
+
+		frameEnc, err := NewEncoder(w, resp.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		frameDec, err := NewDecoder(RemainingReader(dec, r), resp.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		conn.setCodec(frameEnc, frameDec)
+	}
+
 	go func() {
 		for {
 			var msg api.Message
@@ -164,6 +242,10 @@ func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
 				return
 			default:
 			}
+			if IsFrameError(err) {
+				conn.errCh <- errors.Wrap(err, "skipped malformed message")
+				continue
+			}
 			if err != nil {
 				conn.errCh <- errors.Wrap(err, "failed to decode incoming message")
 				return
@@ -187,12 +269,19 @@ func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
 			case api.MessageTypeState:
 				conn.stateMu.Lock()
 				st := deepcopy.Copy(conn.state).(*api.State)
-				if err := json.Unmarshal(msg.Payload, st); err != nil {
+				if err := decodePayload(msg.Payload, st); err != nil {
 					conn.stateMu.Unlock()
 					conn.errCh <- errors.Wrap(err, "failed to decode state message payload")
 					continue
 				}
 
+				for id, ts := range st.Turtles {
+					if ts == nil {
+						logger.Debug("Turtle left", zap.String("turtle", id))
+						delete(st.Turtles, id)
+					}
+				}
+
 				logger.Debug("Received state update", zap.Reflect("state", st))
 
 				conn.state = st
@@ -209,6 +298,42 @@ func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
 				}
 				conn.stateSubsMu.RUnlock()
 
+			case api.MessageTypeToken:
+				if msg.ParentID != nil {
+					logger.Error("Received token response")
+					conn.errCh <- errors.New("SRRS should not receive token responses")
+					return
+				}
+
+				var upd api.TokenUpdate
+				if err := decodePayload(msg.Payload, &upd); err != nil {
+					conn.errCh <- errors.Wrap(err, "failed to decode token message payload")
+					continue
+				}
+				if err := upd.Validate(); err != nil {
+					conn.errCh <- errors.Wrap(err, "invalid token message payload")
+					continue
+				}
+
+				logger.Debug("Updating token...")
+				conn.token.Store(upd.Token)
+
+				if err := conn.encoder.Encode(api.NewMessage(api.MessageTypeToken, nil, &msg.MessageID)); err != nil {
+					conn.errCh <- errors.Wrap(err, "failed to encode token message")
+					continue
+				}
+
+				conn.tokenSubsMu.RLock()
+				for ch := range conn.tokenSubs {
+					select {
+					case ch <- struct{}{}:
+						logger.Debug("Sending token update notification...")
+					default:
+						logger.Debug("Skipping token update...")
+					}
+				}
+				conn.tokenSubsMu.RUnlock()
+
 			default:
 				logger.Error("Received message of unmatched type")
 				conn.errCh <- errors.Errorf("unmatched message type: %s", msg.Type)
@@ -246,9 +371,15 @@ func (c *Conn) sendRequest(ctx context.Context, typ api.MessageType, pld interfa
 	default:
 	}
 
-	v, ok := pld.(api.Validator)
-	if ok && v != nil && v.Validate() != nil {
-		return nil, errors.Wrap(v.Validate(), "payload is invalid")
+	var err error
+	switch v := pld.(type) {
+	case api.FieldValidator:
+		err = v.ValidateOn(c.field)
+	case api.Validator:
+		err = v.Validate()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "payload is invalid")
 	}
 
 	b, err := json.Marshal(pld)
@@ -305,6 +436,13 @@ func (c *Conn) Close() error {
 		close(ch)
 	}
 	c.stateSubsMu.Unlock()
+
+	c.tokenSubsMu.Lock()
+	for ch := range c.tokenSubs {
+		delete(c.tokenSubs, ch)
+		close(ch)
+	}
+	c.tokenSubsMu.Unlock()
 	return nil
 }
 
@@ -338,8 +476,55 @@ func (c *Conn) SubscribeStateChanges(ctx context.Context) (<-chan struct{}, func
 
 	return ch, func() {
 		c.stateSubsMu.Lock()
+		_, ok := c.stateSubs[ch]
 		delete(c.stateSubs, ch)
 		c.stateSubsMu.Unlock()
+		if !ok {
+			// The channel was already closed by Close.
+			return
+		}
+
+		for {
+			// Drain channel
+			select {
+			case <-ch:
+			default:
+				close(ch)
+				return
+			}
+		}
+	}, nil
+}
+
+// SubscribeTokenChanges opens a subscription to token changes.
+// SubscribeTokenChanges returns read-only channel, on which a value is sent
+// every time TRC updates the token and a function, which must be used to close the subscription.
+func (c *Conn) SubscribeTokenChanges(ctx context.Context) (<-chan struct{}, func(), error) {
+	c.closeChMu.RLock()
+	defer c.closeChMu.RUnlock()
+
+	select {
+	case <-c.closeCh:
+		return nil, nil, ErrClosed
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	default:
+	}
+
+	c.tokenSubsMu.Lock()
+	ch := make(chan struct{}, 1)
+	c.tokenSubs[ch] = struct{}{}
+	c.tokenSubsMu.Unlock()
+
+	return ch, func() {
+		c.tokenSubsMu.Lock()
+		_, ok := c.tokenSubs[ch]
+		delete(c.tokenSubs, ch)
+		c.tokenSubsMu.Unlock()
+		if !ok {
+			// The channel was already closed by Close.
+			return
+		}
 
 		for {
 			// Drain channel
@@ -0,0 +1,290 @@
+// Package recording implements recording and replaying of TRC protocol sessions.
+//
+// A recording is a gzip-compressed stream of newline-delimited JSON entries,
+// each holding a message, its direction and the time it was observed at.
+package recording
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"go.uber.org/zap"
+)
+
+// Direction is the direction of a recorded message.
+type Direction string
+
+const (
+	// DirectionToTRC represents messages sent by SRRS to TRC.
+	DirectionToTRC Direction = "trc"
+
+	// DirectionToSRRS represents messages sent by TRC to SRRS.
+	DirectionToSRRS Direction = "srrs"
+)
+
+// Entry is a recorded message.
+type Entry struct {
+	Time      time.Time    `json:"t"`
+	Direction Direction    `json:"d"`
+	Message   *api.Message `json:"m"`
+}
+
+// Encoder encodes values.
+type Encoder interface {
+	Encode(v interface{}) error
+}
+
+// Decoder decodes values.
+type Decoder interface {
+	Decode(v interface{}) error
+}
+
+// Writer records messages.
+// Writer is safe for concurrent use by multiple goroutines.
+type Writer struct {
+	mu  *sync.Mutex
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+// NewWriter returns a new Writer, which writes the recording to w.
+func NewWriter(w io.Writer) *Writer {
+	gz := gzip.NewWriter(w)
+	return &Writer{
+		mu:  &sync.Mutex{},
+		gz:  gz,
+		enc: json.NewEncoder(gz),
+	}
+}
+
+// Record records msg sent in direction dir.
+// Each entry is flushed to the underlying writer, so that the recording survives abrupt termination.
+func (w *Writer) Record(dir Direction, msg *api.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.enc.Encode(&Entry{
+		Time:      time.Now(),
+		Direction: dir,
+		Message:   msg,
+	}); err != nil {
+		return errors.Wrap(err, "failed to encode entry")
+	}
+	return w.gz.Flush()
+}
+
+// Close finishes the recording. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.gz.Close()
+}
+
+// recordingEncoder is an Encoder, which records encoded messages.
+type recordingEncoder struct {
+	Encoder
+	w   *Writer
+	dir Direction
+}
+
+// Encode implements Encoder.
+func (e *recordingEncoder) Encode(v interface{}) error {
+	if err := e.Encoder.Encode(v); err != nil {
+		return err
+	}
+	if msg, ok := v.(*api.Message); ok {
+		if err := e.w.Record(e.dir, msg); err != nil {
+			zap.L().Warn("Failed to record message", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// recordingDecoder is a Decoder, which records decoded messages.
+type recordingDecoder struct {
+	Decoder
+	w   *Writer
+	dir Direction
+}
+
+// Decode implements Decoder.
+func (d *recordingDecoder) Decode(v interface{}) error {
+	if err := d.Decoder.Decode(v); err != nil {
+		return err
+	}
+	if msg, ok := v.(*api.Message); ok {
+		if err := d.w.Record(d.dir, msg); err != nil {
+			zap.L().Warn("Failed to record message", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// WrapEncoder returns an Encoder, which encodes values using enc and records
+// the encoded messages as sent in direction dir.
+// Failure to record a message is logged, but does not fail the encoding.
+func (w *Writer) WrapEncoder(enc Encoder, dir Direction) Encoder {
+	return &recordingEncoder{
+		Encoder: enc,
+		w:       w,
+		dir:     dir,
+	}
+}
+
+// WrapDecoder returns a Decoder, which decodes values using dec and records
+// the decoded messages as sent in direction dir.
+// Failure to record a message is logged, but does not fail the decoding.
+func (w *Writer) WrapDecoder(dec Decoder, dir Direction) Decoder {
+	return &recordingDecoder{
+		Decoder: dec,
+		w:       w,
+		dir:     dir,
+	}
+}
+
+// Reader reads recorded entries.
+type Reader struct {
+	dec *json.Decoder
+}
+
+// NewReader returns a new Reader, which reads the recording from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open recording")
+	}
+	return &Reader{
+		dec: json.NewDecoder(gz),
+	}, nil
+}
+
+// Next returns the next recorded entry or io.EOF, if there are no more entries.
+func (r *Reader) Next() (*Entry, error) {
+	var e Entry
+	if err := r.dec.Decode(&e); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, errors.Wrap(err, "failed to decode entry")
+	}
+	return &e, nil
+}
+
+// ReadAll reads all entries of the recording in r.
+func ReadAll(r io.Reader) ([]*Entry, error) {
+	rd, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var es []*Entry
+	for {
+		e, err := rd.Next()
+		if err == io.EOF {
+			return es, nil
+		}
+		if err != nil {
+			return es, err
+		}
+		es = append(es, e)
+	}
+}
+
+// replayConfig is the configuration of Replay.
+type replayConfig struct {
+	speed     float64
+	stepCh    <-chan struct{}
+	direction Direction
+}
+
+// ReplayOption represents a Replay option.
+type ReplayOption func(*replayConfig)
+
+// WithSpeed sets the replay speed factor, 1 being the original speed.
+// Factor of 0 replays the messages without any delay.
+func WithSpeed(factor float64) ReplayOption {
+	return func(conf *replayConfig) {
+		conf.speed = factor
+	}
+}
+
+// WithStep enables step-by-step replay: a message is only replayed once a value is received on ch.
+// Timing of the original session is ignored in step-by-step mode.
+func WithStep(ch <-chan struct{}) ReplayOption {
+	return func(conf *replayConfig) {
+		conf.stepCh = ch
+	}
+}
+
+// WithDirection specifies the direction of messages to replay.
+// By default, messages sent in DirectionToSRRS are replayed.
+func WithDirection(dir Direction) ReplayOption {
+	return func(conf *replayConfig) {
+		conf.direction = dir
+	}
+}
+
+// Replay calls send for each entry in es, which matches the configured direction,
+// preserving the timing of the original session by default.
+func Replay(ctx context.Context, es []*Entry, send func(*Entry) error, opts ...ReplayOption) error {
+	conf := &replayConfig{
+		speed:     1,
+		direction: DirectionToSRRS,
+	}
+	for _, opt := range opts {
+		opt(conf)
+	}
+	if conf.speed < 0 {
+		return errors.Errorf("invalid replay speed: %f", conf.speed)
+	}
+	if len(es) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	first := es[0].Time
+	for i, e := range es {
+		if e.Direction != conf.direction {
+			continue
+		}
+
+		var wait <-chan time.Time
+		switch {
+		case conf.stepCh != nil:
+		case conf.speed == 0:
+		default:
+			at := start.Add(time.Duration(float64(e.Time.Sub(first)) / conf.speed))
+			wait = time.After(time.Until(at))
+		}
+
+		if conf.stepCh != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case _, ok := <-conf.stepCh:
+				if !ok {
+					return nil
+				}
+			}
+		}
+
+		if wait != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-wait:
+			}
+		}
+
+		if err := send(e); err != nil {
+			return errors.Wrapf(err, "failed to replay entry %d", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package recording_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	. "github.com/rvolosatovs/turtlitto/pkg/trcapi/recording"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Record(), ReadAll(), Replay() in recording.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestRecordReplay(t *testing.T) {
+	a := assert.New(t)
+
+	g := apitest.NewTestGenerator(t)
+	msgs := []*api.Message{
+		g.Message(),
+		g.Message(),
+		g.Message(),
+		g.Message(),
+	}
+	dirs := []Direction{DirectionToSRRS, DirectionToTRC, DirectionToSRRS, DirectionToSRRS}
+
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	for i, msg := range msgs {
+		a.NoError(w.Record(dirs[i], msg))
+	}
+	a.NoError(w.Close())
+
+	es, err := ReadAll(buf)
+	a.NoError(err)
+	if !a.Len(es, len(msgs)) {
+		t.FailNow()
+	}
+	for i, e := range es {
+		a.Equal(dirs[i], e.Direction)
+		a.Equal(msgs[i].MessageID, e.Message.MessageID)
+		a.Equal(msgs[i].Type, e.Message.Type)
+		a.False(e.Time.IsZero())
+	}
+
+	t.Run("speed", func(t *testing.T) {
+		a := assert.New(t)
+
+		var sent []*api.Message
+		err := Replay(context.Background(), es, func(e *Entry) error {
+			sent = append(sent, e.Message)
+			return nil
+		}, WithSpeed(0))
+		a.NoError(err)
+		if a.Len(sent, 3) {
+			a.Equal(msgs[0].MessageID, sent[0].MessageID)
+			a.Equal(msgs[2].MessageID, sent[1].MessageID)
+			a.Equal(msgs[3].MessageID, sent[2].MessageID)
+		}
+	})
+
+	t.Run("direction", func(t *testing.T) {
+		a := assert.New(t)
+
+		var sent []*api.Message
+		err := Replay(context.Background(), es, func(e *Entry) error {
+			sent = append(sent, e.Message)
+			return nil
+		}, WithSpeed(0), WithDirection(DirectionToTRC))
+		a.NoError(err)
+		if a.Len(sent, 1) {
+			a.Equal(msgs[1].MessageID, sent[0].MessageID)
+		}
+	})
+
+	t.Run("step", func(t *testing.T) {
+		a := assert.New(t)
+
+		stepCh := make(chan struct{})
+		sentCh := make(chan *api.Message, len(msgs))
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- Replay(context.Background(), es, func(e *Entry) error {
+				sentCh <- e.Message
+				return nil
+			}, WithStep(stepCh))
+		}()
+
+		select {
+		case <-sentCh:
+			t.Fatal("Message replayed before step")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		for i := 0; i < 3; i++ {
+			stepCh <- struct{}{}
+			select {
+			case <-sentCh:
+			case <-time.After(time.Second):
+				t.Fatal("Message not replayed after step")
+			}
+		}
+		a.NoError(<-errCh)
+	})
+}
@@ -0,0 +1,90 @@
+package trcapi
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// poolNameRegexp matches valid Pool names.
+var poolNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Registry represents a registry of named Pool's, one per TRC.
+// Registry is safe for concurrent use by multiple goroutines.
+type Registry struct {
+	poolsMu *sync.RWMutex
+	pools   map[string]*Pool
+}
+
+// NewRegistry returns a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		poolsMu: &sync.RWMutex{},
+		pools:   make(map[string]*Pool),
+	}
+}
+
+// AddPool adds p to the registry under name.
+// name must consist of alphanumeric characters, '-' or '_' and must not be registered yet.
+func (r *Registry) AddPool(name string, p *Pool) error {
+	if !poolNameRegexp.MatchString(name) {
+		return errors.Errorf("invalid TRC name: %q", name)
+	}
+	if p == nil {
+		return errors.New("pool is nil")
+	}
+
+	r.poolsMu.Lock()
+	defer r.poolsMu.Unlock()
+
+	if _, ok := r.pools[name]; ok {
+		return errors.Errorf("TRC %s is already registered", name)
+	}
+	r.pools[name] = p
+	return nil
+}
+
+// GetPool returns the Pool registered under name, if such exists.
+func (r *Registry) GetPool(name string) (*Pool, bool) {
+	r.poolsMu.RLock()
+	p, ok := r.pools[name]
+	r.poolsMu.RUnlock()
+	return p, ok
+}
+
+// ListPoolNames returns the sorted names of all registered Pool's.
+func (r *Registry) ListPoolNames() []string {
+	r.poolsMu.RLock()
+	names := make([]string, 0, len(r.pools))
+	for name := range r.pools {
+		names = append(names, name)
+	}
+	r.poolsMu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Close closes all registered Pool's.
+// All Pool's are closed even if closing some of them fails, in which case the error of the first one
+// in order of names is returned.
+func (r *Registry) Close() error {
+	r.poolsMu.RLock()
+	defer r.poolsMu.RUnlock()
+
+	names := make([]string, 0, len(r.pools))
+	for name := range r.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var err error
+	for _, name := range names {
+		if cerr := r.pools[name].Close(); cerr != nil && err == nil {
+			err = errors.Wrapf(cerr, "failed to close pool %s", name)
+		}
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package trcapi_test
+
+import (
+	"testing"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: AddPool(), GetPool(), ListPoolNames() in registry.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestRegistry(t *testing.T) {
+	a := assert.New(t)
+
+	reg := NewRegistry()
+	a.Empty(reg.ListPoolNames())
+
+	magenta := NewPool(nil)
+	cyan := NewPool(nil)
+
+	a.NoError(reg.AddPool("magenta", magenta))
+	a.NoError(reg.AddPool("cyan", cyan))
+
+	a.Error(reg.AddPool("cyan", cyan), "duplicate name")
+	a.Error(reg.AddPool("", cyan), "empty name")
+	a.Error(reg.AddPool("a/b", cyan), "name with a slash")
+	a.Error(reg.AddPool("yellow", nil), "nil pool")
+
+	a.Equal([]string{"cyan", "magenta"}, reg.ListPoolNames())
+
+	p, ok := reg.GetPool("magenta")
+	a.True(ok)
+	a.True(p == magenta)
+
+	p, ok = reg.GetPool("yellow")
+	a.False(ok)
+	a.Nil(p)
+
+	a.NoError(reg.Close())
+}
@@ -0,0 +1,26 @@
+package trcapi
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseRoster parses a comma-separated list of turtle IDs.
+// IDs must be non-empty and unique.
+func ParseRoster(s string) ([]string, error) {
+	ids := strings.Split(s, ",")
+	seen := make(map[string]struct{}, len(ids))
+	for i, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			return nil, errors.Errorf("turtle ID at position %d is empty", i)
+		}
+		if _, ok := seen[id]; ok {
+			return nil, errors.Errorf("turtle ID %s is specified more than once", id)
+		}
+		seen[id] = struct{}{}
+		ids[i] = id
+	}
+	return ids, nil
+}
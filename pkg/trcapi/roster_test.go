@@ -0,0 +1,106 @@
+package trcapi_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	. "github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: ParseRoster() in roster.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestParseRoster(t *testing.T) {
+	a := assert.New(t)
+
+	ids, err := ParseRoster("1, 2,keeper")
+	a.NoError(err)
+	a.Equal([]string{"1", "2", "keeper"}, ids)
+
+	_, err = ParseRoster("")
+	a.Error(err)
+
+	_, err = ParseRoster("1,,2")
+	a.Error(err)
+
+	_, err = ParseRoster("1,2,1")
+	a.Error(err)
+}
+
+//Test_items: WithRoster(), Connect(), State() in conn.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestRosterChange(t *testing.T) {
+	a := assert.New(t)
+
+	srrsConn, trcConn := net.Pipe()
+
+	trc := trctest.Connect(trcConn, trcConn,
+		trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+	)
+	defer trc.Close()
+
+	go func() {
+		for err := range trc.Errors() {
+			t.Errorf("TRC error: %s", err)
+		}
+	}()
+
+	go func() {
+		a.NoError(trc.SendHandshake(&api.Handshake{Version: DefaultVersion}))
+	}()
+
+	conn, err := Connect(DefaultVersion, srrsConn, srrsConn, WithRoster("3", "5"))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	go func() {
+		for err := range conn.Errors() {
+			t.Errorf("SRRS error: %s", err)
+		}
+	}()
+
+	ctx := context.Background()
+
+	a.Equal(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"3": {},
+			"5": {},
+		},
+	}, conn.State(ctx))
+
+	ch, closeFn, err := conn.SubscribeStateChanges(ctx)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer closeFn()
+
+	a.NoError(trc.SendState(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"3": nil,
+			"8": {HomeGoal: api.HomeGoalBlue},
+		},
+	}))
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("No update received")
+	}
+
+	a.Equal(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"5": {},
+			"8": {HomeGoal: api.HomeGoalBlue},
+		},
+	}, conn.State(ctx))
+}
@@ -7,7 +7,7 @@ import (
 	"github.com/pkg/errors"
 )
 
-// Token returns the token received from TRC during the handshake procedure or error,
+// Token returns the token received from TRC during the handshake procedure or in the latest token message or error,
 // if it did not happen yet.
 func (c *Conn) Token() (string, error) {
 	v := c.token.Load()
@@ -0,0 +1,127 @@
+// +build !noauth
+
+package trcapi_test
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	. "github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Connect(), SubscribeTokenChanges() in conn.go, Token() in token_auth.go, TokenUpdate.Validate() in validate.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestToken(t *testing.T) {
+	a := assert.New(t)
+
+	srrsIn, trcOut := io.Pipe()
+	trcIn, srrsOut := io.Pipe()
+
+	ackCh := make(chan *api.Message, 1)
+	trc := trctest.Connect(trcOut, trcIn,
+		trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+		trctest.WithHandler(api.MessageTypeToken, func(msg *api.Message) (*api.Message, error) {
+			ackCh <- msg
+			return trctest.DefaultTokenHandler(msg)
+		}),
+	)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(3)
+
+	go func() {
+		defer wg.Done()
+
+		for err := range trc.Errors() {
+			panic(errors.Wrap(err, "TRC error"))
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		err := trc.SendHandshake(&api.Handshake{Version: DefaultVersion, Token: "old"})
+		a.Nil(err)
+	}()
+
+	conn, err := Connect(DefaultVersion, srrsOut, srrsIn)
+	if !a.Nil(err) {
+		t.FailNow()
+	}
+
+	srrsErrCh := make(chan error, 1)
+	go func() {
+		defer wg.Done()
+
+		for err := range conn.Errors() {
+			srrsErrCh <- err
+		}
+	}()
+
+	tok, err := conn.Token()
+	a.NoError(err)
+	a.Equal("old", tok)
+
+	ch, closeFn, err := conn.SubscribeTokenChanges(context.Background())
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	a.NoError(trc.SendToken("new"))
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("No update received")
+	}
+
+	tok, err = conn.Token()
+	a.NoError(err)
+	a.Equal("new", tok)
+
+	select {
+	case msg := <-ackCh:
+		a.NotNil(msg.ParentID)
+	case <-time.After(time.Second):
+		t.Fatal("No acknowledgement received")
+	}
+
+	a.NoError(trc.SendToken(""))
+
+	select {
+	case err := <-srrsErrCh:
+		a.Contains(err.Error(), "invalid token")
+	case <-time.After(time.Second):
+		t.Fatal("Empty token accepted")
+	}
+
+	tok, err = conn.Token()
+	a.NoError(err)
+	a.Equal("new", tok)
+
+	select {
+	case <-ch:
+		t.Error("Update received for empty token")
+	case <-ackCh:
+		t.Error("Empty token acknowledged")
+	default:
+	}
+
+	a.NotPanics(func() { closeFn() })
+
+	a.NoError(conn.Close())
+	a.NoError(trc.Close())
+	a.NoError(trcIn.Close())
+	a.NoError(srrsIn.Close())
+
+	wg.Wait()
+}
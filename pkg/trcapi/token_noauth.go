@@ -5,7 +5,7 @@ package trcapi
 
 import "go.uber.org/zap"
 
-// Token returns the token received from TRC during the handshake procedure or error,
+// Token returns the token received from TRC during the handshake procedure or in the latest token message or error,
 // if it did not happen yet.
 func (c *Conn) Token() (string, error) {
 	zap.L().Warn("Bypassing TRC authentication")
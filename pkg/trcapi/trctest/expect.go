@@ -0,0 +1,426 @@
+package trctest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+// DefaultExpectWithin is the time an expectation may take to be met, unless specified otherwise.
+const DefaultExpectWithin = time.Second
+
+// Expectation describes a message SRRS is expected to send.
+type Expectation struct {
+	typ    api.MessageType
+	within time.Duration
+	pred   func(*api.Message) bool
+	desc   string
+	state  *api.State
+}
+
+// ExpectOption represents an Expectation option.
+type ExpectOption func(*Expectation)
+
+// Matching requires the message to satisfy pred, which is described by desc in failures.
+func Matching(desc string, pred func(*api.Message) bool) ExpectOption {
+	return func(e *Expectation) {
+		e.desc = desc
+		e.pred = pred
+	}
+}
+
+// WithState requires the message to be a state message, which has all fields set in st set to the same values.
+// Fields not set in st may have any value.
+func WithState(st *api.State) ExpectOption {
+	return func(e *Expectation) {
+		e.state = st
+	}
+}
+
+// Within sets the time the expectation may take to be met.
+func Within(d time.Duration) ExpectOption {
+	return func(e *Expectation) {
+		e.within = d
+	}
+}
+
+// Expect returns an expectation of a message of type typ configured by opts.
+func Expect(typ api.MessageType, opts ...ExpectOption) *Expectation {
+	e := &Expectation{
+		typ:    typ,
+		within: DefaultExpectWithin,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// String returns a description of e.
+func (e *Expectation) String() string {
+	s := fmt.Sprintf("%s message", e.typ)
+	if e.desc != "" {
+		s += " " + e.desc
+	}
+	if e.state != nil {
+		b, err := json.Marshal(e.state)
+		if err != nil {
+			panic(errors.Wrap(err, "failed to encode expected state"))
+		}
+		s += " with state " + string(b)
+	}
+	return fmt.Sprintf("%s within %s", s, e.within)
+}
+
+// mismatches returns the reasons msg does not meet e or nil, if it does.
+func (e *Expectation) mismatches(msg *api.Message) []string {
+	if msg.Type != e.typ {
+		return []string{fmt.Sprintf("type: want %s, got %s", e.typ, msg.Type)}
+	}
+
+	var diffs []string
+	if e.pred != nil && !e.pred(msg) {
+		diffs = append(diffs, "predicate not satisfied")
+	}
+	if e.state == nil {
+		return diffs
+	}
+
+	want, err := toGeneric(e.state)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to encode expected state"))
+	}
+	var got interface{}
+	if err := json.Unmarshal(msg.Payload, &got); err != nil {
+		return append(diffs, fmt.Sprintf("payload: %s", err))
+	}
+	return append(diffs, diffSubset("", want, got)...)
+}
+
+// toGeneric returns the JSON encoding of v decoded into generic values.
+func toGeneric(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var g interface{}
+	return g, json.Unmarshal(b, &g)
+}
+
+// diffSubset returns the differences of got from want at path, where fields not present in want are ignored.
+func diffSubset(path string, want, got interface{}) []string {
+	wm, ok := want.(map[string]interface{})
+	if !ok {
+		if reflect.DeepEqual(want, got) {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: want %s, got %s", displayPath(path), display(want), display(got))}
+	}
+
+	gm, ok := got.(map[string]interface{})
+	if !ok {
+		return []string{fmt.Sprintf("%s: want an object, got %s", displayPath(path), display(got))}
+	}
+
+	keys := make([]string, 0, len(wm))
+	for k := range wm {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var diffs []string
+	for _, k := range keys {
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+
+		gv, ok := gm[k]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: want %s, got nothing", p, display(wm[k])))
+			continue
+		}
+		diffs = append(diffs, diffSubset(p, wm[k], gv)...)
+	}
+	return diffs
+}
+
+// displayPath returns path suitable for display.
+func displayPath(path string) string {
+	if path == "" {
+		return "payload"
+	}
+	return path
+}
+
+// display returns the JSON encoding of v.
+func display(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// Watcher records the messages received from SRRS and checks expectations against them.
+// Watcher is safe for concurrent use by multiple goroutines.
+type Watcher struct {
+	conn *Conn
+
+	mu       sync.Mutex
+	received []*api.Message
+	// consumed holds the indexes of messages in received, which met an expectation.
+	consumed map[int]bool
+	// next is the index in received, from which in-order expectations are checked.
+	next int
+	// receivedCh is closed and replaced every time a message is received.
+	receivedCh chan struct{}
+}
+
+// Watch starts recording the messages received from SRRS and returns a *Watcher checking expectations against them.
+// Messages received before Watch is called are not recorded.
+// Close must be called once the Watcher is not needed anymore.
+func (c *Conn) Watch() *Watcher {
+	w := &Watcher{
+		conn:       c,
+		consumed:   make(map[int]bool),
+		receivedCh: make(chan struct{}),
+	}
+	c.watchersMu.Lock()
+	c.watchers[w] = struct{}{}
+	c.watchersMu.Unlock()
+	return w
+}
+
+// notifyWatchers records msg in all watchers of c.
+func (c *Conn) notifyWatchers(msg *api.Message) {
+	c.watchersMu.RLock()
+	defer c.watchersMu.RUnlock()
+
+	for w := range c.watchers {
+		w.mu.Lock()
+		w.received = append(w.received, msg)
+		close(w.receivedCh)
+		w.receivedCh = make(chan struct{})
+		w.mu.Unlock()
+	}
+}
+
+// Close stops recording messages.
+func (w *Watcher) Close() {
+	w.conn.watchersMu.Lock()
+	delete(w.conn.watchers, w)
+	w.conn.watchersMu.Unlock()
+}
+
+// Received returns the messages recorded so far in order of reception.
+func (w *Watcher) Received() []*api.Message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]*api.Message{}, w.received...)
+}
+
+// find returns the index of the first message in received at or after from, which is not consumed and meets e,
+// or -1, if there is no such message.
+// w.mu must be held by the caller.
+func (w *Watcher) find(e *Expectation, from int) int {
+	for i := from; i < len(w.received); i++ {
+		if !w.consumed[i] && e.mismatches(w.received[i]) == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// failure returns the error describing that e was not met, where candidates are the indexes of messages,
+// which were considered.
+// w.mu must be held by the caller.
+func (w *Watcher) failure(e *Expectation, candidates []int) error {
+	var (
+		closest []string
+		count   int
+	)
+	for _, i := range candidates {
+		if w.consumed[i] || w.received[i].Type != e.typ {
+			continue
+		}
+		count++
+
+		diffs := e.mismatches(w.received[i])
+		if closest == nil || len(diffs) < len(closest) {
+			closest = diffs
+		}
+	}
+
+	msg := fmt.Sprintf("expected %s", e)
+	if count == 0 {
+		return errors.Errorf("%s, but received no %s messages", msg, e.typ)
+	}
+	return errors.Errorf("%s, but none of %d %s messages received matched, closest differs in:\n\t%s",
+		msg, count, e.typ, strings.Join(closest, "\n\t"))
+}
+
+// wait waits until either ch is closed, i.e. a message is received, or deadline passes.
+func wait(ch <-chan struct{}, deadline time.Time) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+	case <-timer.C:
+	}
+}
+
+// ExpectInOrder waits for messages meeting es to be received in order and returns them.
+// Each expectation must be met within its duration after the previous one was met or, for the first one,
+// after ExpectInOrder was called. Messages in between, which do not meet the expectations, are ignored.
+// Messages returned are not considered by subsequent expectations.
+func (w *Watcher) ExpectInOrder(es ...*Expectation) ([]*api.Message, error) {
+	msgs := make([]*api.Message, 0, len(es))
+	for n, e := range es {
+		deadline := time.Now().Add(e.within)
+		for {
+			w.mu.Lock()
+			from := w.next
+			if i := w.find(e, from); i >= 0 {
+				w.consumed[i] = true
+				w.next = i + 1
+				msgs = append(msgs, w.received[i])
+				w.mu.Unlock()
+				break
+			}
+			if time.Now().After(deadline) {
+				candidates := make([]int, 0, len(w.received)-from)
+				for i := from; i < len(w.received); i++ {
+					candidates = append(candidates, i)
+				}
+				err := w.failure(e, candidates)
+				w.mu.Unlock()
+				return msgs, errors.Wrapf(err, "expectation %d of %d not met", n+1, len(es))
+			}
+			ch := w.receivedCh
+			w.mu.Unlock()
+
+			wait(ch, deadline)
+		}
+	}
+	return msgs, nil
+}
+
+// match returns the index of the message in received, which is matched to each expectation in es,
+// or -1 if there is none. Messages, which are not consumed, are matched, such that each message meets
+// at most one expectation and as many expectations as possible are met.
+// Expectations are matched in order of order and an expectation is never left unmatched
+// in favor of one following it in order.
+func (w *Watcher) match(es []*Expectation, order []int) []int {
+	meets := make([][]int, len(es))
+	for n, e := range es {
+		for i := range w.received {
+			if !w.consumed[i] && e.mismatches(w.received[i]) == nil {
+				meets[n] = append(meets[n], i)
+			}
+		}
+	}
+
+	matched := make([]int, len(es))
+	for n := range matched {
+		matched[n] = -1
+	}
+	owners := make(map[int]int, len(es))
+
+	// augment looks for an augmenting path starting at expectation n and applies it, if found.
+	var augment func(n int, visited map[int]bool) bool
+	augment = func(n int, visited map[int]bool) bool {
+		for _, i := range meets[n] {
+			if visited[i] {
+				continue
+			}
+			visited[i] = true
+
+			if owner, ok := owners[i]; ok && !augment(owner, visited) {
+				continue
+			}
+			owners[i] = n
+			matched[n] = i
+			return true
+		}
+		return false
+	}
+	for _, n := range order {
+		augment(n, map[int]bool{})
+	}
+	return matched
+}
+
+// ExpectUnordered waits for messages meeting es to be received in any order and returns them
+// in order of es. Each expectation must be met within its duration after ExpectUnordered was called.
+// Each message meets at most one expectation and messages are assigned to expectations, such that
+// as many expectations as possible are met. Messages returned are not considered by subsequent expectations.
+func (w *Watcher) ExpectUnordered(es ...*Expectation) ([]*api.Message, error) {
+	start := time.Now()
+
+	// Expectations expiring earlier take precedence, if not all expectations can be met.
+	order := make([]int, len(es))
+	for n := range order {
+		order[n] = n
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return es[order[i]].within < es[order[j]].within
+	})
+
+	for {
+		w.mu.Lock()
+		matched := w.match(es, order)
+
+		var (
+			errs     []string
+			deadline time.Time
+		)
+		for n, e := range es {
+			if matched[n] >= 0 {
+				continue
+			}
+			d := start.Add(e.within)
+			if time.Now().After(d) {
+				candidates := make([]int, len(w.received))
+				for i := range candidates {
+					candidates[i] = i
+				}
+				errs = append(errs, errors.Wrapf(w.failure(e, candidates), "expectation %d of %d not met", n+1, len(es)).Error())
+				continue
+			}
+			if deadline.IsZero() || d.Before(deadline) {
+				deadline = d
+			}
+		}
+
+		if len(errs) == 0 && !deadline.IsZero() {
+			ch := w.receivedCh
+			w.mu.Unlock()
+
+			wait(ch, deadline)
+			continue
+		}
+
+		msgs := make([]*api.Message, len(es))
+		for n, i := range matched {
+			if i >= 0 {
+				w.consumed[i] = true
+				msgs[n] = w.received[i]
+			}
+		}
+		w.mu.Unlock()
+
+		if len(errs) > 0 {
+			return msgs, errors.New(strings.Join(errs, "\n"))
+		}
+		return msgs, nil
+	}
+}
@@ -0,0 +1,132 @@
+package trctest_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	. "github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Watch(), Expect(), ExpectInOrder(), ExpectUnordered() in expect.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestExpect(t *testing.T) {
+	a := assert.New(t)
+
+	env := newFaultTestEnv(WithHandler(api.MessageTypeState, func(*api.Message) (*api.Message, error) {
+		return nil, nil
+	}))
+	w := env.conn.Watch()
+	defer w.Close()
+
+	sendState := func(st *api.State) {
+		b, err := json.Marshal(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Nil(env.out.Encode(api.NewMessage(api.MessageTypeState, b, nil)))
+	}
+
+	go func() {
+		sendState(&api.State{Command: api.CommandStop})
+		time.Sleep(10 * time.Millisecond)
+		sendState(&api.State{Command: api.CommandStart})
+	}()
+	msgs, err := w.ExpectInOrder(
+		Expect(api.MessageTypeState, WithState(&api.State{Command: api.CommandStop})),
+		Expect(api.MessageTypeState, Matching("starting the game", func(msg *api.Message) bool {
+			var st api.State
+			return json.Unmarshal(msg.Payload, &st) == nil && st.Command == api.CommandStart
+		})),
+	)
+	a.Nil(err)
+	a.Len(msgs, 2)
+
+	sendState(&api.State{Command: api.CommandGoIn})
+	sendState(&api.State{Command: api.CommandGoOut})
+	msgs, err = w.ExpectUnordered(
+		Expect(api.MessageTypeState, WithState(&api.State{Command: api.CommandGoOut})),
+		Expect(api.MessageTypeState, WithState(&api.State{Command: api.CommandGoIn})),
+	)
+	a.Nil(err)
+	if a.Len(msgs, 2) {
+		a.Contains(string(msgs[0].Payload), string(api.CommandGoOut))
+		a.Contains(string(msgs[1].Payload), string(api.CommandGoIn))
+	}
+
+	sendState(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"1": {BatteryVoltage: apitest.Uint8Ptr(20)},
+		},
+	})
+	_, err = w.ExpectInOrder(Expect(api.MessageTypeState, Within(50*time.Millisecond), WithState(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"1": {BatteryVoltage: apitest.Uint8Ptr(21)},
+		},
+	})))
+	if a.Error(err) {
+		a.Contains(err.Error(), "expectation 1 of 1 not met")
+		a.Contains(err.Error(), "turtles.1.batteryvoltage: want 21, got 20")
+	}
+
+	_, err = w.ExpectUnordered(Expect(api.MessageTypePing, Within(50*time.Millisecond)))
+	if a.Error(err) {
+		a.Contains(err.Error(), "received no ping messages")
+	}
+}
+
+//Test_items: ExpectUnordered() in expect.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestExpectUnordered(t *testing.T) {
+	a := assert.New(t)
+
+	env := newFaultTestEnv(WithHandler(api.MessageTypeState, func(*api.Message) (*api.Message, error) {
+		return nil, nil
+	}))
+	w := env.conn.Watch()
+	defer w.Close()
+
+	sendState := func(st *api.State) {
+		b, err := json.Marshal(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Nil(env.out.Encode(api.NewMessage(api.MessageTypeState, b, nil)))
+	}
+
+	go func() {
+		sendState(&api.State{Command: api.CommandStop})
+		time.Sleep(10 * time.Millisecond)
+		sendState(&api.State{Command: api.CommandStart})
+	}()
+	msgs, err := w.ExpectUnordered(
+		Expect(api.MessageTypeState),
+		Expect(api.MessageTypeState, WithState(&api.State{Command: api.CommandStop})),
+	)
+	a.Nil(err)
+	if a.Len(msgs, 2) {
+		a.Contains(string(msgs[0].Payload), string(api.CommandStart))
+		a.Contains(string(msgs[1].Payload), string(api.CommandStop))
+	}
+
+	sendState(&api.State{Command: api.CommandGoIn})
+	msgs, err = w.ExpectUnordered(
+		Expect(api.MessageTypeState, Within(100*time.Millisecond)),
+		Expect(api.MessageTypeState, Within(50*time.Millisecond), WithState(&api.State{Command: api.CommandGoIn})),
+	)
+	if a.Error(err) {
+		a.Contains(err.Error(), "expectation 1 of 2 not met")
+		a.NotContains(err.Error(), "expectation 2 of 2 not met")
+	}
+	if a.Len(msgs, 2) {
+		a.Nil(msgs[0])
+		a.Contains(string(msgs[1].Payload), string(api.CommandGoIn))
+	}
+}
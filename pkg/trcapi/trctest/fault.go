@@ -0,0 +1,394 @@
+package trctest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	mrand "math/rand"
+	"sort"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"go.uber.org/zap"
+)
+
+// DefaultFaultDelay is the time responses are delayed by FaultDelay, unless specified otherwise.
+const DefaultFaultDelay = 2 * time.Second
+
+// Fault is a kind of misbehaviour, which may be injected into messages sent by Conn.
+type Fault string
+
+const (
+	// FaultDelay delays a response.
+	FaultDelay Fault = "delay"
+	// FaultDrop drops a response.
+	FaultDrop Fault = "drop"
+	// FaultDuplicate sends a message twice.
+	FaultDuplicate Fault = "duplicate"
+	// FaultWrongParent replaces the ParentID of a response by a random one.
+	FaultWrongParent Fault = "wrong_parent"
+	// FaultMalformed sends a message, which cannot be decoded.
+	FaultMalformed Fault = "malformed"
+	// FaultUnknownField adds a field unknown to the protocol to the payload of a message or,
+	// if the payload is not an object, to the message itself.
+	FaultUnknownField Fault = "unknown_field"
+	// FaultOutOfRange sets a turtle field of a state to a value out of the allowed range.
+	FaultOutOfRange Fault = "out_of_range"
+	// FaultAbruptClose closes the connection in the middle of sending a message.
+	FaultAbruptClose Fault = "abrupt_close"
+	// FaultVersionMismatch sends a handshake with an unsupported version.
+	FaultVersionMismatch Fault = "version_mismatch"
+)
+
+// Faults lists all faults in order of application.
+var Faults = []Fault{
+	FaultWrongParent,
+	FaultVersionMismatch,
+	FaultOutOfRange,
+	FaultUnknownField,
+	FaultDrop,
+	FaultAbruptClose,
+	FaultMalformed,
+	FaultDelay,
+	FaultDuplicate,
+}
+
+// ParseFault parses a fault from s.
+func ParseFault(s string) (Fault, error) {
+	for _, f := range Faults {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", errors.Errorf("unknown fault: %s", s)
+}
+
+// appliesTo reports whether f can be injected into msg.
+func (f Fault) appliesTo(msg *api.Message) bool {
+	switch f {
+	case FaultDelay, FaultDrop, FaultWrongParent:
+		return msg.ParentID != nil
+	case FaultOutOfRange:
+		return msg.Type == api.MessageTypeState
+	case FaultVersionMismatch:
+		return msg.Type == api.MessageTypeHandshake && msg.ParentID == nil
+	}
+	return true
+}
+
+// errClosedByFault is returned when the connection is closed by FaultAbruptClose.
+var errClosedByFault = errors.New("connection closed by injected fault")
+
+// WithFaultProbability injects f into each message, to which f applies, with probability p.
+func WithFaultProbability(f Fault, p float64) Option {
+	return func(c *Conn) {
+		c.faultProbabilities[f] = p
+	}
+}
+
+// WithFaultAt injects f into the first message, to which f applies, sent after each of the times ats
+// elapsed since Connect was called.
+func WithFaultAt(f Fault, ats ...time.Duration) Option {
+	return func(c *Conn) {
+		c.faultSchedule[f] = append(c.faultSchedule[f], ats...)
+	}
+}
+
+// WithFaultDelay sets the time responses are delayed by FaultDelay.
+func WithFaultDelay(d time.Duration) Option {
+	return func(c *Conn) {
+		c.faultDelay = d
+	}
+}
+
+// WithFaultSeed seeds the random number generator used for fault injection.
+// Using the same seed and the same sequence of messages, the same faults are injected.
+func WithFaultSeed(seed int64) Option {
+	return func(c *Conn) {
+		c.faultRand = mrand.New(mrand.NewSource(seed))
+	}
+}
+
+// InjectFault injects f into the next message, to which f applies.
+func (c *Conn) InjectFault(f Fault) {
+	c.faultMu.Lock()
+	c.faultsArmed[f]++
+	c.faultMu.Unlock()
+}
+
+// scheduleFaults arms the faults scheduled by WithFaultAt at their respective times.
+func (c *Conn) scheduleFaults() {
+	c.faultMu.Lock()
+	defer c.faultMu.Unlock()
+
+	for f, ats := range c.faultSchedule {
+		for _, at := range ats {
+			f := f
+			c.faultTimers = append(c.faultTimers, time.AfterFunc(at, func() {
+				c.InjectFault(f)
+			}))
+		}
+	}
+}
+
+// stopFaults stops the timers started by scheduleFaults.
+func (c *Conn) stopFaults() {
+	c.faultMu.Lock()
+	defer c.faultMu.Unlock()
+
+	for _, t := range c.faultTimers {
+		t.Stop()
+	}
+}
+
+// faultsFor returns the set of faults to inject into msg.
+func (c *Conn) faultsFor(msg *api.Message) map[Fault]bool {
+	c.faultMu.Lock()
+	defer c.faultMu.Unlock()
+
+	var fs map[Fault]bool
+	for _, f := range Faults {
+		if !f.appliesTo(msg) {
+			continue
+		}
+
+		inject := false
+		if c.faultsArmed[f] > 0 {
+			c.faultsArmed[f]--
+			inject = true
+		} else if p := c.faultProbabilities[f]; p > 0 && c.faultRand.Float64() < p {
+			inject = true
+		}
+		if !inject {
+			continue
+		}
+		if fs == nil {
+			fs = make(map[Fault]bool)
+		}
+		fs[f] = true
+	}
+	return fs
+}
+
+// send sends msg to SRRS injecting faults, if any.
+func (c *Conn) send(msg *api.Message) error {
+	fs := c.faultsFor(msg)
+	if len(fs) == 0 {
+		return c.encode(msg)
+	}
+
+	logger := zap.L().With(
+		zap.Reflect("msg", msg),
+		zap.Reflect("faults", fs),
+	)
+	logger.Debug("Injecting faults...")
+
+	msg = &api.Message{
+		Type:      msg.Type,
+		MessageID: msg.MessageID,
+		ParentID:  msg.ParentID,
+		Payload:   msg.Payload,
+	}
+	if fs[FaultWrongParent] {
+		id := ulid.MustNew(ulid.Now(), rand.Reader)
+		msg.ParentID = &id
+	}
+	if fs[FaultVersionMismatch] {
+		pld, err := mismatchVersion(msg.Payload)
+		if err != nil {
+			return err
+		}
+		msg.Payload = pld
+	}
+	if fs[FaultOutOfRange] {
+		pld, err := outOfRange(msg.Payload)
+		if err != nil {
+			return err
+		}
+		msg.Payload = pld
+	}
+
+	var v interface{} = msg
+	if fs[FaultUnknownField] {
+		pld, ok, err := unknownField(msg.Payload)
+		if err != nil {
+			return err
+		}
+		msg.Payload = pld
+
+		if !ok {
+			b, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			m := map[string]interface{}{}
+			if err := json.Unmarshal(b, &m); err != nil {
+				return err
+			}
+			m[unknownFieldName] = FaultUnknownField
+			v = m
+		}
+	}
+
+	switch {
+	case fs[FaultDrop]:
+		logger.Debug("Dropping message")
+		return nil
+
+	case fs[FaultAbruptClose]:
+		b, err := c.marshal(v)
+		if err != nil {
+			return err
+		}
+		if err := c.writeRaw(b[:len(b)/2]); err != nil {
+			return err
+		}
+		if cl, ok := c.w.(io.Closer); ok {
+			if err := cl.Close(); err != nil {
+				return err
+			}
+		}
+		return errClosedByFault
+
+	case fs[FaultMalformed]:
+		b, err := c.marshal(v)
+		if err != nil {
+			return err
+		}
+		return c.writeRaw(c.malform(b))
+
+	case fs[FaultDelay]:
+		time.AfterFunc(c.faultDelay, func() {
+			if err := c.encode(v); err != nil {
+				logger.Warn("Failed to send delayed message", zap.Error(err))
+			}
+			if fs[FaultDuplicate] {
+				if err := c.encode(v); err != nil {
+					logger.Warn("Failed to send duplicated message", zap.Error(err))
+				}
+			}
+		})
+		return nil
+	}
+
+	if err := c.encode(v); err != nil {
+		return err
+	}
+	if fs[FaultDuplicate] {
+		return c.encode(v)
+	}
+	return nil
+}
+
+// mismatchVersion returns handshake payload pld with the major version incremented.
+func mismatchVersion(pld json.RawMessage) (json.RawMessage, error) {
+	var hs api.Handshake
+	if err := json.Unmarshal(pld, &hs); err != nil {
+		return nil, errors.Wrap(err, "failed to decode handshake payload")
+	}
+	hs.Version.Major++
+	hs.Version.Minor = 0
+	hs.Version.Patch = 0
+	return json.Marshal(hs)
+}
+
+// unknownFieldName is the name of the field added by FaultUnknownField.
+const unknownFieldName = "injected_fault"
+
+// unknownField returns payload pld with a field unknown to the protocol added.
+// unknownField reports false and returns pld unchanged, if pld is not a JSON object.
+func unknownField(pld json.RawMessage) (json.RawMessage, bool, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(pld, &m); err != nil || m == nil {
+		return pld, false, nil
+	}
+	m[unknownFieldName] = FaultUnknownField
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to encode payload")
+	}
+	return b, true, nil
+}
+
+// outOfRange returns state payload pld with the battery voltage of a turtle set out of range.
+// The turtle with the lowest ID is used, if pld contains any turtles, otherwise the first one of api.DefaultRoster.
+func outOfRange(pld json.RawMessage) (json.RawMessage, error) {
+	var st map[string]interface{}
+	if err := json.Unmarshal(pld, &st); err != nil {
+		return nil, errors.Wrap(err, "failed to decode state payload")
+	}
+	if st == nil {
+		st = map[string]interface{}{}
+	}
+
+	turtles, _ := st["turtles"].(map[string]interface{})
+	if turtles == nil {
+		turtles = map[string]interface{}{}
+	}
+	ids := make([]string, 0, len(turtles))
+	for id := range turtles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	id := api.DefaultRoster[0]
+	if len(ids) > 0 {
+		id = ids[0]
+	}
+	ts, _ := turtles[id].(map[string]interface{})
+	if ts == nil {
+		ts = map[string]interface{}{}
+	}
+	ts["batteryvoltage"] = 255
+	turtles[id] = ts
+	st["turtles"] = turtles
+	return json.Marshal(st)
+}
+
+// marshal returns the encoding of v using the current encoding of c.
+func (c *Conn) marshal(v interface{}) ([]byte, error) {
+	c.encoderMu.RLock()
+	encoding := c.encoding
+	c.encoderMu.RUnlock()
+
+	buf := &bytes.Buffer{}
+	enc, err := trcapi.NewEncoder(buf, encoding)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// malform returns b encoded using the current encoding of c corrupted, such that it cannot be decoded.
+// MessagePack frames are kept intact, such that only the frame payload is malformed.
+func (c *Conn) malform(b []byte) []byte {
+	c.encoderMu.RLock()
+	encoding := c.encoding
+	c.encoderMu.RUnlock()
+
+	b = append([]byte{}, b...)
+	if encoding == api.EncodingMsgPack {
+		// 0xc1 is never used in MessagePack.
+		b[4] = 0xc1
+		return b
+	}
+	// Replace the closing brace by a trailing comma.
+	return append(bytes.TrimRight(b, "}\n"), ",\n"...)
+}
+
+// writeRaw writes b to SRRS as-is.
+func (c *Conn) writeRaw(b []byte) error {
+	c.encoderMu.RLock()
+	defer c.encoderMu.RUnlock()
+
+	_, err := c.w.Write(b)
+	return err
+}
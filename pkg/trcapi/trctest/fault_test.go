@@ -0,0 +1,283 @@
+package trctest_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	. "github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	"github.com/stretchr/testify/assert"
+)
+
+// faultTestEnv is a TRC connection, of which the SRRS side is accessed at the wire level.
+type faultTestEnv struct {
+	conn *Conn
+	in   *bufio.Reader
+	out  *json.Encoder
+}
+
+func newFaultTestEnv(opts ...Option) *faultTestEnv {
+	srrsIn, trcOut := io.Pipe()
+	trcIn, srrsOut := io.Pipe()
+
+	opts = append(opts, WithHandler(api.MessageTypePing, DefaultPingHandler))
+	conn := Connect(trcOut, trcIn, opts...)
+	go func() {
+		for range conn.Errors() {
+		}
+	}()
+	return &faultTestEnv{
+		conn: conn,
+		in:   bufio.NewReader(srrsIn),
+		out:  json.NewEncoder(srrsOut),
+	}
+}
+
+// read reads a single line sent by TRC.
+func (env *faultTestEnv) read() ([]byte, error) {
+	return env.in.ReadBytes('\n')
+}
+
+// readMessage reads a single message sent by TRC.
+func (env *faultTestEnv) readMessage() (*api.Message, error) {
+	b, err := env.read()
+	if err != nil {
+		return nil, err
+	}
+	msg := &api.Message{}
+	return msg, json.Unmarshal(b, msg)
+}
+
+// ping sends a ping request to TRC and returns it.
+func (env *faultTestEnv) ping() (*api.Message, error) {
+	req := api.NewMessage(api.MessageTypePing, nil, nil)
+	return req, env.out.Encode(req)
+}
+
+//Test_items: InjectFault(), WithFaultProbability(), WithFaultAt(), WithFaultDelay() in fault.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestFaults(t *testing.T) {
+	st := &api.State{Command: api.CommandStop}
+
+	t.Run("duplicate", func(t *testing.T) {
+		a := assert.New(t)
+
+		env := newFaultTestEnv()
+		env.conn.InjectFault(FaultDuplicate)
+		go env.conn.SendState(st)
+
+		first, err := env.readMessage()
+		a.Nil(err)
+		second, err := env.readMessage()
+		a.Nil(err)
+		a.Equal(first, second)
+	})
+
+	t.Run("wrong_parent", func(t *testing.T) {
+		a := assert.New(t)
+
+		env := newFaultTestEnv()
+		env.conn.InjectFault(FaultWrongParent)
+
+		req, err := env.ping()
+		a.Nil(err)
+		resp, err := env.readMessage()
+		a.Nil(err)
+		if a.NotNil(resp.ParentID) {
+			a.NotEqual(req.MessageID, *resp.ParentID)
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		a := assert.New(t)
+
+		env := newFaultTestEnv()
+		env.conn.InjectFault(FaultDrop)
+
+		_, err := env.ping()
+		a.Nil(err)
+		req, err := env.ping()
+		a.Nil(err)
+		resp, err := env.readMessage()
+		a.Nil(err)
+		a.Equal(&req.MessageID, resp.ParentID)
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		a := assert.New(t)
+
+		env := newFaultTestEnv(WithFaultDelay(50 * time.Millisecond))
+		env.conn.InjectFault(FaultDelay)
+
+		start := time.Now()
+		req, err := env.ping()
+		a.Nil(err)
+		resp, err := env.readMessage()
+		a.Nil(err)
+		a.Equal(&req.MessageID, resp.ParentID)
+		a.True(time.Since(start) >= 50*time.Millisecond)
+	})
+
+	t.Run("unknown_field", func(t *testing.T) {
+		a := assert.New(t)
+
+		env := newFaultTestEnv()
+		env.conn.InjectFault(FaultUnknownField)
+		go env.conn.SendState(st)
+
+		msg, err := env.readMessage()
+		a.Nil(err)
+		m := map[string]interface{}{}
+		a.Nil(json.Unmarshal(msg.Payload, &m))
+		a.Equal(string(FaultUnknownField), m["injected_fault"])
+
+		// Messages without a payload object get the field added to the message itself.
+		env.conn.InjectFault(FaultUnknownField)
+		go env.conn.Ping()
+
+		b, err := env.read()
+		a.Nil(err)
+		m = map[string]interface{}{}
+		a.Nil(json.Unmarshal(b, &m))
+		a.Equal(string(FaultUnknownField), m["injected_fault"])
+	})
+
+	t.Run("out_of_range", func(t *testing.T) {
+		a := assert.New(t)
+
+		env := newFaultTestEnv()
+		env.conn.InjectFault(FaultOutOfRange)
+		go env.conn.SendState(st)
+
+		msg, err := env.readMessage()
+		a.Nil(err)
+		got := &api.State{}
+		a.Nil(json.Unmarshal(msg.Payload, got))
+		a.Equal(api.CommandStop, got.Command)
+		a.NotNil(got.Validate())
+	})
+
+	t.Run("version_mismatch", func(t *testing.T) {
+		a := assert.New(t)
+
+		env := newFaultTestEnv()
+		env.conn.InjectFault(FaultVersionMismatch)
+		go env.conn.SendHandshake(&api.Handshake{Version: trcapi.DefaultVersion})
+
+		msg, err := env.readMessage()
+		a.Nil(err)
+		hs := &api.Handshake{}
+		a.Nil(json.Unmarshal(msg.Payload, hs))
+		a.Equal(trcapi.DefaultVersion.Major+1, hs.Version.Major)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		a := assert.New(t)
+
+		env := newFaultTestEnv()
+		env.conn.InjectFault(FaultMalformed)
+		go env.conn.SendState(st)
+
+		b, err := env.read()
+		a.Nil(err)
+		a.False(json.Valid(b))
+	})
+
+	t.Run("abrupt_close", func(t *testing.T) {
+		a := assert.New(t)
+
+		env := newFaultTestEnv()
+		env.conn.InjectFault(FaultAbruptClose)
+		go env.conn.SendState(st)
+
+		b, err := env.read()
+		a.Equal(io.EOF, err)
+		a.NotEmpty(b)
+		a.False(json.Valid(b))
+	})
+
+	t.Run("probability", func(t *testing.T) {
+		a := assert.New(t)
+
+		env := newFaultTestEnv(WithFaultProbability(FaultDuplicate, 1), WithFaultSeed(42))
+		go func() {
+			env.conn.SendState(st)
+			env.conn.SendState(st)
+		}()
+
+		for i := 0; i < 4; i++ {
+			msg, err := env.readMessage()
+			a.Nil(err)
+			a.Equal(api.MessageTypeState, msg.Type)
+		}
+	})
+
+	t.Run("scheduled", func(t *testing.T) {
+		a := assert.New(t)
+
+		env := newFaultTestEnv(WithFaultAt(FaultMalformed, 20*time.Millisecond))
+		go func() {
+			env.conn.SendState(st)
+			time.Sleep(50 * time.Millisecond)
+			env.conn.SendState(st)
+		}()
+
+		b, err := env.read()
+		a.Nil(err)
+		a.True(json.Valid(b))
+
+		b, err = env.read()
+		a.Nil(err)
+		a.False(json.Valid(b))
+	})
+}
+
+//Test_items: FaultUnknownField in fault.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestFaultUnknownFieldRejected(t *testing.T) {
+	a := assert.New(t)
+
+	srrsConn, trcConn := net.Pipe()
+	defer srrsConn.Close()
+	defer trcConn.Close()
+
+	trc := Connect(trcConn, trcConn, WithHandler(api.MessageTypePing, DefaultPingHandler))
+	defer trc.Close()
+	go func() {
+		for range trc.Errors() {
+		}
+	}()
+
+	hsErrCh := make(chan error, 1)
+	go func() {
+		hsErrCh <- trc.SendHandshake(&api.Handshake{Version: trcapi.DefaultVersion})
+	}()
+
+	conn, err := trcapi.Connect(trcapi.DefaultVersion, srrsConn, srrsConn)
+	if !a.Nil(err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+	a.Nil(<-hsErrCh)
+
+	trc.InjectFault(FaultUnknownField)
+	go trc.SendState(&api.State{Command: api.CommandStop})
+
+	select {
+	case err := <-conn.Errors():
+		a.Contains(err.Error(), "unknown field")
+	case <-time.After(time.Second):
+		t.Fatal("State with an unknown field was not rejected")
+	}
+	a.Empty(conn.State(context.Background()).Command)
+}
@@ -2,13 +2,17 @@
 package trctest
 
 import (
+	"context"
 	"encoding/json"
 	"io"
+	mrand "math/rand"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rvolosatovs/turtlitto/pkg/api"
 	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/recording"
 	"go.uber.org/zap"
 )
 
@@ -43,19 +47,49 @@ func DefaultHandshakeHandler(msg *api.Message) (*api.Message, error) {
 	return nil, nil
 }
 
+// DefaultTokenHandler is a token handler, which accepts the acknowledgements of token updates.
+func DefaultTokenHandler(msg *api.Message) (*api.Message, error) {
+	if msg.ParentID == nil {
+		return nil, errors.New("TRC should not receive a token request")
+	}
+	return nil, nil
+}
+
 // Handler is a function, which handles a message.
 type Handler func(*api.Message) (*api.Message, error)
 
 // Conn represents a connection to SRRS.
 type Conn struct {
-	decoder interface{ Decode(v interface{}) error }
-	encoder interface{ Encode(v interface{}) error }
+	w       io.Writer
+	r       io.Reader
+	jsonDec *json.Decoder
+
+	decoder   trcapi.Decoder
+	encoderMu *sync.RWMutex
+	encoder   trcapi.Encoder
+	encoding  api.Encoding
+	recorder  *recording.Writer
 
 	errCh   chan error
 	closeCh chan struct{}
+	// doneCh is closed when the decoding goroutine returns.
+	doneCh chan struct{}
+	// handshakeCh is notified when the handshake response is received.
+	handshakeCh chan struct{}
 
 	handlers      *sync.Map
 	defaultHander Handler
+
+	faultMu            sync.Mutex
+	faultProbabilities map[Fault]float64
+	faultSchedule      map[Fault][]time.Duration
+	faultsArmed        map[Fault]int
+	faultTimers        []*time.Timer
+	faultDelay         time.Duration
+	faultRand          *mrand.Rand
+
+	watchersMu sync.RWMutex
+	watchers   map[*Watcher]struct{}
 }
 
 // Option represents a Conn option.
@@ -81,6 +115,55 @@ func WithDefaultHandler(h Handler) Option {
 	}
 }
 
+// WithRecorder records all messages exchanged on Conn using rec.
+func WithRecorder(rec *recording.Writer) Option {
+	return func(c *Conn) {
+		c.recorder = rec
+	}
+}
+
+// setCodec sets the encoding and the encoder and decoder used by c.
+func (c *Conn) setCodec(encoding api.Encoding, enc trcapi.Encoder, dec trcapi.Decoder) {
+	if c.recorder != nil {
+		enc = c.recorder.WrapEncoder(enc, recording.DirectionToSRRS)
+		dec = c.recorder.WrapDecoder(dec, recording.DirectionToTRC)
+	}
+	c.encoderMu.Lock()
+	c.encoder = enc
+	c.encoding = encoding
+	c.encoderMu.Unlock()
+	c.decoder = dec
+}
+
+// encode encodes v using the current encoder.
+func (c *Conn) encode(v interface{}) error {
+	c.encoderMu.RLock()
+	defer c.encoderMu.RUnlock()
+	return c.encoder.Encode(v)
+}
+
+// switchEncoding switches the encoding of c according to the handshake response msg.
+func (c *Conn) switchEncoding(msg *api.Message) error {
+	var hs api.Handshake
+	if err := json.Unmarshal(msg.Payload, &hs); err != nil {
+		return errors.Wrap(err, "failed to decode handshake payload")
+	}
+	if hs.Encoding == "" || hs.Encoding == api.EncodingJSON {
+		return nil
+	}
+
+	enc, err := trcapi.NewEncoder(c.w, hs.Encoding)
+	if err != nil {
+		return err
+	}
+	dec, err := trcapi.NewDecoder(trcapi.RemainingReader(c.jsonDec, c.r), hs.Encoding)
+	if err != nil {
+		return err
+	}
+	c.setCodec(hs.Encoding, enc, dec)
+	return nil
+}
+
 // Connect establishes the TRC-side connection according to TRC API protocol
 // specification of version ver on w and r.
 func Connect(w io.Writer, r io.Reader, opts ...Option) *Conn {
@@ -89,15 +172,29 @@ func Connect(w io.Writer, r io.Reader, opts ...Option) *Conn {
 	dec := json.NewDecoder(r)
 	dec.DisallowUnknownFields()
 	conn := &Conn{
-		decoder:  dec,
-		encoder:  json.NewEncoder(w),
-		closeCh:  make(chan struct{}),
-		errCh:    make(chan error),
-		handlers: &sync.Map{},
+		w:           w,
+		r:           r,
+		jsonDec:     dec,
+		encoderMu:   &sync.RWMutex{},
+		closeCh:     make(chan struct{}),
+		doneCh:      make(chan struct{}),
+		handshakeCh: make(chan struct{}, 1),
+		errCh:       make(chan error),
+		handlers:    &sync.Map{},
+
+		faultProbabilities: make(map[Fault]float64),
+		faultSchedule:      make(map[Fault][]time.Duration),
+		faultsArmed:        make(map[Fault]int),
+		faultDelay:         DefaultFaultDelay,
+		faultRand:          mrand.New(mrand.NewSource(time.Now().UnixNano())),
+
+		watchers: make(map[*Watcher]struct{}),
 	}
 	for _, opt := range opts {
 		opt(conn)
 	}
+	conn.setCodec(api.EncodingJSON, json.NewEncoder(w), dec)
+	conn.scheduleFaults()
 
 	if conn.defaultHander == nil {
 		conn.defaultHander = func(msg *api.Message) (*api.Message, error) {
@@ -106,6 +203,8 @@ func Connect(w io.Writer, r io.Reader, opts ...Option) *Conn {
 	}
 
 	go func() {
+		defer close(conn.doneCh)
+
 		for {
 			var msg api.Message
 			err := conn.decoder.Decode(&msg)
@@ -123,10 +222,26 @@ func Connect(w io.Writer, r io.Reader, opts ...Option) *Conn {
 				return
 			default:
 			}
+			if trcapi.IsFrameError(err) {
+				logger.Warn("Skipping malformed message", zap.Error(err))
+				continue
+			}
 			if err != nil {
 				conn.errCh <- errors.Wrap(err, "failed to decode incoming message")
 				return
 			}
+			conn.notifyWatchers(&msg)
+
+			if msg.Type == api.MessageTypeHandshake && msg.ParentID != nil {
+				if err := conn.switchEncoding(&msg); err != nil {
+					conn.errCh <- errors.Wrap(err, "failed to switch encoding")
+					return
+				}
+				select {
+				case conn.handshakeCh <- struct{}{}:
+				default:
+				}
+			}
 
 			var h Handler
 			v, ok := conn.handlers.Load(msg.Type)
@@ -152,7 +267,7 @@ func Connect(w io.Writer, r io.Reader, opts ...Option) *Conn {
 			logger.Debug("Sending response to SRRS...",
 				zap.Reflect("resp", resp),
 			)
-			if err := conn.encoder.Encode(resp); err != nil {
+			if err := conn.send(resp); err != nil {
 				conn.errCh <- err
 				return
 			}
@@ -163,7 +278,7 @@ func Connect(w io.Writer, r io.Reader, opts ...Option) *Conn {
 
 // Ping sends ping to the TRC and waits for response.
 func (c *Conn) Ping() error {
-	return c.encoder.Encode(api.NewMessage(api.MessageTypePing, nil, nil))
+	return c.send(api.NewMessage(api.MessageTypePing, nil, nil))
 }
 
 // SetState sends the state to TRC and waits for response.
@@ -172,20 +287,68 @@ func (c *Conn) SendState(st *api.State) error {
 	if err != nil {
 		return err
 	}
-	return c.encoder.Encode(api.NewMessage(api.MessageTypeState, b, nil))
+	return c.send(api.NewMessage(api.MessageTypeState, b, nil))
+}
+
+// SendToken sends a token message, which replaces the token sent in the handshake by tok.
+func (c *Conn) SendToken(tok string) error {
+	b, err := json.Marshal(&api.TokenUpdate{
+		Token: tok,
+	})
+	if err != nil {
+		return err
+	}
+	return c.send(api.NewMessage(api.MessageTypeToken, b, nil))
 }
 
 // SendHandshake sends handshake message.
+// If hs offers encodings, SendHandshake waits for the response, since
+// the encoding negotiated by SRRS must be used for all subsequent messages.
 func (c *Conn) SendHandshake(hs *api.Handshake) error {
 	b, err := json.Marshal(hs)
 	if err != nil {
 		return err
 	}
-	return c.encoder.Encode(api.NewMessage(api.MessageTypeHandshake, b, nil))
+	return c.sendHandshake(api.NewMessage(api.MessageTypeHandshake, b, nil), len(hs.Encodings) > 0)
+}
+
+// sendHandshake sends handshake request msg and, if wait is true, waits for the response.
+func (c *Conn) sendHandshake(msg *api.Message, wait bool) error {
+	if err := c.send(msg); err != nil {
+		return err
+	}
+	if !wait {
+		return nil
+	}
+
+	select {
+	case <-c.handshakeCh:
+		return nil
+	case <-c.closeCh:
+		return errors.New("connection closed before handshake response was received")
+	case <-c.doneCh:
+		return errors.New("decoding stopped before handshake response was received")
+	}
+}
+
+// Replay replays the messages sent by TRC in recorded entries es to SRRS.
+// By default, the timing of the original session is preserved, see recording.Replay for options.
+func (c *Conn) Replay(ctx context.Context, es []*recording.Entry, opts ...recording.ReplayOption) error {
+	return recording.Replay(ctx, es, func(e *recording.Entry) error {
+		if e.Message.Type == api.MessageTypeHandshake && e.Message.ParentID == nil {
+			var hs api.Handshake
+			if err := json.Unmarshal(e.Message.Payload, &hs); err != nil {
+				return errors.Wrap(err, "failed to decode recorded handshake payload")
+			}
+			return c.sendHandshake(e.Message, len(hs.Encodings) > 0)
+		}
+		return c.send(e.Message)
+	}, opts...)
 }
 
 // Close closes the connection.
 func (c *Conn) Close() error {
+	c.stopFaults()
 	close(c.closeCh)
 	return nil
 }
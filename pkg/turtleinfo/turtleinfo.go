@@ -0,0 +1,194 @@
+// Package turtleinfo implements a registry of static information about turtles,
+// which is optionally persisted to a JSON file.
+package turtleinfo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+// Store stores information about turtles by ID.
+// Store is safe for concurrent use by multiple goroutines.
+type Store struct {
+	// path is the path to the file the store is persisted to. The store is not persisted if empty.
+	path string
+
+	mu    sync.RWMutex
+	infos map[string]*api.TurtleInfo
+
+	subsMu sync.RWMutex
+	subs   map[chan struct{}]struct{}
+}
+
+// New returns a new empty *Store, which is kept in memory only.
+func New() *Store {
+	return &Store{
+		infos: make(map[string]*api.TurtleInfo),
+		subs:  make(map[chan struct{}]struct{}),
+	}
+}
+
+// Open returns a new *Store persisted to the file at path.
+// The file is created on first update if it does not exist.
+func Open(path string) (*Store, error) {
+	s := New()
+	s.path = path
+
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return s, nil
+	case err != nil:
+		return nil, errors.Wrap(err, "failed to read turtle info file")
+	}
+
+	if err := json.Unmarshal(b, &s.infos); err != nil {
+		return nil, errors.Wrap(err, "failed to decode turtle info file")
+	}
+	for id, info := range s.infos {
+		if info == nil {
+			delete(s.infos, id)
+			continue
+		}
+		if err := info.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid info of turtle %s", id)
+		}
+	}
+	return s, nil
+}
+
+// Get returns the information about turtle identified by id and whether it is known.
+func (s *Store) Get(id string) (*api.TurtleInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	info, ok := s.infos[id]
+	if !ok {
+		return nil, false
+	}
+	cp := *info
+	return &cp, true
+}
+
+// List returns the information about all known turtles by ID.
+func (s *Store) List() map[string]*api.TurtleInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make(map[string]*api.TurtleInfo, len(s.infos))
+	for id, info := range s.infos {
+		cp := *info
+		infos[id] = &cp
+	}
+	return infos
+}
+
+// Update replaces the information about turtles in upd. Turtles with nil information are removed.
+// Update validates all of upd before applying it and persists the store, if it is backed by a file.
+// If an error is returned, the store is not modified.
+func (s *Store) Update(upd map[string]*api.TurtleInfo) error {
+	var errs api.ValidationErrors
+	for id, info := range upd {
+		path := "[" + strconv.Quote(id) + "]"
+		if id == "" {
+			errs = append(errs, &api.ValidationError{
+				Path:    path,
+				Reason:  api.ValidationReasonInvalidValue,
+				Message: "turtle ID must not be empty",
+			})
+		}
+		if info == nil {
+			continue
+		}
+		for _, err := range api.AsValidationErrors(info.Validate()) {
+			cp := *err
+			cp.Path = path + "." + cp.Path
+			errs = append(errs, &cp)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	s.mu.Lock()
+	infos := make(map[string]*api.TurtleInfo, len(s.infos)+len(upd))
+	for id, info := range s.infos {
+		infos[id] = info
+	}
+	for id, info := range upd {
+		if info == nil {
+			delete(infos, id)
+			continue
+		}
+		cp := *info
+		infos[id] = &cp
+	}
+
+	if err := s.save(infos); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.infos = infos
+	s.mu.Unlock()
+
+	s.notify()
+	return nil
+}
+
+// save persists infos to s.path, if set.
+// The file is replaced atomically, such that it is never left partially written.
+func (s *Store) save(infos map[string]*api.TurtleInfo) error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(infos, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode turtle info")
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrap(err, "failed to write turtle info file")
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to replace turtle info file")
+	}
+	return nil
+}
+
+// Subscribe opens a subscription to store changes.
+// Subscribe returns a channel, on which a value is sent every time the store changes
+// and a function, which must be used to close the subscription.
+func (s *Store) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	s.subsMu.Lock()
+	s.subs[ch] = struct{}{}
+	s.subsMu.Unlock()
+
+	return ch, func() {
+		s.subsMu.Lock()
+		delete(s.subs, ch)
+		s.subsMu.Unlock()
+	}
+}
+
+// notify notifies the subscribers of a store change.
+func (s *Store) notify() {
+	s.subsMu.RLock()
+	for ch := range s.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	s.subsMu.RUnlock()
+}
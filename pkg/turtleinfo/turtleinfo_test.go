@@ -0,0 +1,77 @@
+package turtleinfo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	. "github.com/rvolosatovs/turtlitto/pkg/turtleinfo"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Open(), Get(), List(), Update(), Subscribe() in turtleinfo.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestStore(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "turtleinfo")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "turtles.json")
+
+	s, err := Open(path)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Empty(s.List())
+
+	ch, closeFn := s.Subscribe()
+	defer closeFn()
+
+	raphael := &api.TurtleInfo{
+		Name:             "Raphael",
+		Serial:           "TU-0003",
+		Generation:       2,
+		BatteryChemistry: api.BatteryChemistryLiPo,
+		Notes:            "Left wheel replaced",
+	}
+	a.NoError(s.Update(map[string]*api.TurtleInfo{
+		"3": raphael,
+		"8": {Name: "Splinter"},
+	}))
+
+	select {
+	case <-ch:
+	default:
+		t.Error("No change notification received")
+	}
+
+	info, ok := s.Get("3")
+	a.True(ok)
+	a.Equal(raphael, info)
+
+	a.Error(s.Update(map[string]*api.TurtleInfo{
+		"3": {BatteryChemistry: "lead_acid"},
+	}))
+	info, _ = s.Get("3")
+	a.Equal(raphael, info)
+
+	a.NoError(s.Update(map[string]*api.TurtleInfo{
+		"8": nil,
+	}))
+	_, ok = s.Get("8")
+	a.False(ok)
+
+	s, err = Open(path)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Equal(map[string]*api.TurtleInfo{"3": raphael}, s.List())
+}
@@ -0,0 +1,138 @@
+package webapi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+// matchTracker tracks the state of a match as commands are sent to TRC.
+// matchTracker is safe for concurrent use by multiple goroutines.
+type matchTracker struct {
+	mu    sync.Mutex
+	match api.Match
+	// elapsed is the game time elapsed in the current period before the clock was last started.
+	elapsed time.Duration
+	// startedAt is the time the clock was last started at. It is zero if the clock is paused.
+	startedAt time.Time
+
+	subsMu sync.RWMutex
+	subs   map[chan struct{}]struct{}
+}
+
+// newMatchTracker returns a new matchTracker of a stopped match in the first half.
+func newMatchTracker() *matchTracker {
+	return &matchTracker{
+		match: api.Match{
+			Period: api.PeriodFirstHalf,
+			Phase:  api.PhaseStopped,
+		},
+		subs: make(map[chan struct{}]struct{}),
+	}
+}
+
+// Match returns the current state of the match.
+func (t *matchTracker) Match() *api.Match {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m := t.match
+	elapsed := t.elapsed
+	if m.ClockRunning {
+		elapsed += time.Since(t.startedAt)
+	}
+	m.Clock = elapsed.Seconds()
+	return &m
+}
+
+// CheckCommand returns a *api.TransitionError if cmd is not allowed in the current phase of the match.
+func (t *matchTracker) CheckCommand(cmd api.Command) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, err := t.match.Phase.Transition(cmd)
+	return err
+}
+
+// HandleCommand updates the match according to cmd sent to TRC.
+// The clock runs while the game is in api.PhaseRunning, if the game was started by a RefBox command.
+// Demos are played without the clock running.
+func (t *matchTracker) HandleCommand(cmd api.Command) {
+	t.mu.Lock()
+	phase, _ := t.match.Phase.Transition(cmd)
+
+	running := t.match.ClockRunning
+	switch {
+	case phase != api.PhaseRunning:
+		running = false
+	case t.match.Phase != api.PhaseRunning:
+		info, _ := api.GetCommand(cmd)
+		running = info.Category == api.CommandCategoryRefBox
+	}
+
+	switch {
+	case running && !t.match.ClockRunning:
+		t.startedAt = time.Now()
+
+	case !running && t.match.ClockRunning:
+		t.elapsed += time.Since(t.startedAt)
+		t.startedAt = time.Time{}
+	}
+	t.match.ClockRunning = running
+	t.match.Phase = phase
+	if cmd.IsSetPiece() {
+		t.match.LastSetPiece = cmd
+	}
+	t.mu.Unlock()
+
+	t.notify()
+}
+
+// Update applies the manual update u to the match.
+func (t *matchTracker) Update(u *api.MatchUpdate) {
+	t.mu.Lock()
+	if u.Score != nil {
+		t.match.Score = *u.Score
+	}
+	if u.Period != "" && u.Period != t.match.Period {
+		t.match.Period = u.Period
+		t.match.Phase = api.PhaseStopped
+		t.match.ClockRunning = false
+		t.match.LastSetPiece = ""
+		t.elapsed = 0
+		t.startedAt = time.Time{}
+	}
+	t.mu.Unlock()
+
+	t.notify()
+}
+
+// Subscribe opens a subscription to match changes.
+// Subscribe returns a channel, on which a value is sent every time the match changes
+// and a function, which must be used to close the subscription.
+func (t *matchTracker) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	t.subsMu.Lock()
+	t.subs[ch] = struct{}{}
+	t.subsMu.Unlock()
+
+	return ch, func() {
+		t.subsMu.Lock()
+		delete(t.subs, ch)
+		t.subsMu.Unlock()
+	}
+}
+
+// notify notifies the subscribers of a match change.
+func (t *matchTracker) notify() {
+	t.subsMu.RLock()
+	for ch := range t.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	t.subsMu.RUnlock()
+}
@@ -9,14 +9,17 @@ import (
 	"encoding/json"
 	"net/http"
 	"path"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/schema"
 	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
 	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/turtleinfo"
 	"go.uber.org/zap"
 )
 
@@ -34,6 +37,10 @@ const (
 	inactivityTimeout = 5 * time.Second
 )
 
+// CloseTokenRevoked is the WebSocket close code sent when the session is invalidated,
+// because TRC replaced the token it was created with.
+const CloseTokenRevoked = 4001
+
 var (
 	// StateEndpoint is the state endpoint.
 	StateEndpoint = path.Join("api", "v1", "state")
@@ -42,19 +49,105 @@ var (
 	AuthEndpoint = path.Join("api", "v1", "auth")
 
 	// TurtleEndpoint is the turtle endpoint.
+	// Only the fields listed by api.WritableTurtleFields may be set.
 	TurtleEndpoint = path.Join("api", "v1", "turtles")
 
 	// CommandEndpoint is the command endpoint.
 	CommandEndpoint = path.Join("api", "v1", "command")
 
+	// MatchEndpoint is the match endpoint.
+	MatchEndpoint = path.Join("api", "v1", "match")
+
+	// TurtleInfoEndpoint is the endpoint of the turtle registry.
+	TurtleInfoEndpoint = path.Join("api", "v1", "turtle_info")
+
+	// CommandsEndpoint is the endpoint describing the known commands.
+	// It is shared by all TRC's served.
+	CommandsEndpoint = path.Join("api", "v1", "commands")
+
+	// SchemaEndpoint is the endpoint listing the names of JSON Schema documents describing the protocol.
+	// Each document is served at SchemaEndpoint/<name>.json. It is shared by all TRC's served.
+	SchemaEndpoint = path.Join("api", "v1", "schema")
+
+	// TRCEndpoint is the endpoint listing the names of TRC's served.
+	// Endpoints scoped to a particular TRC are nested under it, see ScopedEndpoint.
+	TRCEndpoint = path.Join("api", "v1", "trcs")
+
+	// FeedEndpoint is the combined state endpoint of all TRC's served.
+	FeedEndpoint = path.Join("api", "v1", "feed")
+
 	errActiveWebSocket     = errors.New("an active WebSocket connection already exists")
 	errAuthenticateFirst   = errors.New("authenticate first")
 	errAuthorizationHeader = errors.New("`Authorization` header not found or invalid")
 	errInvalidSessionKey   = errors.New("invalid session key")
 	errInvalidToken        = errors.New("invalid token")
+	errTokenRevoked        = errors.New("token revoked by TRC, authenticate again")
 	errFailedToGetToken    = errors.New("TRC connection established, but failed to get token")
 )
 
+// ScopedEndpoint returns the endpoint ep scoped to TRC identified by name.
+// ep must be one of AuthEndpoint, StateEndpoint, TurtleEndpoint, CommandEndpoint, MatchEndpoint or TurtleInfoEndpoint.
+func ScopedEndpoint(name, ep string) string {
+	return path.Join(TRCEndpoint, name, path.Base(ep))
+}
+
+// State is the state of TRC sent on StateEndpoint and FeedEndpoint.
+// Along with the state received from TRC, it contains the state of the match, the registered turtle information
+// and the violations of team consistency rules, which are never exchanged with TRC.
+type State struct {
+	api.State
+
+	// Match represents the state of the match.
+	Match *api.Match `json:"match,omitempty"`
+
+	// TurtleInfo represents the information about turtles by ID as registered in SRRS.
+	// TurtleInfo is always sent in full, such that clients notice turtles removed from the registry.
+	TurtleInfo map[string]*api.TurtleInfo `json:"turtle_info"`
+
+	// Warnings represents the violations of team consistency rules found in the state, see api.State.ValidateTeam.
+	Warnings api.ValidationErrors `json:"warnings,omitempty"`
+}
+
+// FeedUpdate is the message sent on FeedEndpoint.
+type FeedUpdate struct {
+	// TRC is the name of the TRC, which State belongs to.
+	TRC   string `json:"trc"`
+	State *State `json:"state"`
+	// Error is set if TRC is no longer included in the feed, e.g. because the connection to it was lost.
+	// State is nil in that case.
+	Error string `json:"error,omitempty"`
+}
+
+// ErrorResponse is the body of responses to requests, which failed validation.
+type ErrorResponse struct {
+	Error string `json:"error"`
+
+	// Violations represents every violation found in the request.
+	Violations api.ValidationErrors `json:"violations"`
+}
+
+// httpError replies to the request with err and HTTP code code.
+// If err is caused by api.ValidationErrors, the reply is an ErrorResponse encoded as JSON,
+// otherwise it is err in plain text.
+func httpError(w http.ResponseWriter, err error, code int) {
+	switch errors.Cause(err).(type) {
+	case api.ValidationErrors, *api.ValidationError:
+	default:
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(&ErrorResponse{
+		Error:      err.Error(),
+		Violations: api.AsValidationErrors(err),
+	}); err != nil {
+		zap.L().Error("Failed to write error response", zap.Error(err))
+	}
+}
+
 // controlWriter can write Control messages to itself.
 type controlWriter interface {
 	WriteControl(messageType int, data []byte, deadline time.Time) error
@@ -77,24 +170,280 @@ func wsError(w controlWriter, logger *zap.Logger, err error, code int) {
 type session struct {
 	isActive bool
 	key      string
+	// token is the TRC token the session was created with.
+	token string
 }
 
-// server manages the web API.
+// checkToken returns errTokenRevoked, if s was created with a token other than the current token of TRC
+// connected to via trcConn.
+func (s *session) checkToken(trcConn *trcapi.Conn) error {
+	tok, err := trcConn.Token()
+	if err != nil {
+		return errors.Wrap(err, errFailedToGetToken.Error())
+	}
+	if tok != s.token {
+		return errTokenRevoked
+	}
+	return nil
+}
+
+// server manages the web API of a single TRC.
 type server struct {
-	pool *trcapi.Pool
+	// name is the name of the TRC in the registry or empty, if the TRC is not part of one.
+	name  string
+	pool  *trcapi.Pool
+	match *matchTracker
+	info  *turtleinfo.Store
+
+	// advisory specifies whether commands not allowed in the current phase are sent to TRC anyway.
+	advisory bool
+	// strictTeam specifies whether turtle updates violating team consistency rules are rejected.
+	strictTeam bool
+	// field are the field dimensions positions in turtle updates are validated against.
+	field api.FieldDimensions
+	// commandMu serializes commands, such that each is validated against the phase resulting from the previous one.
+	commandMu sync.Mutex
 
 	sessionMu sync.RWMutex
 	session   *session
 
 	stopTimerMu sync.Mutex
 	stopTimer   *time.Timer
+	activeConns int
 }
 
-// handleState handles requests to StateEndpoint.
-func (srv *server) handleState(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	logger := logcontext.Logger(ctx)
+// Option represents a web API option.
+type Option func(*server)
+
+// WithAdvisoryPhases configures the web API to only log commands, which are not allowed
+// in the current phase of the game, instead of rejecting them.
+// This is useful for demos, where the rules are not strictly followed.
+func WithAdvisoryPhases() Option {
+	return func(srv *server) {
+		srv.advisory = true
+	}
+}
 
+// WithStrictTeamValidation configures the web API to reject turtle state updates, which introduce
+// violations of team consistency rules, see api.State.ValidateTeam.
+// By default, the violations are only reported as warnings in the state.
+func WithStrictTeamValidation() Option {
+	return func(srv *server) {
+		srv.strictTeam = true
+	}
+}
+
+// WithFieldDimensions configures the web API to validate positions in turtle state updates against d.
+// By default, api.DefaultFieldDimensions are used.
+func WithFieldDimensions(d api.FieldDimensions) Option {
+	return func(srv *server) {
+		srv.field = d
+	}
+}
+
+// WithTurtleInfo configures the web API to serve and edit the turtle information in s.
+// By default, the turtle information is only kept in memory.
+func WithTurtleInfo(s *turtleinfo.Store) Option {
+	return func(srv *server) {
+		srv.info = s
+	}
+}
+
+// WithTRCOptions configures the web API of the TRC named name in a registry using opts,
+// see RegisterRegistryHandlers. The web API of other TRC's is not affected.
+// This is useful for options, which must not be shared between TRC's, like WithTurtleInfo.
+func WithTRCOptions(name string, opts ...Option) Option {
+	return func(srv *server) {
+		if srv.name != name {
+			return
+		}
+		for _, opt := range opts {
+			opt(srv)
+		}
+	}
+}
+
+// newServer returns a new server managing the TRC connections in pool.
+func newServer(name string, pool *trcapi.Pool, opts ...Option) *server {
+	srv := &server{
+		name:  name,
+		pool:  pool,
+		match: newMatchTracker(),
+		info:  turtleinfo.New(),
+		field: api.DefaultFieldDimensions(),
+	}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	srv.stopTimer = time.AfterFunc(420 /* blaze it */, func() {
+		trcConn, err := pool.Conn()
+		if err != nil {
+			zap.L().Error("Failed to establish connection to TRC", zap.Error(err))
+			return
+		}
+		defer trcConn.Close()
+
+		srv.commandMu.Lock()
+		defer srv.commandMu.Unlock()
+
+		if err := trcConn.SetCommand(context.Background(), api.CommandStop); err != nil {
+			zap.L().Error("Failed to stop TRC", zap.Error(err))
+			return
+		}
+		srv.match.HandleCommand(api.CommandStop)
+	})
+	srv.stopTimer.Stop()
+	return srv
+}
+
+// track wraps hdl, such that the TRC's managed by srvs are stopped once there are no active connections
+// to any of them for inactivityTimeout.
+func track(hdl http.HandlerFunc, srvs ...*server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, srv := range srvs {
+			srv.stopTimerMu.Lock()
+			srv.activeConns++
+			srv.stopTimer.Stop()
+			srv.stopTimerMu.Unlock()
+		}
+
+		hdl(w, r)
+
+		for _, srv := range srvs {
+			srv.stopTimerMu.Lock()
+			srv.activeConns--
+			if srv.activeConns == 0 {
+				srv.stopTimer.Reset(inactivityTimeout)
+			}
+			srv.stopTimerMu.Unlock()
+		}
+	}
+}
+
+// state returns the current state of TRC connected to via trcConn along with the state of the match,
+// the registered turtle information and the violations of team consistency rules.
+func (srv *server) state(ctx context.Context, trcConn *trcapi.Conn) *State {
+	st := trcConn.State(ctx)
+	return &State{
+		State:      *st,
+		Match:      srv.match.Match(),
+		TurtleInfo: srv.info.List(),
+		Warnings:   api.AsValidationErrors(st.ValidateTeam()),
+	}
+}
+
+// checkTeam returns api.ValidationErrors listing the violations of team consistency rules,
+// which are introduced by applying upd to st. Violations already present in st are ignored.
+// st is modified.
+func checkTeam(st *api.State, upd map[string]*api.TurtleState) error {
+	type violation struct {
+		path   string
+		reason api.ValidationReason
+	}
+	known := make(map[violation]struct{})
+	for _, err := range api.AsValidationErrors(st.ValidateTeam()) {
+		known[violation{err.Path, err.Reason}] = struct{}{}
+	}
+
+	if st.Turtles == nil {
+		st.Turtles = make(map[string]*api.TurtleState, len(upd))
+	}
+	for id, ts := range upd {
+		if ts == nil {
+			continue
+		}
+		if st.Turtles[id] == nil {
+			st.Turtles[id] = &api.TurtleState{}
+		}
+
+		// Only the fields set in ts are updated.
+		b, err := json.Marshal(ts)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode turtle state")
+		}
+		if err := json.Unmarshal(b, st.Turtles[id]); err != nil {
+			return errors.Wrap(err, "failed to apply turtle state")
+		}
+	}
+
+	var errs api.ValidationErrors
+	for _, err := range api.AsValidationErrors(st.ValidateTeam()) {
+		if _, ok := known[violation{err.Path, err.Reason}]; !ok {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// withRemovals returns st with turtles present in old, but not in st, explicitly set to nil,
+// such that WebSocket clients are notified of turtles leaving the roster.
+// st is not modified.
+func withRemovals(old, st *State) *State {
+	var ret *State
+	for id := range old.Turtles {
+		if _, ok := st.Turtles[id]; ok {
+			continue
+		}
+
+		if ret == nil {
+			cp := *st
+			cp.Turtles = make(map[string]*api.TurtleState, len(st.Turtles)+1)
+			for k, v := range st.Turtles {
+				cp.Turtles[k] = v
+			}
+			ret = &cp
+		}
+		ret.Turtles[id] = nil
+	}
+	if ret == nil {
+		return st
+	}
+	return ret
+}
+
+// acquireSession marks the session identified by key as active and returns it.
+// acquireSession returns the WebSocket close code along with the error,
+// if the session cannot be acquired.
+func (srv *server) acquireSession(key string) (*session, int, error) {
+	srv.sessionMu.Lock()
+	defer srv.sessionMu.Unlock()
+
+	switch {
+	case srv.session == nil:
+		return nil, websocket.ClosePolicyViolation, errAuthenticateFirst
+
+	case key != srv.session.key:
+		return nil, websocket.CloseInvalidFramePayloadData, errInvalidSessionKey
+
+	case srv.session.isActive:
+		return nil, websocket.ClosePolicyViolation, errActiveWebSocket
+	}
+	srv.session.isActive = true
+	return srv.session, 0, nil
+}
+
+// releaseSession marks the session s as inactive.
+func (srv *server) releaseSession(s *session) {
+	srv.sessionMu.Lock()
+	s.isActive = false
+	srv.sessionMu.Unlock()
+}
+
+// invalidateSession invalidates the session s, if it is the current one.
+func (srv *server) invalidateSession(s *session) {
+	srv.sessionMu.Lock()
+	if srv.session == s {
+		srv.session = nil
+	}
+	srv.sessionMu.Unlock()
+}
+
+// upgrade upgrades the HTTP connection to a WebSocket and reads the initial message into v.
+// upgrade closes the WebSocket on error, if it was opened.
+func upgrade(w http.ResponseWriter, r *http.Request, logger *zap.Logger, v interface{}) (*websocket.Conn, error) {
 	wsConn, err := (&websocket.Upgrader{
 		HandshakeTimeout:  readTimeout,
 		EnableCompression: true,
@@ -103,55 +452,91 @@ func (srv *server) handleState(w http.ResponseWriter, r *http.Request) {
 		}}).Upgrade(w, r, nil)
 	if err != nil {
 		logger.Error("Failed to open WebSocket")
-		return
+		return nil, err
 	}
-	defer wsConn.Close()
 
-	wsConn.EnableWriteCompression(true)
-	if err := wsConn.SetCompressionLevel(flate.BestCompression); err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to enable compression"), websocket.CloseProtocolError)
-		return
+	if err := func() error {
+		wsConn.EnableWriteCompression(true)
+		if err := wsConn.SetCompressionLevel(flate.BestCompression); err != nil {
+			wsError(wsConn, logger, errors.Wrap(err, "failed to enable compression"), websocket.CloseProtocolError)
+			return err
+		}
+
+		logger.Debug("Reading key...")
+		if err := wsConn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+			wsError(wsConn, logger, errors.Wrap(err, "failed to set read deadline"), websocket.CloseInternalServerErr)
+			return err
+		}
+
+		if err := wsConn.ReadJSON(v); err != nil {
+			wsError(wsConn, logger, errors.Wrap(err, "failed to read session key"), websocket.CloseInvalidFramePayloadData)
+			return err
+		}
+		return nil
+	}(); err != nil {
+		wsConn.Close()
+		return nil, err
 	}
+	return wsConn, nil
+}
 
-	var key string
-	logger.Debug("Reading key...")
+// writeJSON writes v on the WebSocket.
+func writeJSON(wsConn *websocket.Conn, v interface{}) error {
+	if err := wsConn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return errors.Wrap(err, "failed to set write deadline")
+	}
+	return wsConn.WriteJSON(v)
+}
 
-	if err := wsConn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to set read deadline"), websocket.CloseInternalServerErr)
-		return
+// writePing writes a ping message on the WebSocket.
+func writePing(wsConn *websocket.Conn) error {
+	if err := wsConn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return errors.Wrap(err, "failed to set write deadline")
 	}
+	return wsConn.WriteMessage(websocket.PingMessage, nil)
+}
 
-	if err := wsConn.ReadJSON(&key); err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to read session key"), websocket.CloseInvalidFramePayloadData)
-		return
+// readErrors starts reading messages from the WebSocket and returns a channel,
+// on which the first read error is sent.
+func readErrors(wsConn *websocket.Conn) (<-chan error, error) {
+	if err := wsConn.SetReadDeadline(time.Now().Add(pingInterval + writeTimeout + readTimeout)); err != nil {
+		return nil, errors.Wrap(err, "failed to set read deadline")
 	}
+	wsConn.SetPongHandler(func(string) error {
+		return wsConn.SetReadDeadline(time.Now().Add(pingInterval + writeTimeout + readTimeout))
+	})
 
-	srv.sessionMu.Lock()
-	switch {
-	case srv.session == nil:
-		srv.sessionMu.Unlock()
-		wsError(wsConn, logger, errAuthenticateFirst, websocket.ClosePolicyViolation)
-		return
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			_, _, err := wsConn.NextReader()
+			if err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+	return errCh, nil
+}
 
-	case key != srv.session.key:
-		srv.sessionMu.Unlock()
-		wsError(wsConn, logger, errInvalidSessionKey, websocket.CloseInvalidFramePayloadData)
-		return
+// handleState handles requests to StateEndpoint.
+func (srv *server) handleState(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logcontext.Logger(ctx)
 
-	case srv.session.isActive:
-		srv.sessionMu.Unlock()
-		wsError(wsConn, logger, errActiveWebSocket, websocket.ClosePolicyViolation)
+	var key string
+	wsConn, err := upgrade(w, r, logger, &key)
+	if err != nil {
 		return
 	}
+	defer wsConn.Close()
 
-	srv.session.isActive = true
-	srv.sessionMu.Unlock()
-
-	defer func() {
-		srv.sessionMu.Lock()
-		srv.session.isActive = false
-		srv.sessionMu.Unlock()
-	}()
+	sess, code, err := srv.acquireSession(key)
+	if err != nil {
+		wsError(wsConn, logger, err, code)
+		return
+	}
+	defer srv.releaseSession(sess)
 
 	logger.Debug("Retrieving a connection from pool...")
 	trcConn, err := srv.pool.Conn()
@@ -168,35 +553,49 @@ func (srv *server) handleState(w http.ResponseWriter, r *http.Request) {
 	}
 	defer closeFn()
 
-	oldState := trcConn.State(ctx)
+	logger.Debug("Subscribing to token changes...")
+	tokenCh, closeTokenFn, err := trcConn.SubscribeTokenChanges(ctx)
+	if err != nil {
+		wsError(wsConn, logger, errors.Wrap(err, "failed to subscribe to token changes"), websocket.CloseInternalServerErr)
+		return
+	}
+	defer closeTokenFn()
 
-	if err := wsConn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to set write deadline"), websocket.CloseInternalServerErr)
+	if err := sess.checkToken(trcConn); err != nil {
+		srv.invalidateSession(sess)
+		wsError(wsConn, logger, err, CloseTokenRevoked)
 		return
 	}
 
+	matchCh, closeMatchFn := srv.match.Subscribe()
+	defer closeMatchFn()
+
+	infoCh, closeInfoFn := srv.info.Subscribe()
+	defer closeInfoFn()
+
+	oldState := srv.state(ctx, trcConn)
+
 	logger.Debug("Sending current state on the WebSocket...", zap.Reflect("state", oldState))
-	if err := wsConn.WriteJSON(oldState); err != nil {
+	if err := writeJSON(wsConn, oldState); err != nil {
 		wsError(wsConn, logger, errors.Wrap(err, "failed to write state"), websocket.CloseInternalServerErr)
 		return
 	}
 
-	if err := wsConn.SetReadDeadline(time.Now().Add(pingInterval + writeTimeout + readTimeout)); err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to set read deadline"), websocket.CloseInternalServerErr)
+	errCh, err := readErrors(wsConn)
+	if err != nil {
+		wsError(wsConn, logger, err, websocket.CloseInternalServerErr)
+		return
 	}
-	wsConn.SetPongHandler(func(string) error {
-		return wsConn.SetReadDeadline(time.Now().Add(pingInterval + writeTimeout + readTimeout))
-	})
 
-	errCh := make(chan error, 1)
-	go func() {
-		for {
-			_, _, err := wsConn.NextReader()
-			if err != nil {
-				errCh <- err
-			}
-		}
-	}()
+	// sendState sends the current state on the WebSocket.
+	sendState := func() error {
+		st := srv.state(ctx, trcConn)
+		diff := withRemovals(oldState, st)
+		oldState = st
+
+		logger.Debug("Sending state on the WebSocket...", zap.Reflect("state", diff))
+		return errors.Wrap(writeJSON(wsConn, diff), "failed to write state")
+	}
 
 	for {
 		select {
@@ -217,40 +616,282 @@ func (srv *server) handleState(w http.ResponseWriter, r *http.Request) {
 			wsError(wsConn, logger, errors.Wrap(err, "communication via WebSocket failed"), websocket.CloseAbnormalClosure)
 			return
 
+		case <-tokenCh:
+			logger.Debug("Token change acknowledged")
+			if err := sess.checkToken(trcConn); err != nil {
+				srv.invalidateSession(sess)
+				wsError(wsConn, logger, err, CloseTokenRevoked)
+				return
+			}
+
+		case <-matchCh:
+			logger.Debug("Match change acknowledged")
+			if err := sendState(); err != nil {
+				wsError(wsConn, logger, err, websocket.CloseInternalServerErr)
+				return
+			}
+
+		case <-infoCh:
+			logger.Debug("Turtle info change acknowledged")
+			if err := sendState(); err != nil {
+				wsError(wsConn, logger, err, websocket.CloseInternalServerErr)
+				return
+			}
+
 		case <-changeCh:
 			logger.Debug("State change acknowledged")
 
-			st := trcConn.State(ctx)
+			st := srv.state(ctx, trcConn)
 			// TODO: Compute diff of st and oldState
-			_ = oldState
-
-			diff := st
+			diff := withRemovals(oldState, st)
 			if diff == nil {
 				continue
 			}
 			oldState = st
 
-			if err := wsConn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
-				wsError(wsConn, logger, errors.Wrap(err, "failed to set write deadline"), websocket.CloseInternalServerErr)
-				return
-			}
-
 			logger.Debug("Sending state diff on the WebSocket...", zap.Reflect("state", diff))
-			if err := wsConn.WriteJSON(diff); err != nil {
+			if err := writeJSON(wsConn, diff); err != nil {
 				wsError(wsConn, logger, errors.Wrap(err, "failed to write state"), websocket.CloseInternalServerErr)
 				return
 			}
 
 		case <-time.After(pingInterval):
-			if err := wsConn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
-				wsError(wsConn, logger, errors.Wrap(err, "failed to set write deadline"), websocket.CloseInternalServerErr)
+			if err := writePing(wsConn); err != nil {
+				wsError(wsConn, logger, errors.Wrap(err, "failed to write ping"), websocket.CloseInternalServerErr)
 				return
 			}
+		}
+	}
+}
 
-			if err := wsConn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				wsError(wsConn, logger, errors.Wrap(err, "failed to write ping"), websocket.CloseInternalServerErr)
+// makeFeedHandler returns a handler of requests to FeedEndpoint, which combines
+// the state feeds of TRC's managed by srvs.
+// The initial message on the WebSocket must be a JSON object mapping TRC names to session keys.
+// Only the TRC's present in the initial message are included in the feed.
+// If the connection to a TRC is lost, it is excluded from the feed and the feed is only closed once no TRC's remain.
+func makeFeedHandler(srvs map[string]*server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		logger := logcontext.Logger(ctx)
+
+		var keys map[string]string
+		wsConn, err := upgrade(w, r, logger, &keys)
+		if err != nil {
+			return
+		}
+		defer wsConn.Close()
+
+		if len(keys) == 0 {
+			wsError(wsConn, logger, errAuthenticateFirst, websocket.ClosePolicyViolation)
+			return
+		}
+
+		updateCh := make(chan string)
+		failCh := make(chan error, len(keys))
+		lostCh := make(chan FeedUpdate, len(keys))
+
+		// lastStates holds the last state sent for each TRC.
+		lastStates := make(map[string]*State, len(keys))
+		for name, key := range keys {
+			logger := logger.With(zap.String("trc", name))
+
+			srv, ok := srvs[name]
+			if !ok {
+				wsError(wsConn, logger, errors.Errorf("unknown TRC: %s", name), websocket.CloseInvalidFramePayloadData)
+				return
+			}
+
+			sess, code, err := srv.acquireSession(key)
+			if err != nil {
+				wsError(wsConn, logger, errors.Wrapf(err, "failed to acquire session of TRC %s", name), code)
+				return
+			}
+			defer srv.releaseSession(sess)
+
+			logger.Debug("Retrieving a connection from pool...")
+			trcConn, err := srv.pool.Conn()
+			if err != nil {
+				wsError(wsConn, logger, errors.Wrapf(err, "failed to establish connection to TRC %s", name), websocket.CloseInternalServerErr)
+				return
+			}
+
+			logger.Debug("Subscribing to state changes...")
+			changeCh, closeFn, err := trcConn.SubscribeStateChanges(ctx)
+			if err != nil {
+				wsError(wsConn, logger, errors.Wrapf(err, "failed to subscribe to state changes of TRC %s", name), websocket.CloseInternalServerErr)
+				return
+			}
+			defer closeFn()
+
+			logger.Debug("Subscribing to token changes...")
+			tokenCh, closeTokenFn, err := trcConn.SubscribeTokenChanges(ctx)
+			if err != nil {
+				wsError(wsConn, logger, errors.Wrapf(err, "failed to subscribe to token changes of TRC %s", name), websocket.CloseInternalServerErr)
 				return
 			}
+			defer closeTokenFn()
+
+			if err := sess.checkToken(trcConn); err != nil {
+				srv.invalidateSession(sess)
+				wsError(wsConn, logger, errors.Wrapf(err, "invalid session of TRC %s", name), CloseTokenRevoked)
+				return
+			}
+
+			matchCh, closeMatchFn := srv.match.Subscribe()
+			defer closeMatchFn()
+
+			infoCh, closeInfoFn := srv.info.Subscribe()
+			defer closeInfoFn()
+
+			st := srv.state(ctx, trcConn)
+			logger.Debug("Sending current state on the WebSocket...", zap.Reflect("state", st))
+			if err := writeJSON(wsConn, &FeedUpdate{TRC: name, State: st}); err != nil {
+				wsError(wsConn, logger, errors.Wrap(err, "failed to write state"), websocket.CloseInternalServerErr)
+				return
+			}
+			lastStates[name] = st
+
+			go func(name string, srv *server, sess *session, trcConn *trcapi.Conn) {
+				for {
+					select {
+					case <-ctx.Done():
+						return
+
+					case <-trcConn.Closed():
+						lostCh <- FeedUpdate{TRC: name, Error: "connection is closed"}
+						return
+
+					case <-trcConn.Errors():
+						trcConn.Close()
+						lostCh <- FeedUpdate{TRC: name, Error: "communication failed"}
+						return
+
+					case _, ok := <-tokenCh:
+						if !ok {
+							lostCh <- FeedUpdate{TRC: name, Error: "connection is closed"}
+							return
+						}
+						if err := sess.checkToken(trcConn); err != nil {
+							srv.invalidateSession(sess)
+							failCh <- errors.Wrapf(err, "invalid session of TRC %s", name)
+							return
+						}
+
+					case _, ok := <-changeCh:
+						if !ok {
+							lostCh <- FeedUpdate{TRC: name, Error: "connection is closed"}
+							return
+						}
+
+						select {
+						case updateCh <- name:
+						case <-ctx.Done():
+							return
+						}
+
+					case <-matchCh:
+						select {
+						case updateCh <- name:
+						case <-ctx.Done():
+							return
+						}
+
+					case <-infoCh:
+						select {
+						case updateCh <- name:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}
+			}(name, srv, sess, trcConn)
+		}
+
+		errCh, err := readErrors(wsConn)
+		if err != nil {
+			wsError(wsConn, logger, err, websocket.CloseInternalServerErr)
+			return
+		}
+
+		// exclude excludes the TRC of upd from the feed and notifies the client.
+		// exclude closes the WebSocket and returns false if the feed cannot continue.
+		exclude := func(upd *FeedUpdate) bool {
+			if _, ok := lastStates[upd.TRC]; !ok {
+				return true
+			}
+			logger := logger.With(zap.String("trc", upd.TRC))
+
+			logger.Warn("Excluding TRC from the feed", zap.String("reason", upd.Error))
+			delete(lastStates, upd.TRC)
+			if err := writeJSON(wsConn, upd); err != nil {
+				wsError(wsConn, logger, errors.Wrap(err, "failed to write update"), websocket.CloseInternalServerErr)
+				return false
+			}
+			if len(lastStates) == 0 {
+				wsError(wsConn, logger, errors.New("connections to all TRC's are lost"), websocket.CloseInternalServerErr)
+				return false
+			}
+			return true
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				wsError(wsConn, logger, errors.New("context done"), websocket.CloseInvalidFramePayloadData)
+				return
+
+			case err := <-failCh:
+				code := websocket.CloseInternalServerErr
+				if errors.Cause(err) == errTokenRevoked {
+					code = CloseTokenRevoked
+				}
+				wsError(wsConn, logger, err, code)
+				return
+
+			case err := <-errCh:
+				wsError(wsConn, logger, errors.Wrap(err, "communication via WebSocket failed"), websocket.CloseAbnormalClosure)
+				return
+
+			case upd := <-lostCh:
+				if !exclude(&upd) {
+					return
+				}
+
+			case name := <-updateCh:
+				logger := logger.With(zap.String("trc", name))
+
+				if _, ok := lastStates[name]; !ok {
+					continue
+				}
+
+				srv := srvs[name]
+				trcConn, err := srv.pool.Conn()
+				if err != nil {
+					logger.Warn("Failed to establish connection to TRC", zap.Error(err))
+					if !exclude(&FeedUpdate{TRC: name, Error: "failed to establish connection"}) {
+						return
+					}
+					continue
+				}
+
+				st := srv.state(ctx, trcConn)
+				diff := withRemovals(lastStates[name], st)
+				lastStates[name] = st
+
+				logger.Debug("Sending state on the WebSocket...", zap.Reflect("state", diff))
+				if err := writeJSON(wsConn, &FeedUpdate{TRC: name, State: diff}); err != nil {
+					wsError(wsConn, logger, errors.Wrap(err, "failed to write state"), websocket.CloseInternalServerErr)
+					return
+				}
+
+			case <-time.After(pingInterval):
+				if err := writePing(wsConn); err != nil {
+					wsError(wsConn, logger, errors.Wrap(err, "failed to write ping"), websocket.CloseInternalServerErr)
+					return
+				}
+			}
 		}
 	}
 }
@@ -314,8 +955,31 @@ func (srv *server) handleAuth(w http.ResponseWriter, r *http.Request) {
 
 	logger.Debug("Creating new session")
 	srv.session = &session{
-		key: key,
+		key:   key,
+		token: trcTok,
+	}
+}
+
+// authenticate checks the session key in r and writes an error to w if it does not match the current session.
+// authenticate reports whether the request is authenticated.
+// srv.sessionMu must be held by the caller.
+func (srv *server) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	if srv.session == nil {
+		http.Error(w, errAuthenticateFirst.Error(), http.StatusMethodNotAllowed)
+		return false
+	}
+
+	_, key, ok := r.BasicAuth()
+	if !ok && srv.session.key != "" {
+		http.Error(w, errAuthorizationHeader.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	if key != srv.session.key {
+		http.Error(w, errInvalidSessionKey.Error(), http.StatusUnauthorized)
+		return false
 	}
+	return true
 }
 
 func (srv *server) makeTRCSendHandler(f func(context.Context, *trcapi.Conn, *json.Decoder) error) http.HandlerFunc {
@@ -329,22 +993,18 @@ func (srv *server) makeTRCSendHandler(f func(context.Context, *trcapi.Conn, *jso
 			return
 		}
 
+		// revoked is the session to invalidate once sessionMu is released.
+		var revoked *session
+		defer func() {
+			if revoked != nil {
+				srv.invalidateSession(revoked)
+			}
+		}()
+
 		srv.sessionMu.RLock()
 		defer srv.sessionMu.RUnlock()
 
-		_, key, ok := r.BasicAuth()
-		if !ok && srv.session.key != "" {
-			http.Error(w, errAuthorizationHeader.Error(), http.StatusBadRequest)
-			return
-		}
-
-		switch {
-		case srv.session == nil:
-			http.Error(w, errAuthenticateFirst.Error(), http.StatusMethodNotAllowed)
-			return
-
-		case key != srv.session.key:
-			http.Error(w, errInvalidSessionKey.Error(), http.StatusUnauthorized)
+		if !srv.authenticate(w, r) {
 			return
 		}
 
@@ -355,50 +1015,171 @@ func (srv *server) makeTRCSendHandler(f func(context.Context, *trcapi.Conn, *jso
 			return
 		}
 
+		if err := srv.session.checkToken(trcConn); err != nil {
+			if errors.Cause(err) == errTokenRevoked {
+				revoked = srv.session
+			}
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
 		dec := json.NewDecoder(r.Body)
 		dec.DisallowUnknownFields()
 
 		if err := f(ctx, trcConn, dec); err != nil {
-			http.Error(w, errors.Wrap(err, "failed to process request").Error(), http.StatusBadRequest)
+			httpError(w, errors.Wrap(err, "failed to process request"), http.StatusBadRequest)
 			return
 		}
 	}
 }
 
-// HandleFuncer allows registration of a handler function for a specified pattern.
-// An example implementation of this interface is *http.ServeMux.
-type HandleFuncer interface {
-	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+// handleMatch handles requests to MatchEndpoint.
+// The match is tracked by SRRS, hence updates are accepted regardless of whether TRC is connected.
+func (srv *server) handleMatch(w http.ResponseWriter, r *http.Request) {
+	logger := logcontext.Logger(r.Context())
+
+	if r.Method != "POST" {
+		http.Error(w, errors.Errorf("expected a POST request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	srv.sessionMu.RLock()
+	defer srv.sessionMu.RUnlock()
+
+	if !srv.authenticate(w, r) {
+		return
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var u api.MatchUpdate
+	if err := dec.Decode(&u); err != nil {
+		http.Error(w, errors.Wrap(err, "failed to decode request body").Error(), http.StatusBadRequest)
+		return
+	}
+	if err := u.Validate(); err != nil {
+		httpError(w, errors.Wrap(err, "invalid match update"), http.StatusBadRequest)
+		return
+	}
+
+	logger.Info("Received match update", zap.Reflect("update", u))
+	srv.match.Update(&u)
 }
 
-// Register endpoints registers webapi endpoints on handler.
-func RegisterHandlers(pool *trcapi.Pool, handler HandleFuncer) {
-	var stopTimerMu sync.Mutex
-	stopTimer := time.AfterFunc(420 /* blaze it */, func() {
-		trcConn, err := pool.Conn()
-		if err != nil {
-			zap.L().Error("Failed to establish connection to TRC", zap.Error(err))
+// handleTurtleInfo handles requests to TurtleInfoEndpoint.
+// GET requests return the information about all registered turtles,
+// POST requests update it, where turtles with null information are removed from the registry.
+func (srv *server) handleTurtleInfo(w http.ResponseWriter, r *http.Request) {
+	logger := logcontext.Logger(r.Context())
+
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, errors.Errorf("expected a GET or POST request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	srv.sessionMu.RLock()
+	defer srv.sessionMu.RUnlock()
+
+	if !srv.authenticate(w, r) {
+		return
+	}
+
+	if r.Method == "POST" {
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+
+		var upd map[string]*api.TurtleInfo
+		if err := dec.Decode(&upd); err != nil {
+			http.Error(w, errors.Wrap(err, "failed to decode request body").Error(), http.StatusBadRequest)
 			return
 		}
-		defer trcConn.Close()
 
-		if err := trcConn.SetCommand(context.Background(), api.CommandStop); err != nil {
-			zap.L().Error("Failed to stop TRC", zap.Error(err))
+		logger.Info("Received turtle info", zap.Reflect("info", upd))
+		if err := srv.info.Update(upd); err != nil {
+			httpError(w, errors.Wrap(err, "failed to update turtle info"), http.StatusBadRequest)
+			return
 		}
-	})
-	stopTimer.Stop()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(srv.info.List()); err != nil {
+		logger.Error("Failed to write turtle info", zap.Error(err))
+	}
+}
+
+// handleCommands handles requests to CommandsEndpoint.
+func handleCommands(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, errors.Errorf("expected a GET request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(api.ListCommands()); err != nil {
+		logcontext.Logger(r.Context()).Error("Failed to write commands", zap.Error(err))
+	}
+}
 
-	activeConns := 0
+// handleSchema handles requests to SchemaEndpoint and the documents nested under it.
+func handleSchema(w http.ResponseWriter, r *http.Request) {
+	logger := logcontext.Logger(r.Context())
 
-	s := &server{
-		pool: pool,
+	if r.Method != "GET" {
+		http.Error(w, errors.Errorf("expected a GET request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	if p := strings.TrimSuffix(r.URL.Path, "/"); p == "/"+SchemaEndpoint {
+		names := make([]string, 0, len(schema.Documents))
+		for _, doc := range schema.Documents {
+			names = append(names, doc.Name)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(names); err != nil {
+			logger.Error("Failed to write schema names", zap.Error(err))
+		}
+		return
+	}
+
+	name := strings.TrimSuffix(path.Base(r.URL.Path), ".json")
+	v, ok := schema.Lookup(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
 	}
+
+	b, err := schema.Marshal(v)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "failed to generate schema").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	if _, err := w.Write(b); err != nil {
+		logger.Error("Failed to write schema", zap.Error(err))
+	}
+}
+
+// HandleFuncer allows registration of a handler function for a specified pattern.
+// An example implementation of this interface is *http.ServeMux.
+type HandleFuncer interface {
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+// register registers the endpoints managed by srv on handler.
+// Endpoint paths are obtained by applying scope to AuthEndpoint, StateEndpoint, CommandEndpoint, TurtleEndpoint,
+// MatchEndpoint and TurtleInfoEndpoint.
+func (srv *server) register(handler HandleFuncer, scope func(string) string) {
 	for ep, f := range map[string]http.HandlerFunc{
-		"/" + AuthEndpoint: s.handleAuth,
+		AuthEndpoint: srv.handleAuth,
 
-		"/" + StateEndpoint: s.handleState,
+		StateEndpoint: srv.handleState,
 
-		"/" + CommandEndpoint: s.makeTRCSendHandler(func(ctx context.Context, trcConn *trcapi.Conn, dec *json.Decoder) error {
+		TurtleInfoEndpoint: srv.handleTurtleInfo,
+
+		CommandEndpoint: srv.makeTRCSendHandler(func(ctx context.Context, trcConn *trcapi.Conn, dec *json.Decoder) error {
 			var cmd api.Command
 			if err := dec.Decode(&cmd); err != nil {
 				return errors.Wrap(err, "failed to decode request body")
@@ -408,13 +1189,27 @@ func RegisterHandlers(pool *trcapi.Pool, handler HandleFuncer) {
 			}
 
 			zap.L().Info("Received command", zap.String("command", string(cmd)))
+
+			srv.commandMu.Lock()
+			defer srv.commandMu.Unlock()
+
+			if err := srv.match.CheckCommand(cmd); err != nil {
+				if !srv.advisory {
+					return err
+				}
+				zap.L().Warn("Sending command not allowed in current phase", zap.Error(err))
+			}
+
 			if err := trcConn.SetCommand(ctx, cmd); err != nil {
 				return errors.Wrap(err, "failed to send command to TRC")
 			}
+			srv.match.HandleCommand(cmd)
 			return nil
 		}),
 
-		"/" + TurtleEndpoint: s.makeTRCSendHandler(func(ctx context.Context, trcConn *trcapi.Conn, dec *json.Decoder) error {
+		MatchEndpoint: srv.handleMatch,
+
+		TurtleEndpoint: srv.makeTRCSendHandler(func(ctx context.Context, trcConn *trcapi.Conn, dec *json.Decoder) error {
 
 			var st map[string]*api.TurtleState
 			if err := dec.Decode(&st); err != nil {
@@ -424,6 +1219,17 @@ func RegisterHandlers(pool *trcapi.Pool, handler HandleFuncer) {
 				return nil
 			}
 
+			errs := api.AsValidationErrors(api.ValidateWritable(st))
+			errs = append(errs, api.AsValidationErrors((&api.State{Turtles: st}).ValidateOn(srv.field))...)
+			if len(errs) > 0 {
+				return errors.Wrap(errs, "invalid turtle state")
+			}
+			if srv.strictTeam {
+				if err := checkTeam(trcConn.State(ctx), st); err != nil {
+					return errors.Wrap(err, "turtle state violates team consistency rules")
+				}
+			}
+
 			zap.L().Info("Received turtle state", zap.Reflect("state", st))
 			if err := trcConn.SetTurtleState(ctx, st); err != nil {
 				return errors.Wrap(err, "failed to send turtle state to TRC")
@@ -431,21 +1237,52 @@ func RegisterHandlers(pool *trcapi.Pool, handler HandleFuncer) {
 			return nil
 		}),
 	} {
-		hdl := f
-		handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
-			stopTimerMu.Lock()
-			activeConns++
-			stopTimer.Stop()
-			stopTimerMu.Unlock()
-
-			hdl(w, r)
-
-			stopTimerMu.Lock()
-			activeConns--
-			if activeConns == 0 {
-				stopTimer.Reset(inactivityTimeout)
-			}
-			stopTimerMu.Unlock()
-		})
+		handler.HandleFunc("/"+scope(ep), track(f, srv))
 	}
 }
+
+// RegisterHandlers registers webapi endpoints of the TRC managed by pool on handler.
+func RegisterHandlers(pool *trcapi.Pool, handler HandleFuncer, opts ...Option) {
+	newServer("", pool, opts...).register(handler, func(ep string) string { return ep })
+	handler.HandleFunc("/"+CommandsEndpoint, handleCommands)
+	handler.HandleFunc("/"+SchemaEndpoint, handleSchema)
+	handler.HandleFunc("/"+SchemaEndpoint+"/", handleSchema)
+}
+
+// RegisterRegistryHandlers registers webapi endpoints of every TRC in reg on handler.
+// The endpoints of each TRC are scoped by its name, see ScopedEndpoint.
+// RegisterRegistryHandlers additionally registers TRCEndpoint, FeedEndpoint, CommandsEndpoint and SchemaEndpoint.
+func RegisterRegistryHandlers(reg *trcapi.Registry, handler HandleFuncer, opts ...Option) {
+	names := reg.ListPoolNames()
+
+	srvs := make(map[string]*server, len(names))
+	all := make([]*server, 0, len(names))
+	for _, name := range names {
+		pool, ok := reg.GetPool(name)
+		if !ok {
+			panic(errors.Errorf("TRC %s not found in registry", name))
+		}
+
+		name := name
+		srv := newServer(name, pool, opts...)
+		srv.register(handler, func(ep string) string { return ScopedEndpoint(name, ep) })
+		srvs[name] = srv
+		all = append(all, srv)
+	}
+
+	handler.HandleFunc("/"+TRCEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, errors.Errorf("expected a GET request, got %s", r.Method).Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(names); err != nil {
+			logcontext.Logger(r.Context()).Error("Failed to write TRC names", zap.Error(err))
+		}
+	})
+	handler.HandleFunc("/"+FeedEndpoint, track(makeFeedHandler(srvs), all...))
+	handler.HandleFunc("/"+CommandsEndpoint, handleCommands)
+	handler.HandleFunc("/"+SchemaEndpoint, handleSchema)
+	handler.HandleFunc("/"+SchemaEndpoint+"/", handleSchema)
+}
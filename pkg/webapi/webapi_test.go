@@ -0,0 +1,519 @@
+package webapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	"github.com/rvolosatovs/turtlitto/pkg/srrstest"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/turtleinfo"
+	. "github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func newEnv(t *testing.T, opts ...srrstest.Option) *srrstest.Env {
+	env, err := srrstest.New(opts...)
+	if err != nil {
+		t.Fatalf("Failed to start SRRS: %s", err)
+	}
+	return env
+}
+
+//Test_items: handleAuth(), authenticate() in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestAuth(t *testing.T) {
+	a := assert.New(t)
+
+	env := newEnv(t)
+	defer env.Close()
+
+	err := env.Client.Auth("wrong")
+	a.Equal(http.StatusUnauthorized, srrstest.StatusCode(err))
+
+	err = env.Client.SendCommand(api.CommandStop)
+	a.Equal(http.StatusMethodNotAllowed, srrstest.StatusCode(err))
+
+	a.NoError(env.Client.Auth(env.Token()))
+	a.NotEmpty(env.Client.SessionKey())
+	a.NoError(env.Client.SendCommand(api.CommandStop))
+}
+
+//Test_items: handleState() in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestState(t *testing.T) {
+	a := assert.New(t)
+
+	env := newEnv(t)
+	defer env.Close()
+
+	sc := env.AuthAndOpenState(t)
+	defer sc.Close()
+
+	a.Len(sc.State().Turtles, len(api.DefaultRoster))
+
+	a.NoError(env.TRC().SendState(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"1": {BatteryVoltage: apitest.Uint8Ptr(21)},
+		},
+	}))
+	st := env.ExpectStateWithin(t, sc, srrstest.DefaultTimeout, func(st *State) bool {
+		return st.Turtles["1"] != nil && st.Turtles["1"].BatteryVoltage != nil
+	})
+	a.Equal(uint8(21), *st.Turtles["1"].BatteryVoltage)
+
+	_, err := env.Client.OpenState()
+	a.Error(err)
+}
+
+//Test_items: CommandEndpoint, TurtleEndpoint handlers in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestSend(t *testing.T) {
+	a := assert.New(t)
+
+	env := newEnv(t)
+	defer env.Close()
+
+	a.NoError(env.Client.Auth(env.Token()))
+
+	a.NoError(env.Client.SendCommand(api.CommandKickOffCyan))
+	env.ExpectTRCReceived(t, api.MessageTypeState, func(msg *api.Message) bool {
+		var st api.State
+		return json.Unmarshal(msg.Payload, &st) == nil && st.Command == api.CommandKickOffCyan
+	})
+
+	err := env.Client.SendCommand(api.CommandCornerCyan)
+	a.Equal(http.StatusBadRequest, srrstest.StatusCode(err))
+
+	a.NoError(env.Client.SetTurtles(map[string]*api.TurtleState{
+		"2": {HomeGoal: api.HomeGoalYellow},
+	}))
+	env.ExpectTRCReceived(t, api.MessageTypeState, func(msg *api.Message) bool {
+		var st api.State
+		return json.Unmarshal(msg.Payload, &st) == nil && st.Turtles["2"] != nil && st.Turtles["2"].HomeGoal == api.HomeGoalYellow
+	})
+
+	err = env.Client.SetTurtles(map[string]*api.TurtleState{
+		"2": {BatteryVoltage: apitest.Uint8Ptr(21)},
+	})
+	a.Equal(http.StatusBadRequest, srrstest.StatusCode(err))
+}
+
+//Test_items: handleState(), CloseTokenRevoked in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestTokenRevoked(t *testing.T) {
+	a := assert.New(t)
+
+	env := newEnv(t)
+	defer env.Close()
+
+	sc := env.AuthAndOpenState(t)
+	defer sc.Close()
+
+	a.NoError(env.TRC().SendToken("new"))
+	env.ExpectTRCReceived(t, api.MessageTypeToken, nil)
+
+	var err error
+	for err == nil {
+		_, err = sc.Next(srrstest.DefaultTimeout)
+	}
+	a.True(websocket.IsCloseError(err, CloseTokenRevoked), "unexpected error: %s", err)
+
+	err = env.Client.SendCommand(api.CommandStop)
+	a.Equal(http.StatusMethodNotAllowed, srrstest.StatusCode(err))
+
+	err = env.Client.Auth(env.Token())
+	a.Equal(http.StatusUnauthorized, srrstest.StatusCode(err))
+
+	a.NoError(env.Client.Auth("new"))
+	a.NoError(env.Client.SendCommand(api.CommandStop))
+}
+
+//Test_items: makeTRCSendHandler() in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestTokenRevokedREST(t *testing.T) {
+	a := assert.New(t)
+
+	env := newEnv(t)
+	defer env.Close()
+
+	a.NoError(env.Client.Auth(env.Token()))
+
+	a.NoError(env.TRC().SendToken("new"))
+	env.ExpectTRCReceived(t, api.MessageTypeToken, nil)
+
+	err := env.Client.SendCommand(api.CommandStop)
+	a.Equal(http.StatusUnauthorized, srrstest.StatusCode(err))
+
+	err = env.Client.SendCommand(api.CommandStop)
+	a.Equal(http.StatusMethodNotAllowed, srrstest.StatusCode(err))
+
+	a.NoError(env.Client.Auth("new"))
+	a.NoError(env.Client.SendCommand(api.CommandStop))
+}
+
+//Test_items: handleTurtleInfo(), handleState() in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestTurtleInfo(t *testing.T) {
+	a := assert.New(t)
+
+	env := newEnv(t)
+	defer env.Close()
+
+	sc := env.AuthAndOpenState(t)
+	defer sc.Close()
+
+	a.NotNil(sc.State().TurtleInfo)
+
+	raphael := &api.TurtleInfo{Name: "Raphael", Serial: "TU-0003"}
+	_, err := env.Client.UpdateTurtleInfo(map[string]*api.TurtleInfo{"3": raphael})
+	a.NoError(err)
+	st := env.ExpectStateWithin(t, sc, srrstest.DefaultTimeout, func(st *State) bool {
+		return len(st.TurtleInfo) == 1
+	})
+	a.Equal(raphael, st.TurtleInfo["3"])
+
+	_, err = env.Client.UpdateTurtleInfo(map[string]*api.TurtleInfo{"3": nil})
+	a.NoError(err)
+	env.ExpectStateWithin(t, sc, srrstest.DefaultTimeout, func(st *State) bool {
+		return st.TurtleInfo != nil && len(st.TurtleInfo) == 0
+	})
+}
+
+//Test_items: RegisterRegistryHandlers(), WithTRCOptions() in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestRegistryTurtleInfo(t *testing.T) {
+	a := assert.New(t)
+
+	magenta := newEnv(t)
+	defer magenta.Close()
+
+	cyan := newEnv(t)
+	defer cyan.Close()
+
+	reg := trcapi.NewRegistry()
+	a.NoError(reg.AddPool("magenta", magenta.Pool))
+	a.NoError(reg.AddPool("cyan", cyan.Pool))
+
+	magentaInfo, cyanInfo := turtleinfo.New(), turtleinfo.New()
+
+	mux := http.NewServeMux()
+	RegisterRegistryHandlers(reg, mux,
+		WithTRCOptions("magenta", WithTurtleInfo(magentaInfo)),
+		WithTRCOptions("cyan", WithTurtleInfo(cyanInfo)),
+	)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	magentaCl := srrstest.NewClient(srv.URL)
+	magentaCl.TRC = "magenta"
+	a.NoError(magentaCl.Auth(magenta.Token()))
+
+	cyanCl := srrstest.NewClient(srv.URL)
+	cyanCl.TRC = "cyan"
+	a.NoError(cyanCl.Auth(cyan.Token()))
+
+	raphael := &api.TurtleInfo{Name: "Raphael"}
+	_, err := magentaCl.UpdateTurtleInfo(map[string]*api.TurtleInfo{"3": raphael})
+	a.NoError(err)
+
+	infos, err := cyanCl.TurtleInfo()
+	a.NoError(err)
+	a.Empty(infos)
+
+	a.Equal(map[string]*api.TurtleInfo{"3": raphael}, magentaInfo.List())
+	a.Empty(cyanInfo.List())
+}
+
+//Test_items: HandleCommand() in match.go, handleMatch() in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestMatch(t *testing.T) {
+	a := assert.New(t)
+
+	env := newEnv(t)
+	defer env.Close()
+
+	// down specifies whether TRC is unreachable, it is accessed atomically.
+	var down int32
+	pool := trcapi.NewPool(func() (*trcapi.Conn, func(), error) {
+		if atomic.LoadInt32(&down) == 1 {
+			return nil, nil, errors.New("TRC is down")
+		}
+		conn, err := env.Pool.Conn()
+		return conn, nil, err
+	})
+
+	mux := http.NewServeMux()
+	RegisterHandlers(pool, mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cl := srrstest.NewClient(srv.URL)
+	a.NoError(cl.Auth(env.Token()))
+
+	sc, err := cl.OpenState()
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	a.NoError(cl.SendCommand(api.CommandPassDemo))
+	st := env.ExpectStateWithin(t, sc, srrstest.DefaultTimeout, func(st *State) bool {
+		return st.Match != nil && st.Match.Phase == api.PhaseRunning
+	})
+	a.False(st.Match.ClockRunning, "demos must not start the clock")
+
+	a.NoError(cl.SendCommand(api.CommandStop))
+	env.ExpectStateWithin(t, sc, srrstest.DefaultTimeout, func(st *State) bool {
+		return st.Match != nil && st.Match.Phase == api.PhaseStopped
+	})
+
+	a.NoError(cl.SendCommand(api.CommandStart))
+	st = env.ExpectStateWithin(t, sc, srrstest.DefaultTimeout, func(st *State) bool {
+		return st.Match != nil && st.Match.Phase == api.PhaseRunning
+	})
+	a.True(st.Match.ClockRunning)
+	a.NoError(sc.Close())
+
+	// The match can be updated while TRC is unreachable.
+	atomic.StoreInt32(&down, 1)
+	a.NoError(env.Pool.Close())
+	a.Error(cl.SendCommand(api.CommandStop))
+
+	score := &api.Score{Magenta: 1}
+	a.NoError(cl.UpdateMatch(&api.MatchUpdate{Score: score}))
+
+	atomic.StoreInt32(&down, 0)
+	sc, err = cl.OpenState()
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer sc.Close()
+
+	a.Equal(*score, sc.State().Match.Score)
+}
+
+//Test_items: checkTeam(), WithStrictTeamValidation(), TurtleEndpoint handler in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestStrictTeamValidation(t *testing.T) {
+	a := assert.New(t)
+
+	env := newEnv(t, srrstest.WithWebAPIOptions(WithStrictTeamValidation()))
+	defer env.Close()
+
+	sc := env.AuthAndOpenState(t)
+	defer sc.Close()
+
+	a.Empty(sc.State().Warnings)
+
+	a.NoError(env.Client.SetTurtles(map[string]*api.TurtleState{
+		"1": {Role: api.RoleGoalkeeper, TeamColor: api.TeamColorMagenta},
+	}))
+	env.ExpectStateWithin(t, sc, srrstest.DefaultTimeout, func(st *State) bool {
+		return st.Turtles["1"] != nil && st.Turtles["1"].Role == api.RoleGoalkeeper
+	})
+
+	err := env.Client.SetTurtles(map[string]*api.TurtleState{
+		"2": {Role: api.RoleGoalkeeper},
+	})
+	a.Equal(http.StatusBadRequest, srrstest.StatusCode(err))
+	if serr, ok := err.(*srrstest.StatusError); a.True(ok) && a.Len(serr.Violations, 1) {
+		a.Equal(`turtles["2"].role`, serr.Violations[0].Path)
+		a.Equal(api.ValidationReasonDuplicate, serr.Violations[0].Reason)
+	}
+
+	a.NoError(env.TRC().SendState(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"3": {Role: api.RoleAttackerMain, TeamColor: api.TeamColorCyan},
+		},
+	}))
+	st := env.ExpectStateWithin(t, sc, srrstest.DefaultTimeout, func(st *State) bool {
+		return len(st.Warnings) > 0
+	})
+	if a.Len(st.Warnings, 1) {
+		a.Equal(`turtles["3"].teamcolor`, st.Warnings[0].Path)
+		a.Equal(api.ValidationReasonInconsistent, st.Warnings[0].Reason)
+	}
+
+	// Violations present before the update do not cause it to be rejected.
+	a.NoError(env.Client.SetTurtles(map[string]*api.TurtleState{
+		"4": {Role: api.RoleDefenderMain},
+	}))
+	env.ExpectStateWithin(t, sc, srrstest.DefaultTimeout, func(st *State) bool {
+		return st.Turtles["4"] != nil && st.Turtles["4"].Role == api.RoleDefenderMain && len(st.Warnings) == 1
+	})
+
+	a.NoError(env.Client.SetTurtles(map[string]*api.TurtleState{
+		"3": {TeamColor: api.TeamColorMagenta},
+	}))
+	env.ExpectStateWithin(t, sc, srrstest.DefaultTimeout, func(st *State) bool {
+		return len(st.Warnings) == 0
+	})
+}
+
+// expectFeed reads updates from the feed WebSocket wsConn until one satisfies pred and returns it.
+// expectFeed fails the test if that does not happen within srrstest.DefaultTimeout.
+func expectFeed(t *testing.T, wsConn *websocket.Conn, pred func(*FeedUpdate) bool) *FeedUpdate {
+	t.Helper()
+
+	if err := wsConn.SetReadDeadline(time.Now().Add(srrstest.DefaultTimeout)); err != nil {
+		t.Fatalf("Failed to set read deadline: %s", err)
+	}
+	for {
+		var upd FeedUpdate
+		if err := wsConn.ReadJSON(&upd); err != nil {
+			t.Fatalf("Feed update not matched: %s", err)
+		}
+		if pred(&upd) {
+			return &upd
+		}
+	}
+}
+
+//Test_items: makeFeedHandler(), RegisterRegistryHandlers() in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestFeed(t *testing.T) {
+	a := assert.New(t)
+
+	magenta := newEnv(t)
+	defer magenta.Close()
+
+	cyan := newEnv(t)
+	defer cyan.Close()
+
+	reg := trcapi.NewRegistry()
+	a.NoError(reg.AddPool("magenta", magenta.Pool))
+	a.NoError(reg.AddPool("cyan", cyan.Pool))
+
+	mux := http.NewServeMux()
+	RegisterRegistryHandlers(reg, mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/" + TRCEndpoint)
+	if a.NoError(err) {
+		var names []string
+		a.NoError(json.NewDecoder(resp.Body).Decode(&names))
+		resp.Body.Close()
+		a.Equal([]string{"cyan", "magenta"}, names)
+	}
+
+	keys := map[string]string{}
+	for name, env := range map[string]*srrstest.Env{"magenta": magenta, "cyan": cyan} {
+		cl := srrstest.NewClient(srv.URL)
+		cl.TRC = name
+		a.NoError(cl.Auth(env.Token()))
+		keys[name] = cl.SessionKey()
+	}
+
+	wsConn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/"+FeedEndpoint, nil)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer wsConn.Close()
+	a.NoError(wsConn.WriteJSON(keys))
+
+	initial := map[string]bool{}
+	for len(initial) < 2 {
+		upd := expectFeed(t, wsConn, func(*FeedUpdate) bool { return true })
+		a.NotNil(upd.State)
+		initial[upd.TRC] = true
+	}
+	a.Equal(map[string]bool{"magenta": true, "cyan": true}, initial)
+
+	a.NoError(cyan.TRC().SendState(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"1": {BatteryVoltage: apitest.Uint8Ptr(21)},
+			"2": {BatteryVoltage: apitest.Uint8Ptr(22)},
+		},
+	}))
+	upd := expectFeed(t, wsConn, func(upd *FeedUpdate) bool {
+		return upd.State != nil && upd.State.Turtles["2"] != nil && upd.State.Turtles["2"].BatteryVoltage != nil
+	})
+	a.Equal("cyan", upd.TRC)
+	a.Equal(apitest.Uint8Ptr(21), upd.State.Turtles["1"].BatteryVoltage)
+
+	a.NoError(magenta.TRC().SendState(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"5": {BatteryVoltage: apitest.Uint8Ptr(25)},
+		},
+	}))
+	upd = expectFeed(t, wsConn, func(upd *FeedUpdate) bool {
+		return upd.State != nil && upd.State.Turtles["5"] != nil && upd.State.Turtles["5"].BatteryVoltage != nil
+	})
+	a.Equal("magenta", upd.TRC)
+	if a.NotNil(upd.State.Turtles["1"]) {
+		a.Nil(upd.State.Turtles["1"].BatteryVoltage)
+	}
+
+	a.NoError(cyan.TRC().SendState(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"2": nil,
+		},
+	}))
+	upd = expectFeed(t, wsConn, func(upd *FeedUpdate) bool {
+		if upd.State == nil {
+			return false
+		}
+		ts, ok := upd.State.Turtles["2"]
+		return ok && ts == nil
+	})
+	a.Equal("cyan", upd.TRC)
+	a.NotNil(upd.State.Turtles["1"])
+
+	// Losing the connection to a single TRC does not close the feed.
+	magentaConn, err := magenta.Pool.Conn()
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(magentaConn.Close())
+	upd = expectFeed(t, wsConn, func(upd *FeedUpdate) bool {
+		return upd.Error != ""
+	})
+	a.Equal("magenta", upd.TRC)
+	a.Nil(upd.State)
+
+	a.NoError(cyan.TRC().SendState(&api.State{
+		Command: api.CommandStop,
+	}))
+	upd = expectFeed(t, wsConn, func(upd *FeedUpdate) bool {
+		return upd.State != nil && upd.State.Command == api.CommandStop
+	})
+	a.Equal("cyan", upd.TRC)
+
+	a.NoError(cyan.TRC().SendToken("new"))
+	cyan.ExpectTRCReceived(t, api.MessageTypeToken, nil)
+
+	for err == nil {
+		var upd FeedUpdate
+		err = wsConn.ReadJSON(&upd)
+	}
+	a.True(websocket.IsCloseError(err, CloseTokenRevoked), "unexpected error: %s", err)
+}